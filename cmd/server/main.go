@@ -1,146 +1,359 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/IcarusCore/Requestarr/internal/cache"
 	"github.com/IcarusCore/Requestarr/internal/handlers"
 	"github.com/IcarusCore/Requestarr/internal/models"
 	"github.com/IcarusCore/Requestarr/internal/services"
+	"github.com/IcarusCore/Requestarr/internal/version"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/rs/cors"
+	"golang.org/x/crypto/bcrypt"
 )
 
 //go:embed frontend/static/*
 var staticFiles embed.FS
 
 func main() {
+	initLogger()
+
 	// Get configuration from environment
 	port := getEnv("PORT", "5000")
 	dbPath := getEnv("DB_PATH", "/config/requestarrr.db")
 	adminPassword := getEnv("ADMIN_PASSWORD", "admin")
 	secretKey := getEnv("SECRET_KEY", "change-me-in-production-please")
+	pollInterval := getPollInterval()
+	shutdownTimeout := getShutdownTimeout()
+	redisURL := os.Getenv("REDIS_URL")
+	cachePersistPath := os.Getenv("CACHE_PERSIST_PATH")
+	corsOrigins := getCORSOrigins()
+	baseURL := getBaseURL()
+	trustedProxies := getTrustedProxies()
 
 	// Initialize database
 	db, err := models.InitDB(dbPath)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", "error", err)
 	}
-	defer db.Close()
 
 	// Initialize default settings from environment
 	initDefaultSettings(db)
 
-	// Initialize cache with 10-minute TTL
-	appCache := cache.NewCache(10 * time.Minute)
+	// Create the bootstrap admin account on first run
+	if err := initAdminUser(db, adminPassword); err != nil {
+		fatal("Failed to create admin user", "error", err)
+	}
+
+	// Initialize cache with 10-minute TTL, in Redis when configured and
+	// otherwise in memory with at most 10k items, keeping slow-changing
+	// lookups longer
+	var appCache cache.CacheStore
+	var memCache *cache.Cache
+	if redisURL != "" {
+		redisCache, err := cache.NewRedisCache(redisURL, 10*time.Minute)
+		if err != nil {
+			fatal("Failed to connect to Redis", "error", err)
+		}
+		appCache = redisCache
+	} else {
+		memCache = cache.NewCache(10*time.Minute, 10000, cachePersistPath)
+		appCache = memCache
+	}
+	appCache.SetPolicy("tmdb_movie_", 7*24*time.Hour)
+	appCache.SetPolicy("tmdb_tv_", 7*24*time.Hour)
+	appCache.SetPolicy("ratings_", 6*time.Hour)
 
 	// Initialize services
-	tmdbService := services.NewTMDBService(db, appCache)
-	sonarrService := services.NewSonarrService(db)
-	radarrService := services.NewRadarrService(db)
+	sonarrService := services.NewSonarrService(db, appCache)
+	radarrService := services.NewRadarrService(db, appCache, "radarr")
+	radarr4kService := services.NewRadarrService(db, appCache, "radarr_4k")
+	tmdbService := services.NewTMDBService(db, appCache, sonarrService, radarrService, radarr4kService)
+	traktService := services.NewTraktService(db, appCache, tmdbService)
 	ratingsService := services.NewRatingsService(db, appCache)
 	notificationService := services.NewNotificationService(db)
+	plexService := services.NewPlexService(db, appCache)
 
 	// Initialize session store
 	sessionStore := sessions.NewCookieStore([]byte(secretKey))
 	sessionStore.Options = &sessions.Options{
-		Path:     "/",
+		Path:     baseURL + "/",
 		MaxAge:   86400 * 7, // 7 days
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 	}
 
-	// Initialize handlers
-	h := handlers.NewHandler(db, sessionStore, adminPassword, tmdbService, sonarrService, radarrService, ratingsService, notificationService, appCache)
+	// Process-level configuration, reported by the effective config endpoint
+	runtimeConfig := []handlers.ConfigEntry{
+		{Key: "PORT", Value: port, Source: envSource("PORT")},
+		{Key: "DB_PATH", Value: dbPath, Source: envSource("DB_PATH")},
+		{Key: "ADMIN_PASSWORD", Value: adminPassword, Source: envSource("ADMIN_PASSWORD"), Credential: true},
+		{Key: "SECRET_KEY", Value: secretKey, Source: envSource("SECRET_KEY"), Credential: true},
+		{Key: "POLL_INTERVAL_MINUTES", Value: strconv.Itoa(int(pollInterval.Minutes())), Source: envSource("POLL_INTERVAL_MINUTES")},
+		{Key: "SHUTDOWN_TIMEOUT_SECONDS", Value: strconv.Itoa(int(shutdownTimeout.Seconds())), Source: envSource("SHUTDOWN_TIMEOUT_SECONDS")},
+		{Key: "CACHE_PERSIST_PATH", Value: cachePersistPath, Source: envSource("CACHE_PERSIST_PATH")},
+		{Key: "REDIS_URL", Value: redisURL, Source: envSource("REDIS_URL"), Secret: true},
+		{Key: "BASE_URL", Value: baseURL, Source: envSource("BASE_URL")},
+		{Key: "CORS_ORIGINS", Value: strings.Join(corsOrigins, ","), Source: envSource("CORS_ORIGINS")},
+		{Key: "TRUSTED_PROXIES", Value: os.Getenv("TRUSTED_PROXIES"), Source: envSource("TRUSTED_PROXIES")},
+	}
 
-	// Setup router
-	r := mux.NewRouter()
+	// Initialize handlers
+	handlers.SetTrustedProxies(trustedProxies)
+	h := handlers.NewHandler(db, sessionStore, secretKey, tmdbService, traktService, sonarrService, radarrService, radarr4kService, ratingsService, plexService, notificationService, appCache, runtimeConfig)
+
+	// Setup router, mounting everything under BASE_URL when running behind a
+	// reverse proxy subpath
+	root := mux.NewRouter()
+	root.Use(handlers.AccessLog)
+	r := root
+	if baseURL != "" {
+		root.Handle(baseURL, http.RedirectHandler(baseURL+"/", http.StatusMovedPermanently))
+		r = root.PathPrefix(baseURL).Subrouter()
+	}
 
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
 
 	// Health & Status
 	api.HandleFunc("/health", h.HealthCheck).Methods("GET")
+	api.HandleFunc("/health/detailed", h.AdminRequired(h.DetailedHealth)).Methods("GET")
+	api.HandleFunc("/version", h.GetVersion).Methods("GET")
 	api.HandleFunc("/services/status", h.ServicesStatus).Methods("GET")
 	api.HandleFunc("/stats", h.GetStats).Methods("GET")
+	api.HandleFunc("/stats/top", h.GetTopRequested).Methods("GET")
+	api.HandleFunc("/stats/daily", h.GetDailyStats).Methods("GET")
+	api.HandleFunc("/stats/requesters", h.AdminRequired(h.GetRequesterStats)).Methods("GET")
+	api.HandleFunc("/config", h.GetConfig).Methods("GET")
 
 	// Discovery
 	api.HandleFunc("/discover/series", h.DiscoverSeries).Methods("GET")
 	api.HandleFunc("/discover/movies", h.DiscoverMovies).Methods("GET")
+	api.HandleFunc("/discover/trending", h.DiscoverTrending).Methods("GET")
+	api.HandleFunc("/discover/trakt", h.DiscoverTrakt).Methods("GET")
+	api.HandleFunc("/watch-providers", h.GetWatchProviders).Methods("GET")
+
+	// Media
+	api.HandleFunc("/media/{type:movie|tv}/{id:[0-9]+}", h.GetMediaDetail).Methods("GET")
+	api.HandleFunc("/media/{type:movie|tv}/{id:[0-9]+}/recommendations", h.GetRecommendations).Methods("GET")
+	api.HandleFunc("/media/series/{tvdbId:[0-9]+}/seasons", h.GetSeasonAvailability).Methods("GET")
+	api.HandleFunc("/collection/{id:[0-9]+}", h.GetCollection).Methods("GET")
 
 	// Search
 	api.HandleFunc("/search/series", h.SearchSeries).Methods("GET")
 	api.HandleFunc("/search/movies", h.SearchMovies).Methods("GET")
+	api.HandleFunc("/search/person", h.SearchPerson).Methods("GET")
+	api.HandleFunc("/search/multi", h.SearchMulti).Methods("GET")
+	api.HandleFunc("/lookup", h.LookupByID).Methods("GET")
 	api.HandleFunc("/search", h.SearchSeries).Methods("GET") // Alias
+	api.HandleFunc("/person/{id:[0-9]+}/credits", h.GetPersonCredits).Methods("GET")
 
 	// Ratings
 	api.HandleFunc("/ratings", h.GetRatings).Methods("GET")
 
 	// Requests
 	api.HandleFunc("/request", h.CreateRequest).Methods("POST")
+	api.HandleFunc("/request/collection", h.RequestCollection).Methods("POST")
 	api.HandleFunc("/requests", h.GetRequests).Methods("GET")
+	api.HandleFunc("/requests/feed.xml", h.RequestsFeed).Methods("GET")
+	api.HandleFunc("/requests/mine", h.GetMyRequests).Methods("GET")
+	api.HandleFunc("/requests/search", h.SearchRequests).Methods("GET")
 	api.HandleFunc("/requests/{id:[0-9]+}", h.GetRequest).Methods("GET")
+	api.HandleFunc("/requests/{id:[0-9]+}", h.AdminRequired(h.DeleteRequest)).Methods("DELETE")
 	api.HandleFunc("/requests/{id:[0-9]+}/status", h.AdminRequired(h.UpdateRequestStatus)).Methods("PUT")
 	api.HandleFunc("/requests/{id:[0-9]+}/approve", h.AdminRequired(h.ApproveRequest)).Methods("POST")
+	api.HandleFunc("/requests/{id:[0-9]+}/reject", h.AdminRequired(h.RejectRequest)).Methods("POST")
+	api.HandleFunc("/requests/{id:[0-9]+}/refresh", h.AdminRequired(h.RefreshRequest)).Methods("POST")
+	api.HandleFunc("/requests/{id:[0-9]+}/upgrade", h.RequestUpgrade).Methods("POST")
+	api.HandleFunc("/requests/{id:[0-9]+}/comments", h.GetComments).Methods("GET")
+	api.HandleFunc("/requests/{id:[0-9]+}/comments", h.AddComment).Methods("POST")
+	api.HandleFunc("/requests/{id:[0-9]+}/issue", h.ReportIssue).Methods("POST")
+
+	// Issues
+	api.HandleFunc("/issues", h.AdminRequired(h.GetIssues)).Methods("GET")
+	api.HandleFunc("/issues/{id:[0-9]+}/resolve", h.AdminRequired(h.ResolveIssue)).Methods("POST")
 
 	// Admin
 	api.HandleFunc("/admin/check", h.AdminCheck).Methods("GET")
 	api.HandleFunc("/admin/login", h.AdminLogin).Methods("POST")
 	api.HandleFunc("/admin/logout", h.AdminLogout).Methods("POST")
+	api.HandleFunc("/admin/password", h.AdminRequired(h.ChangePassword)).Methods("POST")
+	api.HandleFunc("/admin/2fa/enable", h.AdminRequired(h.EnableTwoFactor)).Methods("POST")
+	api.HandleFunc("/admin/2fa/verify", h.AdminRequired(h.VerifyTwoFactor)).Methods("POST")
+	api.HandleFunc("/admin/2fa/disable", h.AdminRequired(h.DisableTwoFactor)).Methods("POST")
 	api.HandleFunc("/admin/settings", h.AdminRequired(h.GetAdminSettings)).Methods("GET")
 	api.HandleFunc("/admin/settings", h.AdminRequired(h.UpdateAdminSettings)).Methods("PUT")
 	api.HandleFunc("/admin/test-connection", h.AdminRequired(h.TestConnection)).Methods("POST")
+	api.HandleFunc("/admin/test-notification", h.AdminRequired(h.TestNotification)).Methods("POST")
 	api.HandleFunc("/admin/activity", h.AdminRequired(h.GetActivity)).Methods("GET")
+	api.HandleFunc("/admin/activity", h.AdminRequired(h.ClearActivity)).Methods("DELETE")
+	api.HandleFunc("/admin/import/overseerr", h.AdminRequired(h.ImportOverseerr)).Methods("POST")
+	api.HandleFunc("/admin/config/effective", h.AdminRequired(h.GetEffectiveConfig)).Methods("GET")
+	api.HandleFunc("/admin/search", h.AdminRequired(h.AdminSearch)).Methods("GET")
+
+	// Plex sign-in
+	api.HandleFunc("/auth/plex/start", h.PlexAuthStart).Methods("POST")
+	api.HandleFunc("/auth/plex/poll", h.PlexAuthPoll).Methods("GET")
+
+	// Users
+	api.HandleFunc("/users", h.AdminRequired(h.GetUsers)).Methods("GET")
+	api.HandleFunc("/users", h.AdminRequired(h.CreateUser)).Methods("POST")
+	api.HandleFunc("/users/{id:[0-9]+}", h.AdminRequired(h.UpdateUser)).Methods("PUT")
+	api.HandleFunc("/users/{id:[0-9]+}", h.AdminRequired(h.DeleteUser)).Methods("DELETE")
+
+	// API keys
+	api.HandleFunc("/api-keys", h.AdminRequired(h.GetAPIKeys)).Methods("GET")
+	api.HandleFunc("/api-keys", h.AdminRequired(h.CreateAPIKey)).Methods("POST")
+	api.HandleFunc("/api-keys/{id:[0-9]+}", h.AdminRequired(h.DeleteAPIKey)).Methods("DELETE")
+
+	// Webhooks
+	api.HandleFunc("/webhooks/sonarr", h.SonarrWebhook).Methods("POST")
+	api.HandleFunc("/webhooks/radarr", h.RadarrWebhook).Methods("POST")
 
 	// Serve static files
 	staticFS, err := fs.Sub(staticFiles, "frontend/static")
 	if err != nil {
-		log.Fatalf("Failed to get static files: %v", err)
+		fatal("Failed to get static files", "error", err)
 	}
 	
-	// Serve index.html for root path
+	// Serve index.html for root path, with the base URL filled in for API calls
+	index, err := fs.ReadFile(staticFS, "index.html")
+	if err != nil {
+		fatal("Failed to read index.html", "error", err)
+	}
+	index = bytes.ReplaceAll(index, []byte("__BASE_URL__"), []byte(baseURL))
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		data, err := fs.ReadFile(staticFS, "index.html")
-		if err != nil {
-			http.Error(w, "Not found", http.StatusNotFound)
-			return
-		}
 		w.Header().Set("Content-Type", "text/html")
-		w.Write(data)
+		w.Write(index)
 	})
 	
 	// Serve other static files
-	r.PathPrefix("/").Handler(http.FileServer(http.FS(staticFS)))
-
-	// Setup CORS
-	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-	})
+	r.PathPrefix("/").Handler(http.StripPrefix(baseURL, http.FileServer(http.FS(staticFS))))
+
+	// Setup CORS for the configured origins only, the web UI is served from
+	// the same origin and doesn't need it
+	var handler http.Handler = root
+	if len(corsOrigins) > 0 {
+		c := cors.New(cors.Options{
+			AllowedOrigins:   corsOrigins,
+			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"*"},
+			AllowCredentials: true,
+		})
+		handler = c.Handler(root)
+	}
 
-	// Start background task for checking completed downloads
-	go startBackgroundTasks(db, sonarrService, radarrService, notificationService)
+	// Stop on SIGINT/SIGTERM, a second signal exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reconcile approvals interrupted by a crash, then start checking completed downloads
+	var workers sync.WaitGroup
+	workers.Add(4)
+	go func() {
+		defer workers.Done()
+		recoverInterruptedApprovals(ctx, db, sonarrService, radarrService, radarr4kService)
+	}()
+	go func() {
+		defer workers.Done()
+		startBackgroundTasks(ctx, db, h, pollInterval)
+	}()
+	go func() {
+		defer workers.Done()
+		startNotificationWorker(ctx, notificationService)
+	}()
+	go func() {
+		defer workers.Done()
+		startWatchlistSync(ctx, h, pollInterval)
+	}()
 
 	// Start server
-	handler := c.Handler(r)
 	addr := fmt.Sprintf(":%s", port)
 	
-	log.Printf("🚀 Requestarrr starting on http://0.0.0.0%s", addr)
-	log.Printf("📁 Database: %s", dbPath)
+	slog.Info("Requestarr starting", "version", version.Version, "commit", version.Commit, "url", fmt.Sprintf("http://0.0.0.0%s%s/", addr, baseURL), "database", dbPath, "poll_interval", pollInterval.String())
 	
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("Server failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down, waiting for requests and background tasks", "timeout", shutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Failed to finish in-flight requests", "error", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		h.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		slog.Warn("Background tasks did not finish before the shutdown timeout")
+	}
+
+	if memCache != nil && cachePersistPath != "" {
+		if err := memCache.Save(); err != nil {
+			slog.Error("Failed to save cache", "error", err)
+		} else {
+			slog.Info("Saved cache for next start", "path", cachePersistPath)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+	slog.Info("Stopped")
+}
+
+// initLogger sets up the default logger from LOG_LEVEL (debug, info, warn or
+// error) and LOG_FORMAT (text or json)
+func initLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
 	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -150,79 +363,292 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func initDefaultSettings(db *models.DB) {
-	defaults := map[string]string{
-		"sonarr_url":      os.Getenv("SONARR_URL"),
-		"sonarr_api_key":  os.Getenv("SONARR_API_KEY"),
-		"radarr_url":      os.Getenv("RADARR_URL"),
-		"radarr_api_key":  os.Getenv("RADARR_API_KEY"),
-		"discord_webhook": os.Getenv("DISCORD_WEBHOOK"),
-		"ntfy_url":        os.Getenv("NTFY_URL"),
-		"ntfy_topic":      os.Getenv("NTFY_TOPIC"),
-		"tmdb_api_key":    os.Getenv("TMDB_API_KEY"),
-		"mdblist_api_key": os.Getenv("MDBLIST_API_KEY"),
+// getPollInterval reads POLL_INTERVAL_MINUTES, defaulting to 15 minutes and
+// never polling more than once a minute
+func getPollInterval() time.Duration {
+	minutes := 15
+	if value := os.Getenv("POLL_INTERVAL_MINUTES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			slog.Warn("Invalid POLL_INTERVAL_MINUTES, using default", "value", value, "default", minutes)
+		} else {
+			minutes = parsed
+		}
+	}
+	if minutes < 1 {
+		minutes = 1
 	}
+	return time.Duration(minutes) * time.Minute
+}
 
-	for key, value := range defaults {
-		if value != "" {
-			db.SetSettingIfNotExists(key, value)
+// getShutdownTimeout reads SHUTDOWN_TIMEOUT_SECONDS, how long to wait for
+// in-flight requests and background tasks when stopping, defaulting to 30
+func getShutdownTimeout() time.Duration {
+	seconds := 30
+	if value := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			slog.Warn("Invalid SHUTDOWN_TIMEOUT_SECONDS, using default", "value", value, "default", seconds)
+		} else {
+			seconds = parsed
 		}
 	}
+	return time.Duration(seconds) * time.Second
 }
 
-func startBackgroundTasks(db *models.DB, sonarr *services.SonarrService, radarr *services.RadarrService, notify *services.NotificationService) {
-	ticker := time.NewTicker(15 * time.Minute)
-	defer ticker.Stop()
+// getCORSOrigins reads the comma-separated CORS_ORIGINS. A wildcard isn't
+// allowed since cross-origin requests carry the session cookie.
+func getCORSOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("CORS_ORIGINS"), ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			fatal("CORS_ORIGINS cannot be *, list the allowed origins instead")
+		}
+		origins = append(origins, origin)
+	}
+	return origins
+}
+
+// getTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of IP
+// addresses and CIDR ranges of reverse proxies
+func getTrustedProxies() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				fatal("Invalid TRUSTED_PROXIES entry", "entry", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			fatal("Invalid TRUSTED_PROXIES entry", "entry", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// getBaseURL reads BASE_URL as a path prefix with a leading slash and no
+// trailing slash, "" when served from the root
+func getBaseURL() string {
+	baseURL := strings.Trim(strings.TrimSpace(os.Getenv("BASE_URL")), "/")
+	if baseURL == "" {
+		return ""
+	}
+	return "/" + baseURL
+}
+
+func envSource(key string) string {
+	if os.Getenv(key) != "" {
+		return "env"
+	}
+	return "default"
+}
 
-	for range ticker.C {
-		checkCompletedDownloads(db, sonarr, radarr, notify)
+func initDefaultSettings(db *models.DB) {
+	defaults := map[string]string{
+		"sonarr_url":         os.Getenv("SONARR_URL"),
+		"sonarr_api_key":     os.Getenv("SONARR_API_KEY"),
+		"radarr_url":         os.Getenv("RADARR_URL"),
+		"radarr_api_key":     os.Getenv("RADARR_API_KEY"),
+		"radarr_4k_url":      os.Getenv("RADARR_4K_URL"),
+		"radarr_4k_api_key":  os.Getenv("RADARR_4K_API_KEY"),
+		"discord_webhook":    os.Getenv("DISCORD_WEBHOOK"),
+		"ntfy_url":           os.Getenv("NTFY_URL"),
+		"ntfy_topic":         os.Getenv("NTFY_TOPIC"),
+		"telegram_bot_token": os.Getenv("TELEGRAM_BOT_TOKEN"),
+		"telegram_chat_id":   os.Getenv("TELEGRAM_CHAT_ID"),
+		"tmdb_api_key":       os.Getenv("TMDB_API_KEY"),
+		"mdblist_api_key":    os.Getenv("MDBLIST_API_KEY"),
+		"trakt_client_id":    os.Getenv("TRAKT_CLIENT_ID"),
+		"webhook_token":      os.Getenv("WEBHOOK_TOKEN"),
+	}
+
+	for key, value := range defaults {
+		if value != "" {
+			db.SetSettingIfNotExists(key, value, "env")
+		}
 	}
 }
 
-func checkCompletedDownloads(db *models.DB, sonarr *services.SonarrService, radarr *services.RadarrService, notify *services.NotificationService) {
-	requests, err := db.GetApprovedRequests()
+// recoverInterruptedApprovals marks pending requests that are already in
+// Sonarr/Radarr as approved. This happens when the server stops between
+// adding to the arr and updating the request.
+func recoverInterruptedApprovals(ctx context.Context, db *models.DB, sonarr *services.SonarrService, radarr, radarr4k *services.RadarrService) {
+	requests, _, err := db.GetRequests("pending", "", 0, 0)
 	if err != nil {
-		log.Printf("Error getting approved requests: %v", err)
+		slog.Error("Failed to get pending requests", "error", err)
+		return
+	}
+	if len(requests) == 0 {
 		return
 	}
 
+	var seriesIDs, movieIDs, movie4kIDs map[int]int
 	for _, req := range requests {
-		if req.ArrID == nil {
-			continue
-		}
+		var arrID int
+		var found bool
 
-		var completed bool
 		if req.MediaType == "series" {
-			series, err := sonarr.GetSeries(*req.ArrID)
-			if err == nil && series != nil {
-				if stats, ok := series["statistics"].(map[string]interface{}); ok {
-					if count, ok := stats["episodeFileCount"].(float64); ok && count > 0 {
-						completed = true
-					}
-				}
+			if req.TvdbID == nil {
+				continue
+			}
+			if seriesIDs == nil {
+				seriesIDs = arrIDsByKey(ctx, sonarr.GetExisting, "tvdbId")
 			}
+			arrID, found = seriesIDs[*req.TvdbID]
+		} else if req.Is4K {
+			if req.TmdbID == nil {
+				continue
+			}
+			if movie4kIDs == nil {
+				movie4kIDs = arrIDsByKey(ctx, radarr4k.GetExisting, "tmdbId")
+			}
+			arrID, found = movie4kIDs[*req.TmdbID]
 		} else {
-			movie, err := radarr.GetMovie(*req.ArrID)
-			if err == nil && movie != nil {
-				if hasFile, ok := movie["hasFile"].(bool); ok && hasFile {
-					completed = true
-				}
+			if req.TmdbID == nil {
+				continue
 			}
+			if movieIDs == nil {
+				movieIDs = arrIDsByKey(ctx, radarr.GetExisting, "tmdbId")
+			}
+			arrID, found = movieIDs[*req.TmdbID]
 		}
 
-		if completed {
-			db.UpdateRequestStatus(req.ID, "completed", "")
-			db.LogActivity("request_completed", map[string]interface{}{
-				"request_id": req.ID,
-				"title":      req.Title,
-			})
-			
-			emoji := "🎉"
-			mediaWord := "Movie"
-			if req.MediaType == "series" {
-				mediaWord = "Series"
-			}
-			notify.Send(fmt.Sprintf("%s %s Ready", emoji, mediaWord), fmt.Sprintf("**%s** is now available to watch!", req.Title), "")
+		if !found {
+			continue
+		}
+
+		db.UpdateRequestStatus(req.ID, "approved", "")
+		db.UpdateRequestArrID(req.ID, arrID)
+		db.LogActivity("request_recovered", map[string]interface{}{
+			"request_id": req.ID,
+			"title":      req.Title,
+			"arr_id":     arrID,
+		})
+		slog.Info("Recovered interrupted approval", "request_id", req.ID, "title", req.Title)
+	}
+}
+
+// arrIDsByKey maps an external id field of the arr library to the arr's own id
+func arrIDsByKey(ctx context.Context, getExisting func(context.Context) ([]map[string]interface{}, error), key string) map[int]int {
+	ids := make(map[int]int)
+
+	existing, err := getExisting(ctx)
+	if err != nil {
+		slog.Error("Failed to get library for recovery", "error", err)
+		return ids
+	}
+
+	for _, item := range existing {
+		externalID, ok := item[key].(float64)
+		if !ok {
+			continue
+		}
+		if id, ok := item["id"].(float64); ok {
+			ids[int(externalID)] = int(id)
+		}
+	}
+	return ids
+}
+
+func initAdminUser(db *models.DB, password string) error {
+	count, err := db.CountUsers("")
+	if err != nil || count > 0 {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.CreateUser("admin", string(hash), "admin", false); err != nil {
+		return err
+	}
+	slog.Info("Created admin user from ADMIN_PASSWORD")
+	return nil
+}
+
+func startBackgroundTasks(ctx context.Context, db *models.DB, h *handlers.Handler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.CheckCompletedDownloads(ctx)
+			pruneActivity(db)
+		}
+	}
+}
+
+// startWatchlistSync requests the Plex watchlists of Plex users every
+// interval, while plex_watchlist_sync is enabled
+func startWatchlistSync(ctx context.Context, h *handlers.Handler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.SyncPlexWatchlists(ctx)
 		}
 	}
 }
+
+// pruneActivity deletes activity older than activity_retention_days, 0
+// keeps everything
+func pruneActivity(db *models.DB) {
+	days := db.GetSettingInt("activity_retention_days", models.DefaultActivityRetentionDays)
+	if days <= 0 {
+		return
+	}
+
+	deleted, err := db.PruneActivity(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		slog.Error("Failed to prune activity log", "error", err)
+		return
+	}
+	if deleted > 0 {
+		slog.Info("Pruned activity log", "deleted", deleted, "retention_days", days)
+	}
+}
+
+// startNotificationWorker retries queued notifications every minute, and
+// once more when shutting down
+func startNotificationWorker(ctx context.Context, notify *services.NotificationService) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The last pass runs after ctx is cancelled, the shutdown
+			// timeout still bounds it
+			notify.ProcessQueue(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			notify.ProcessQueue(ctx)
+		}
+	}
+}
+
@@ -1,74 +1,238 @@
 package cache
 
 import (
+	"container/list"
+	"encoding/gob"
+	"log/slog"
+	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
+func init() {
+	// Generic shapes stored by the services, registered so values can be
+	// gob-encoded for Redis and disk persistence; their own types register
+	// themselves
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register([]map[string]interface{}{})
+	gob.Register(map[int]bool{})
+}
+
+// CacheStore is implemented by the in-memory Cache and RedisCache
+type CacheStore interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	SetWithTTL(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+	SetPolicy(prefix string, ttl time.Duration)
+}
+
+// ttlPolicies maps key prefixes to TTLs used by Set
+type ttlPolicies map[string]time.Duration
+
+// ttlFor returns the TTL of the longest prefix matching key, or def
+func (p ttlPolicies) ttlFor(key string, def time.Duration) time.Duration {
+	ttl := def
+	longest := -1
+	for prefix, policyTTL := range p {
+		if len(prefix) > longest && strings.HasPrefix(key, prefix) {
+			ttl = policyTTL
+			longest = len(prefix)
+		}
+	}
+	return ttl
+}
+
 type item struct {
+	key        string
 	value      interface{}
 	expiration time.Time
 }
 
 type Cache struct {
-	items map[string]item
-	mu    sync.RWMutex
-	ttl   time.Duration
+	items    map[string]*list.Element
+	lru      *list.List // front is most recently used
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxItems int
+	policies ttlPolicies
+	path     string
 }
 
-func NewCache(ttl time.Duration) *Cache {
+// persistedItem is the on-disk form of a cache entry
+type persistedItem struct {
+	Key        string
+	Value      interface{}
+	Expiration time.Time
+}
+
+// NewCache creates a cache with a default TTL, evicting the least recently
+// used entry once it holds maxItems (0 for no limit). If persistPath is set,
+// entries saved there by Save are loaded back.
+func NewCache(ttl time.Duration, maxItems int, persistPath string) *Cache {
 	c := &Cache{
-		items: make(map[string]item),
-		ttl:   ttl,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+		ttl:      ttl,
+		maxItems: maxItems,
+		policies: make(ttlPolicies),
+		path:     persistPath,
 	}
-	
+
+	if persistPath != "" {
+		if err := c.load(); err != nil {
+			slog.Warn("Failed to load cache", "path", persistPath, "error", err)
+		}
+	}
+
 	// Start cleanup goroutine
 	go c.cleanup()
-	
+
 	return c
 }
 
 func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	item, found := c.items[key]
+	el, found := c.items[key]
 	if !found {
 		return nil, false
 	}
 
-	if time.Now().After(item.expiration) {
+	entry := el.Value.(*item)
+	if time.Now().After(entry.expiration) {
 		return nil, false
 	}
 
-	return item.value, true
+	c.lru.MoveToFront(el)
+	return entry.value, true
+}
+
+// SetPolicy sets the TTL used by Set for keys starting with prefix
+func (c *Cache) SetPolicy(prefix string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policies[prefix] = ttl
 }
 
 func (c *Cache) Set(key string, value interface{}) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items[key] = item{
-		value:      value,
-		expiration: time.Now().Add(c.ttl),
-	}
+	c.set(key, value, c.policies.ttlFor(key, c.ttl))
 }
 
 func (c *Cache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.items[key] = item{
-		value:      value,
-		expiration: time.Now().Add(ttl),
+	c.set(key, value, ttl)
+}
+
+func (c *Cache) set(key string, value interface{}, ttl time.Duration) {
+	expiration := time.Now().Add(ttl)
+
+	if el, found := c.items[key]; found {
+		entry := el.Value.(*item)
+		entry.value = value
+		entry.expiration = expiration
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	if c.maxItems > 0 && c.lru.Len() >= c.maxItems {
+		if oldest := c.lru.Back(); oldest != nil {
+			c.remove(oldest)
+		}
 	}
+
+	c.items[key] = c.lru.PushFront(&item{key: key, value: value, expiration: expiration})
 }
 
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	delete(c.items, key)
+	if el, found := c.items[key]; found {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of cached items, including expired ones not yet
+// cleaned up
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Save writes the unexpired entries to the persist path, least recently
+// used first so loading restores their order
+func (c *Cache) Save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	entries := make([]persistedItem, 0, c.lru.Len())
+	for el := c.lru.Back(); el != nil; el = el.Prev() {
+		entry := el.Value.(*item)
+		if now.Before(entry.expiration) {
+			entries = append(entries, persistedItem{entry.key, entry.value, entry.expiration})
+		}
+	}
+	c.mu.Unlock()
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(entries); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+func (c *Cache) load() error {
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var entries []persistedItem
+	if err := gob.NewDecoder(f).Decode(&entries); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if now.Before(e.Expiration) {
+			c.set(e.Key, e.Value, e.Expiration.Sub(now))
+		}
+	}
+	return nil
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*item).key)
 }
 
 func (c *Cache) cleanup() {
@@ -78,9 +242,9 @@ func (c *Cache) cleanup() {
 	for range ticker.C {
 		c.mu.Lock()
 		now := time.Now()
-		for key, item := range c.items {
-			if now.After(item.expiration) {
-				delete(c.items, key)
+		for _, el := range c.items {
+			if now.After(el.Value.(*item).expiration) {
+				c.remove(el)
 			}
 		}
 		c.mu.Unlock()
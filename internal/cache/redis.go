@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisKeyPrefix = "requestarr:"
+	redisTimeout   = 2 * time.Second
+)
+
+// RedisCache stores gob-encoded values in Redis so the cache can be shared
+// between instances and survive restarts
+type RedisCache struct {
+	client   *redis.Client
+	ttl      time.Duration
+	mu       sync.RWMutex
+	policies ttlPolicies
+}
+
+// entry wraps cached values so gob records their concrete type
+type entry struct {
+	Value interface{}
+}
+
+// NewRedisCache connects to the Redis server at url (redis://...) and checks
+// that it is reachable
+func NewRedisCache(url string, ttl time.Duration) (*RedisCache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("Redis not reachable: %w", err)
+	}
+
+	return &RedisCache{
+		client:   client,
+		ttl:      ttl,
+		policies: make(ttlPolicies),
+	}, nil
+}
+
+// Ping checks that Redis is still reachable
+func (c *RedisCache) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *RedisCache) Get(key string) (interface{}, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, redisKeyPrefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Warn("Redis get failed", "key", key, "error", err)
+		}
+		return nil, false
+	}
+
+	var e entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		slog.Warn("Redis decode failed", "key", key, "error", err)
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// SetPolicy sets the TTL used by Set for keys starting with prefix
+func (c *RedisCache) SetPolicy(prefix string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policies[prefix] = ttl
+}
+
+func (c *RedisCache) Set(key string, value interface{}) {
+	c.mu.RLock()
+	ttl := c.policies.ttlFor(key, c.ttl)
+	c.mu.RUnlock()
+
+	c.SetWithTTL(key, value, ttl)
+}
+
+func (c *RedisCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry{value}); err != nil {
+		slog.Warn("Redis encode failed", "key", key, "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := c.client.Set(ctx, redisKeyPrefix+key, buf.Bytes(), ttl).Err(); err != nil {
+		slog.Warn("Redis set failed", "key", key, "error", err)
+	}
+}
+
+func (c *RedisCache) Delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := c.client.Del(ctx, redisKeyPrefix+key).Err(); err != nil {
+		slog.Warn("Redis delete failed", "key", key, "error", err)
+	}
+}
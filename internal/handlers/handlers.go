@@ -1,51 +1,117 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/IcarusCore/Requestarr/internal/auth"
 	"github.com/IcarusCore/Requestarr/internal/cache"
 	"github.com/IcarusCore/Requestarr/internal/models"
 	"github.com/IcarusCore/Requestarr/internal/services"
+	"github.com/IcarusCore/Requestarr/internal/version"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Handler struct {
 	db            *models.DB
 	store         *sessions.CookieStore
-	adminPassword string
+	secretKey     string
 	tmdb          *services.TMDBService
+	trakt         *services.TraktService
 	sonarr        *services.SonarrService
 	radarr        *services.RadarrService
+	radarr4k      *services.RadarrService
 	ratings       *services.RatingsService
+	plex          *services.PlexService
 	notify        *services.NotificationService
-	cache         *cache.Cache
+	cache         cache.CacheStore
+	runtimeConfig []ConfigEntry
+	logins        *loginLimiter
+	approvals     *approvalLocks
+	tasks         sync.WaitGroup
 }
 
-func NewHandler(db *models.DB, store *sessions.CookieStore, adminPassword string, tmdb *services.TMDBService, sonarr *services.SonarrService, radarr *services.RadarrService, ratings *services.RatingsService, notify *services.NotificationService, cache *cache.Cache) *Handler {
+// ConfigEntry is a resolved configuration value and where it came from
+// (env, db, or default)
+type ConfigEntry struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	EnvSet bool   `json:"envSet,omitempty"`
+	Secret bool   `json:"-"`
+	// Credentials only report whether they were set, not even masked
+	Credential bool  `json:"-"`
+	Configured *bool `json:"configured,omitempty"`
+}
+
+func NewHandler(db *models.DB, store *sessions.CookieStore, secretKey string, tmdb *services.TMDBService, trakt *services.TraktService, sonarr *services.SonarrService, radarr *services.RadarrService, radarr4k *services.RadarrService, ratings *services.RatingsService, plex *services.PlexService, notify *services.NotificationService, cache cache.CacheStore, runtimeConfig []ConfigEntry) *Handler {
 	return &Handler{
 		db:            db,
 		store:         store,
-		adminPassword: adminPassword,
+		secretKey:     secretKey,
 		tmdb:          tmdb,
+		trakt:         trakt,
 		sonarr:        sonarr,
 		radarr:        radarr,
+		radarr4k:      radarr4k,
 		ratings:       ratings,
+		plex:          plex,
 		notify:        notify,
 		cache:         cache,
+		runtimeConfig: runtimeConfig,
+		logins:        &loginLimiter{failures: make(map[string]*loginFailures)},
+		approvals:     &approvalLocks{inFlight: make(map[int]bool)},
 	}
 }
 
+// goBackground runs fn without holding up the response. fn gets ctx
+// without its cancellation, so it finishes even when the client hangs up.
+func (h *Handler) goBackground(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		fn(ctx)
+	}()
+}
+
+// Wait blocks until the work handlers started in the background is done
+func (h *Handler) Wait() {
+	h.tasks.Wait()
+}
+
 func (h *Handler) jsonResponse(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
 
 func (h *Handler) errorResponse(w http.ResponseWriter, message string, status int) {
+	if status >= http.StatusInternalServerError {
+		slog.Error("Request failed", "status", status, "error", message)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(map[string]string{"error": message})
@@ -54,8 +120,7 @@ func (h *Handler) errorResponse(w http.ResponseWriter, message string, status in
 // Middleware
 func (h *Handler) AdminRequired(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session, _ := h.store.Get(r, "session")
-		if session.Values["is_admin"] != true {
+		if _, role := h.sessionUser(r); role != "admin" {
 			h.errorResponse(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -63,13 +128,87 @@ func (h *Handler) AdminRequired(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// AccessLog logs each request's route, status and latency under an
+// X-Request-Id, taken from the request or generated
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			requestID = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-Id", requestID)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		slog.Info("Request",
+			"request_id", requestID,
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"latency_ms", time.Since(start).Milliseconds(),
+			"ip", clientIP(r),
+		)
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// sessionUser returns the id and role of the logged in user or the owner of
+// the X-Api-Key header, or 0 and "" when neither is present. The role is read
+// from the database, so deleted users and role changes take effect at once,
+// and sessions started before the user's last password or role change are
+// rejected.
+func (h *Handler) sessionUser(r *http.Request) (int, string) {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		user, err := h.db.GetUserByAPIKey(hashAPIKey(key))
+		if err != nil || user == nil {
+			return 0, ""
+		}
+		return user.ID, user.Role
+	}
+
+	session, _ := h.store.Get(r, "session")
+	userID, _ := session.Values["user_id"].(int)
+	if userID == 0 {
+		return 0, ""
+	}
+	version, _ := session.Values["session_version"].(int)
+	user, err := h.db.GetUser(userID)
+	if err != nil || user == nil || user.SessionVersion != version {
+		return 0, ""
+	}
+	return user.ID, user.Role
+}
+
 // Health & Status
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	sonarrStatus := "not configured"
 	radarrStatus := "not configured"
 
 	if h.db.GetSetting("sonarr_url") != "" && h.db.GetSetting("sonarr_api_key") != "" {
-		if _, err := h.sonarr.GetStatus(); err == nil {
+		if _, err := h.sonarr.GetStatus(r.Context()); err == nil {
 			sonarrStatus = "connected"
 		} else {
 			sonarrStatus = "error: " + err.Error()
@@ -77,7 +216,7 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if h.db.GetSetting("radarr_url") != "" && h.db.GetSetting("radarr_api_key") != "" {
-		if _, err := h.radarr.GetStatus(); err == nil {
+		if _, err := h.radarr.GetStatus(r.Context()); err == nil {
 			radarrStatus = "connected"
 		} else {
 			radarrStatus = "error: " + err.Error()
@@ -91,6 +230,110 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// DetailedHealth checks each dependency concurrently. The overall status is
+// "error" (503) when the database is unavailable, and "degraded" when a
+// configured service is unreachable or notifications are failing.
+func (h *Handler) DetailedHealth(w http.ResponseWriter, r *http.Request) {
+	components := make(map[string]interface{})
+	degraded := false
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	check := func(name string, configured bool, ping func() error) {
+		if !configured {
+			mu.Lock()
+			components[name] = map[string]interface{}{"status": "not configured"}
+			mu.Unlock()
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := map[string]interface{}{"status": "ok"}
+			err := ping()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result = map[string]interface{}{"status": "error", "error": err.Error()}
+				degraded = true
+			}
+			components[name] = result
+		}()
+	}
+
+	check("sonarr", h.sonarr.IsConfigured(), func() error {
+		_, err := h.sonarr.GetStatus(r.Context())
+		return err
+	})
+	check("radarr", h.radarr.IsConfigured(), func() error {
+		_, err := h.radarr.GetStatus(r.Context())
+		return err
+	})
+	check("radarr_4k", h.radarr4k.IsConfigured(), func() error {
+		_, err := h.radarr4k.GetStatus(r.Context())
+		return err
+	})
+	check("tmdb", h.db.GetSetting("tmdb_api_key") != "", func() error {
+		return h.tmdb.Ping(r.Context())
+	})
+	check("cache", true, func() error {
+		if pinger, ok := h.cache.(interface{ Ping() error }); ok {
+			return pinger.Ping()
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	dbErr := h.db.PingContext(ctx)
+
+	notifications := map[string]interface{}{"status": "ok"}
+	if channels, err := h.notify.ChannelStatus(); err != nil {
+		notifications = map[string]interface{}{"status": "error", "error": err.Error()}
+	} else {
+		for _, status := range channels {
+			if status != "ok" {
+				notifications["status"] = "degraded"
+			}
+		}
+		notifications["channels"] = channels
+	}
+
+	wg.Wait()
+
+	if counter, ok := h.cache.(interface{ Len() int }); ok {
+		components["cache"].(map[string]interface{})["items"] = counter.Len()
+	}
+	components["notifications"] = notifications
+	if notifications["status"] != "ok" {
+		degraded = true
+	}
+
+	status, code := "ok", http.StatusOK
+	if dbErr != nil {
+		components["database"] = map[string]interface{}{"status": "error", "error": dbErr.Error()}
+		status, code = "error", http.StatusServiceUnavailable
+	} else {
+		components["database"] = map[string]interface{}{"status": "ok"}
+		if degraded {
+			status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     status,
+		"components": components,
+		"version":    version.Get(),
+	})
+}
+
+func (h *Handler) GetVersion(w http.ResponseWriter, r *http.Request) {
+	h.jsonResponse(w, version.Get())
+}
+
 func (h *Handler) ServicesStatus(w http.ResponseWriter, r *http.Request) {
 	sonarrURL := h.db.GetSetting("sonarr_url")
 	sonarrKey := h.db.GetSetting("sonarr_api_key")
@@ -104,13 +347,13 @@ func (h *Handler) ServicesStatus(w http.ResponseWriter, r *http.Request) {
 	radarrConnected := false
 
 	if sonarrConfigured {
-		if _, err := h.sonarr.GetStatus(); err == nil {
+		if _, err := h.sonarr.GetStatus(r.Context()); err == nil {
 			sonarrConnected = true
 		}
 	}
 
 	if radarrConfigured {
-		if _, err := h.radarr.GetStatus(); err == nil {
+		if _, err := h.radarr.GetStatus(r.Context()); err == nil {
 			radarrConnected = true
 		}
 	}
@@ -130,730 +373,5195 @@ func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
 	h.jsonResponse(w, stats)
 }
 
-// Discovery
-func (h *Handler) DiscoverSeries(w http.ResponseWriter, r *http.Request) {
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
+const (
+	defaultTopRequestedLimit = 10
+	maxTopRequestedLimit     = 100
+	defaultStatsDays         = 30
+	maxStatsDays             = 365
+)
+
+// GetTopRequested lists the most requested titles, optionally of one type
+func (h *Handler) GetTopRequested(w http.ResponseWriter, r *http.Request) {
+	mediaType := r.URL.Query().Get("type")
+	if mediaType != "" && mediaType != "movie" && mediaType != "series" {
+		h.errorResponse(w, "Invalid type, expected movie or series", http.StatusBadRequest)
+		return
 	}
-	sort := r.URL.Query().Get("sort")
-	if sort == "" {
-		sort = "popularity.desc"
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 {
+		limit = defaultTopRequestedLimit
+	}
+	if limit > maxTopRequestedLimit {
+		limit = maxTopRequestedLimit
 	}
-	year := r.URL.Query().Get("year")
 
-	items, totalPages, err := h.tmdb.DiscoverTV(page, sort, year)
+	top, err := h.db.GetTopRequested(mediaType, limit)
 	if err != nil {
 		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	h.jsonResponse(w, map[string]interface{}{
-		"results":    items,
-		"page":       page,
-		"totalPages": totalPages,
-	})
+	if top == nil {
+		top = []models.TopRequested{}
+	}
+	h.jsonResponse(w, top)
 }
 
-func (h *Handler) DiscoverMovies(w http.ResponseWriter, r *http.Request) {
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
+// GetDailyStats returns the number of requests per day over the last days
+// days, oldest first
+func (h *Handler) GetDailyStats(w http.ResponseWriter, r *http.Request) {
+	days, _ := strconv.Atoi(r.URL.Query().Get("days"))
+	if days < 1 {
+		days = defaultStatsDays
 	}
-	sort := r.URL.Query().Get("sort")
-	if sort == "" {
-		sort = "popularity.desc"
+	if days > maxStatsDays {
+		days = maxStatsDays
 	}
-	year := r.URL.Query().Get("year")
 
-	items, totalPages, err := h.tmdb.DiscoverMovies(page, sort, year)
+	series, err := h.db.GetRequestsPerDay(days)
 	if err != nil {
 		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	h.jsonResponse(w, map[string]interface{}{
-		"results":    items,
-		"page":       page,
-		"totalPages": totalPages,
-	})
+	h.jsonResponse(w, series)
 }
 
-// Search
-func (h *Handler) SearchSeries(w http.ResponseWriter, r *http.Request) {
-	term := r.URL.Query().Get("term")
-	if len(term) < 2 {
-		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+// GetRequesterStats returns request counts per requester, optionally for
+// requests made within a from/to date range
+func (h *Handler) GetRequesterStats(w http.ResponseWriter, r *http.Request) {
+	since, until, err := parseDateRange(r.URL.Query())
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	results, err := h.sonarr.Search(term)
+	stats, err := h.db.GetRequesterStats(since, until)
 	if err != nil {
 		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if stats == nil {
+		stats = []models.RequesterStats{}
+	}
+	h.jsonResponse(w, stats)
+}
 
-	existing, _ := h.sonarr.GetExisting()
-	existingIDs := make(map[int]bool)
-	for _, s := range existing {
-		if id, ok := s["tvdbId"].(float64); ok {
-			existingIDs[int(id)] = true
-		}
+// Config (public, non-secret values only)
+func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	instanceName := h.db.GetSetting("instance_name")
+	if instanceName == "" {
+		instanceName = "Requestarr"
 	}
 
-	requestedIDs, _ := h.db.GetRequestedIDs("series")
+	seriesEnabled := h.db.GetSetting("sonarr_url") != "" && h.db.GetSetting("sonarr_api_key") != ""
+	moviesEnabled := h.db.GetSetting("radarr_url") != "" && h.db.GetSetting("radarr_api_key") != ""
 
-	enhancedResults := make([]map[string]interface{}, 0, len(results))
-	for _, series := range results {
-		tvdbID := 0
-		if id, ok := series["tvdbId"].(float64); ok {
-			tvdbID = int(id)
-		}
+	mediaTypes := make([]string, 0, 2)
+	if seriesEnabled {
+		mediaTypes = append(mediaTypes, "series")
+	}
+	if moviesEnabled {
+		mediaTypes = append(mediaTypes, "movie")
+	}
 
-		status := "available"
-		if existingIDs[tvdbID] {
-			status = "exists"
-		} else if requestedIDs[tvdbID] {
-			status = "requested"
-		}
+	h.jsonResponse(w, map[string]interface{}{
+		"instanceName":       instanceName,
+		"mediaTypes":         mediaTypes,
+		"discoveryEnabled":   h.db.GetSetting("tmdb_api_key") != "",
+		"ratingsEnabled":     h.db.GetSetting("mdblist_api_key") != "",
+		"maintenanceMode":    h.db.GetSettingBool("maintenance_mode", false),
+		"maintenanceMessage": h.db.GetSetting("maintenance_message"),
+		"requireEmail":       h.db.GetSettingBool("require_email", false),
+	})
+}
 
-		rating := 0.0
-		if ratings, ok := series["ratings"].(map[string]interface{}); ok {
-			if v, ok := ratings["value"].(float64); ok {
-				rating = v
-			}
-		}
+// Discovery
+const discoverBackfillPages = 3
 
-		poster := ""
-		fanart := ""
-		if images, ok := series["images"].([]interface{}); ok {
-			for _, img := range images {
-				if imgMap, ok := img.(map[string]interface{}); ok {
-					coverType, _ := imgMap["coverType"].(string)
-					remoteUrl, _ := imgMap["remoteUrl"].(string)
-					if coverType == "poster" && poster == "" {
-						poster = remoteUrl
-					} else if coverType == "fanart" && fanart == "" {
-						fanart = remoteUrl
-					}
-				}
-			}
-		}
+// tmdbError replies 503 with Retry-After when TMDB is rate limiting, and 500
+// for other failures
+func (h *Handler) tmdbError(w http.ResponseWriter, err error) {
+	var rateLimited *services.RateLimitError
+	if errors.As(err, &rateLimited) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+		h.errorResponse(w, "TMDB is rate limiting requests, try again shortly", http.StatusServiceUnavailable)
+		return
+	}
+	h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+}
 
-		enhanced := map[string]interface{}{
-			"tvdbId":        tvdbID,
-			"title":         series["title"],
-			"year":          series["year"],
-			"overview":      series["overview"],
-			"network":       series["network"],
-			"status":        series["status"],
-			"rating":        rating,
-			"poster":        poster,
-			"fanart":        fanart,
-			"requestStatus": status,
-		}
-		enhancedResults = append(enhancedResults, enhanced)
+// arrErrorStatus maps a Sonarr or Radarr failure to the status to reply
+// with: the arr's own 400 and 404 pass through, while auth failures and
+// other errors are the upstream's fault
+func arrErrorStatus(err error) int {
+	var arrErr *services.ArrError
+	if !errors.As(err, &arrErr) {
+		return http.StatusInternalServerError
+	}
+	switch arrErr.StatusCode {
+	case http.StatusBadRequest, http.StatusNotFound:
+		return arrErr.StatusCode
 	}
+	return http.StatusBadGateway
+}
 
-	h.jsonResponse(w, enhancedResults)
+func (h *Handler) DiscoverSeries(w http.ResponseWriter, r *http.Request) {
+	h.discover(w, r, h.tmdb.DiscoverTV)
 }
 
-func (h *Handler) SearchMovies(w http.ResponseWriter, r *http.Request) {
-	term := r.URL.Query().Get("term")
-	if len(term) < 2 {
-		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+func (h *Handler) DiscoverMovies(w http.ResponseWriter, r *http.Request) {
+	h.discover(w, r, h.tmdb.DiscoverMovies)
+}
+
+func (h *Handler) discover(w http.ResponseWriter, r *http.Request, fetch func(ctx context.Context, page int, opts services.DiscoverOptions) ([]services.MediaItem, int, error)) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	opts := services.DiscoverOptions{
+		SortBy:        r.URL.Query().Get("sort"),
+		Year:          r.URL.Query().Get("year"),
+		WithProviders: r.URL.Query().Get("withProviders"),
+		WatchRegion:   r.URL.Query().Get("watchRegion"),
+		Language:      r.URL.Query().Get("language"),
+		Region:        strings.ToUpper(r.URL.Query().Get("region")),
+	}
+	if opts.SortBy == "" {
+		opts.SortBy = "popularity.desc"
+	}
+	if opts.Language != "" && !services.IsValidLanguage(opts.Language) {
+		h.errorResponse(w, "language must be a two letter language code or "+services.AnyLanguage, http.StatusBadRequest)
+		return
+	}
+	if opts.Region != "" && !services.IsValidRegion(opts.Region) {
+		h.errorResponse(w, "region must be a two letter country code", http.StatusBadRequest)
 		return
 	}
 
-	results, err := h.radarr.Search(term)
-	if err != nil {
-		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+	// A range such as fromYear=2010&toYear=2019, either end optional
+	var err error
+	if opts.FromYear, err = yearParam(r, "fromYear"); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.ToYear, err = yearParam(r, "toYear"); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Year != "" && (opts.FromYear > 0 || opts.ToYear > 0) {
+		h.errorResponse(w, "Use either year or fromYear/toYear", http.StatusBadRequest)
+		return
+	}
+	if opts.FromYear > 0 && opts.ToYear > 0 && opts.FromYear > opts.ToYear {
+		h.errorResponse(w, "fromYear must not be after toYear", http.StatusBadRequest)
 		return
 	}
 
-	existing, _ := h.radarr.GetExisting()
-	existingIDs := make(map[int]bool)
-	for _, m := range existing {
-		if id, ok := m["tmdbId"].(float64); ok {
-			existingIDs[int(id)] = true
+	if value := r.URL.Query().Get("minRating"); value != "" {
+		opts.MinRating, err = strconv.ParseFloat(value, 64)
+		if err != nil || !(opts.MinRating >= 0 && opts.MinRating <= 10) {
+			h.errorResponse(w, "minRating must be between 0 and 10", http.StatusBadRequest)
+			return
 		}
 	}
+	if opts.MinRuntime, err = rangeParam(r, "minRuntime", 1, maxDiscoverRuntime); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.MaxRuntime, err = rangeParam(r, "maxRuntime", 1, maxDiscoverRuntime); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.MinRuntime > 0 && opts.MaxRuntime > 0 && opts.MinRuntime > opts.MaxRuntime {
+		h.errorResponse(w, "minRuntime must not be more than maxRuntime", http.StatusBadRequest)
+		return
+	}
+	if opts.MinVotes, err = rangeParam(r, "minVotes", 1, maxDiscoverVotes); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	requestedIDs, _ := h.db.GetRequestedIDs("movie")
-
-	enhancedResults := make([]map[string]interface{}, 0, len(results))
-	for _, movie := range results {
-		tmdbID := 0
-		if id, ok := movie["tmdbId"].(float64); ok {
-			tmdbID = int(id)
-		}
+	hideOwned := r.URL.Query().Get("hideOwned") == "true"
+	hideRequested := r.URL.Query().Get("hideRequested") == "true"
 
-		status := "available"
-		if existingIDs[tmdbID] {
-			status = "exists"
-		} else if requestedIDs[tmdbID] {
-			status = "requested"
-		}
+	items, totalPages, err := fetch(r.Context(), page, opts)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
 
-		rating := 0.0
-		if ratings, ok := movie["ratings"].(map[string]interface{}); ok {
-			if tmdbRating, ok := ratings["tmdb"].(map[string]interface{}); ok {
-				if v, ok := tmdbRating["value"].(float64); ok {
-					rating = v
-				}
-			} else if v, ok := ratings["value"].(float64); ok {
-				rating = v
+	nextPage := page + 1
+	if hideOwned || hideRequested {
+		// Hidden items shrink the page, so top it up from the following pages
+		pageSize := len(items)
+		items = filterDiscoverItems(items, hideOwned, hideRequested)
+		for extra := 0; len(items) < pageSize && nextPage <= totalPages && extra < discoverBackfillPages; extra++ {
+			more, _, err := fetch(r.Context(), nextPage, opts)
+			if err != nil {
+				break
 			}
+			nextPage++
+			items = append(items, filterDiscoverItems(more, hideOwned, hideRequested)...)
 		}
+	}
 
-		poster := ""
-		fanart := ""
-		if images, ok := movie["images"].([]interface{}); ok {
-			for _, img := range images {
-				if imgMap, ok := img.(map[string]interface{}); ok {
-					coverType, _ := imgMap["coverType"].(string)
-					remoteUrl, _ := imgMap["remoteUrl"].(string)
-					if coverType == "poster" && poster == "" {
-						poster = remoteUrl
-					} else if coverType == "fanart" && fanart == "" {
-						fanart = remoteUrl
-					}
-				}
-			}
+	// Items TMDB couldn't enrich with external ids can't be requested
+	enrichmentErrors := 0
+	for _, item := range items {
+		if item.EnrichmentFailed {
+			enrichmentErrors++
 		}
+	}
 
-		enhanced := map[string]interface{}{
-			"tmdbId":        tmdbID,
-			"imdbId":        movie["imdbId"],
-			"title":         movie["title"],
-			"year":          movie["year"],
-			"overview":      movie["overview"],
-			"studio":        movie["studio"],
-			"runtime":       movie["runtime"],
-			"rating":        rating,
-			"poster":        poster,
-			"fanart":        fanart,
-			"requestStatus": status,
+	h.jsonResponse(w, map[string]interface{}{
+		"results":          items,
+		"page":             page,
+		"nextPage":         nextPage,
+		"totalPages":       totalPages,
+		"enrichmentErrors": enrichmentErrors,
+		"degraded":         enrichmentErrors > 0,
+	})
+}
+
+// yearParam reads a year from the query, 0 when it's not given
+func yearParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 1800 || year > 9999 {
+		return 0, fmt.Errorf("%s must be a year", name)
+	}
+	return year, nil
+}
+
+// Upper bounds of the discover filters
+const (
+	maxDiscoverRuntime = 1000 // minutes
+	maxDiscoverVotes   = 100000
+)
+
+// rangeParam reads a whole number from low to high from the query, 0 when
+// it's not given
+func rangeParam(r *http.Request, name string, low, high int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < low || n > high {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, low, high)
+	}
+	return n, nil
+}
+
+func (h *Handler) DiscoverTrending(w http.ResponseWriter, r *http.Request) {
+	mediaType := r.URL.Query().Get("type")
+	if mediaType == "" {
+		mediaType = "movie"
+	}
+	if mediaType != "movie" && mediaType != "tv" {
+		h.errorResponse(w, "type must be movie or tv", http.StatusBadRequest)
+		return
+	}
+
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "week"
+	}
+	if window != "day" && window != "week" {
+		h.errorResponse(w, "window must be day or week", http.StatusBadRequest)
+		return
+	}
+
+	items, totalPages, err := h.tmdb.Trending(r.Context(), mediaType, window)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results":    items,
+		"page":       1,
+		"totalPages": totalPages,
+	})
+}
+
+func (h *Handler) GetWatchProviders(w http.ResponseWriter, r *http.Request) {
+	mediaType := r.URL.Query().Get("type")
+	if mediaType == "" {
+		mediaType = "movie"
+	}
+	if mediaType != "movie" && mediaType != "tv" {
+		h.errorResponse(w, "type must be movie or tv", http.StatusBadRequest)
+		return
+	}
+
+	region := strings.ToUpper(r.URL.Query().Get("region"))
+	if region == "" {
+		region = h.tmdb.Region()
+	}
+
+	providers, err := h.tmdb.WatchProviders(r.Context(), mediaType, region)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"region":    region,
+		"providers": providers,
+	})
+}
+
+func (h *Handler) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tmdbID, _ := strconv.Atoi(vars["id"])
+
+	items, err := h.tmdb.Recommendations(r.Context(), vars["type"], tmdbID)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results": items,
+	})
+}
+
+// GetMediaDetail returns a movie or tv show with its cast, trailer,
+// recommendations and ratings, for a detail page
+func (h *Handler) GetMediaDetail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tmdbID, _ := strconv.Atoi(vars["id"])
+
+	detail, err := h.tmdb.GetDetail(r.Context(), vars["type"], tmdbID)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	year := ""
+	if detail.Year > 0 {
+		year = strconv.Itoa(detail.Year)
+	}
+	if ratings, err := h.ratings.GetRatings(r.Context(), detail.Title, year, detail.MediaType, detail.ImdbID, tmdbID); err == nil && *ratings != (services.RatingsResult{}) {
+		detail.Ratings = ratings
+	}
+
+	h.jsonResponse(w, detail)
+}
+
+func (h *Handler) GetCollection(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	collectionID, _ := strconv.Atoi(vars["id"])
+
+	collection, err := h.tmdb.GetCollection(r.Context(), collectionID)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	h.jsonResponse(w, collection)
+}
+
+var traktListPattern = regexp.MustCompile(`^[\w.-]+/[\w.-]+$`)
+
+func (h *Handler) DiscoverTrakt(w http.ResponseWriter, r *http.Request) {
+	list := r.URL.Query().Get("list")
+	if list == "" {
+		list = "trending"
+	}
+	if list != "trending" && list != "popular" && !traktListPattern.MatchString(list) {
+		h.errorResponse(w, "list must be trending, popular or username/slug", http.StatusBadRequest)
+		return
+	}
+
+	mediaType := r.URL.Query().Get("type")
+	if mediaType == "" {
+		mediaType = "movie"
+	}
+	if mediaType != "movie" && mediaType != "series" {
+		h.errorResponse(w, "type must be movie or series", http.StatusBadRequest)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	items, totalPages, err := h.trakt.GetList(r.Context(), list, mediaType, page)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results":    items,
+		"page":       page,
+		"nextPage":   page + 1,
+		"totalPages": totalPages,
+	})
+}
+
+func filterDiscoverItems(items []services.MediaItem, hideOwned, hideRequested bool) []services.MediaItem {
+	filtered := make([]services.MediaItem, 0, len(items))
+	for _, item := range items {
+		if hideOwned && item.RequestStatus == "exists" {
+			continue
+		}
+		if hideRequested && item.RequestStatus == "requested" {
+			continue
+		}
+		filtered = append(filtered, item)
+	}
+	return filtered
+}
+
+// Search
+func (h *Handler) SearchSeries(w http.ResponseWriter, r *http.Request) {
+	term := r.URL.Query().Get("term")
+	if len(term) < 2 {
+		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+		return
+	}
+
+	enhancedResults, err := h.searchSeries(r.Context(), term)
+	if err != nil {
+		h.errorResponse(w, err.Error(), arrErrorStatus(err))
+		return
+	}
+
+	if r.URL.Query().Get("withRatings") == "true" {
+		h.addRatings(r.Context(), enhancedResults, "series")
+	}
+
+	h.jsonResponse(w, enhancedResults)
+}
+
+// searchSeries looks term up in Sonarr and marks series that are already
+// in the library or requested
+func (h *Handler) searchSeries(ctx context.Context, term string) ([]map[string]interface{}, error) {
+	results, err := h.sonarr.Search(ctx, term)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, _ := h.sonarr.GetExisting(ctx)
+	existingIDs := make(map[int]bool)
+	for _, s := range existing {
+		if id, ok := s["tvdbId"].(float64); ok {
+			existingIDs[int(id)] = true
+		}
+	}
+
+	requestedIDs, _ := h.db.GetRequestedIDs("series")
+
+	enhancedResults := make([]map[string]interface{}, 0, len(results))
+	for _, series := range results {
+		tvdbID := 0
+		if id, ok := series["tvdbId"].(float64); ok {
+			tvdbID = int(id)
+		}
+
+		status := "available"
+		if existingIDs[tvdbID] {
+			status = "exists"
+		} else if requestedIDs[tvdbID] {
+			status = "requested"
+		}
+
+		rating := 0.0
+		if ratings, ok := series["ratings"].(map[string]interface{}); ok {
+			if v, ok := ratings["value"].(float64); ok {
+				rating = v
+			}
+		}
+
+		poster := ""
+		fanart := ""
+		if images, ok := series["images"].([]interface{}); ok {
+			for _, img := range images {
+				if imgMap, ok := img.(map[string]interface{}); ok {
+					coverType, _ := imgMap["coverType"].(string)
+					remoteUrl, _ := imgMap["remoteUrl"].(string)
+					if coverType == "poster" && poster == "" {
+						poster = remoteUrl
+					} else if coverType == "fanart" && fanart == "" {
+						fanart = remoteUrl
+					}
+				}
+			}
+		}
+
+		enhanced := map[string]interface{}{
+			"tvdbId":        tvdbID,
+			"imdbId":        series["imdbId"],
+			"title":         series["title"],
+			"overview":      series["overview"],
+			"network":       series["network"],
+			"status":        series["status"],
+			"rating":        rating,
+			"poster":        poster,
+			"fanart":        fanart,
+			"requestStatus": status,
+		}
+		if year := services.ParseYear(series["year"]); year > 0 {
+			enhanced["year"] = year
+		}
+		enhancedResults = append(enhancedResults, enhanced)
+	}
+
+	return enhancedResults, nil
+}
+
+func (h *Handler) SearchMovies(w http.ResponseWriter, r *http.Request) {
+	term := r.URL.Query().Get("term")
+	if len(term) < 2 {
+		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+		return
+	}
+
+	enhancedResults, err := h.searchMovies(r.Context(), term)
+	if err != nil {
+		h.errorResponse(w, err.Error(), arrErrorStatus(err))
+		return
+	}
+
+	if r.URL.Query().Get("withRatings") == "true" {
+		h.addRatings(r.Context(), enhancedResults, "movie")
+	}
+
+	h.jsonResponse(w, enhancedResults)
+}
+
+// searchMovies looks term up in Radarr and marks movies that are already
+// in the library or requested
+func (h *Handler) searchMovies(ctx context.Context, term string) ([]map[string]interface{}, error) {
+	results, err := h.radarr.Search(ctx, term)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, _ := h.radarr.GetExisting(ctx)
+	existingIDs := make(map[int]bool)
+	for _, m := range existing {
+		if id, ok := m["tmdbId"].(float64); ok {
+			existingIDs[int(id)] = true
+		}
+	}
+
+	requestedIDs, _ := h.db.GetRequestedIDs("movie")
+
+	enhancedResults := make([]map[string]interface{}, 0, len(results))
+	for _, movie := range results {
+		tmdbID := 0
+		if id, ok := movie["tmdbId"].(float64); ok {
+			tmdbID = int(id)
+		}
+
+		status := "available"
+		if existingIDs[tmdbID] {
+			status = "exists"
+		} else if requestedIDs[tmdbID] {
+			status = "requested"
+		}
+
+		rating := 0.0
+		if ratings, ok := movie["ratings"].(map[string]interface{}); ok {
+			if tmdbRating, ok := ratings["tmdb"].(map[string]interface{}); ok {
+				if v, ok := tmdbRating["value"].(float64); ok {
+					rating = v
+				}
+			} else if v, ok := ratings["value"].(float64); ok {
+				rating = v
+			}
+		}
+
+		poster := ""
+		fanart := ""
+		if images, ok := movie["images"].([]interface{}); ok {
+			for _, img := range images {
+				if imgMap, ok := img.(map[string]interface{}); ok {
+					coverType, _ := imgMap["coverType"].(string)
+					remoteUrl, _ := imgMap["remoteUrl"].(string)
+					if coverType == "poster" && poster == "" {
+						poster = remoteUrl
+					} else if coverType == "fanart" && fanart == "" {
+						fanart = remoteUrl
+					}
+				}
+			}
+		}
+
+		enhanced := map[string]interface{}{
+			"tmdbId":        tmdbID,
+			"imdbId":        movie["imdbId"],
+			"title":         movie["title"],
+			"overview":      movie["overview"],
+			"studio":        movie["studio"],
+			"runtime":       movie["runtime"],
+			"rating":        rating,
+			"poster":        poster,
+			"fanart":        fanart,
+			"requestStatus": status,
+		}
+		if year := services.ParseYear(movie["year"]); year > 0 {
+			enhanced["year"] = year
+		}
+		enhancedResults = append(enhancedResults, enhanced)
+	}
+
+	return enhancedResults, nil
+}
+
+// SearchMulti searches Sonarr and Radarr at once and returns one list with a
+// mediaType on each result, best title matches first
+func (h *Handler) SearchMulti(w http.ResponseWriter, r *http.Request) {
+	term := r.URL.Query().Get("term")
+	if len(term) < 2 {
+		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+		return
+	}
+
+	var series, movies []map[string]interface{}
+	var seriesErr, moviesErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		series, seriesErr = h.searchSeries(r.Context(), term)
+	}()
+	go func() {
+		defer wg.Done()
+		movies, moviesErr = h.searchMovies(r.Context(), term)
+	}()
+	wg.Wait()
+
+	// One of Sonarr and Radarr failing, or not being set up, still leaves
+	// results worth showing
+	if seriesErr != nil && moviesErr != nil {
+		h.errorResponse(w, seriesErr.Error(), arrErrorStatus(seriesErr))
+		return
+	}
+	if seriesErr != nil {
+		slog.Warn("Series search failed", "term", term, "error", seriesErr)
+	}
+	if moviesErr != nil {
+		slog.Warn("Movie search failed", "term", term, "error", moviesErr)
+	}
+
+	if r.URL.Query().Get("withRatings") == "true" {
+		h.addRatings(r.Context(), series, "series")
+		h.addRatings(r.Context(), movies, "movie")
+	}
+
+	h.jsonResponse(w, mergeSearchResults(term, series, movies))
+}
+
+// mergeSearchResults tags series and movies with their media type, drops
+// repeated ids and orders them by how well the title matches term. Results
+// that match equally keep the order Sonarr and Radarr ranked them in.
+func mergeSearchResults(term string, series, movies []map[string]interface{}) []map[string]interface{} {
+	type ranked struct {
+		item      map[string]interface{}
+		relevance int
+		position  int
+	}
+
+	term = strings.ToLower(strings.TrimSpace(term))
+	seen := make(map[string]bool)
+	var merged []ranked
+	add := func(items []map[string]interface{}, mediaType, idKey string) {
+		for i, item := range items {
+			if id, _ := item[idKey].(int); id > 0 {
+				key := fmt.Sprintf("%s_%d", mediaType, id)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			item["mediaType"] = mediaType
+			title, _ := item["title"].(string)
+			merged = append(merged, ranked{item, titleRelevance(term, title), i})
+		}
+	}
+	add(series, "series", "tvdbId")
+	add(movies, "movie", "tmdbId")
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].relevance != merged[j].relevance {
+			return merged[i].relevance < merged[j].relevance
+		}
+		return merged[i].position < merged[j].position
+	})
+
+	results := make([]map[string]interface{}, len(merged))
+	for i, r := range merged {
+		results[i] = r.item
+	}
+	return results
+}
+
+// titleRelevance ranks how title matches a lower-cased term, lower is better:
+// an exact match, then a prefix, then anywhere in the title
+func titleRelevance(term, title string) int {
+	title = strings.ToLower(title)
+	switch {
+	case title == term:
+		return 0
+	case strings.HasPrefix(title, term):
+		return 1
+	case strings.Contains(title, term):
+		return 2
+	}
+	return 3
+}
+
+var (
+	imdbIDPattern   = regexp.MustCompile(`tt\d+`)
+	tmdbLinkPattern = regexp.MustCompile(`(movie|tv)/(\d+)`)
+)
+
+// LookupByID resolves an IMDb id (?imdb=tt0133093) or a TMDB id
+// (?tmdb=603&type=movie) into one search result ready to be requested. Links
+// to either site can be passed as they are.
+func (h *Handler) LookupByID(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mediaType := query.Get("type")
+	if mediaType != "" && mediaType != "movie" && mediaType != "tv" {
+		h.errorResponse(w, "Invalid type, expected movie or tv", http.StatusBadRequest)
+		return
+	}
+
+	var tmdbID int
+	switch {
+	case query.Get("imdb") != "":
+		imdbID := imdbIDPattern.FindString(query.Get("imdb"))
+		if imdbID == "" {
+			h.errorResponse(w, "Invalid IMDb id", http.StatusBadRequest)
+			return
+		}
+		var err error
+		tmdbID, mediaType, err = h.tmdb.FindByIMDbID(r.Context(), imdbID, mediaType)
+		if err != nil {
+			h.tmdbError(w, err)
+			return
+		}
+		if tmdbID == 0 {
+			h.errorResponse(w, "TMDB has no title with this IMDb id", http.StatusNotFound)
+			return
+		}
+	case query.Get("tmdb") != "":
+		raw := query.Get("tmdb")
+		if m := tmdbLinkPattern.FindStringSubmatch(raw); m != nil {
+			mediaType, raw = m[1], m[2]
+		}
+		id, err := strconv.Atoi(raw)
+		if err != nil || id < 1 {
+			h.errorResponse(w, "Invalid TMDB id", http.StatusBadRequest)
+			return
+		}
+		if mediaType == "" {
+			h.errorResponse(w, "type is required with a TMDB id", http.StatusBadRequest)
+			return
+		}
+		tmdbID = id
+	default:
+		h.errorResponse(w, "imdb or tmdb is required", http.StatusBadRequest)
+		return
+	}
+
+	var results []map[string]interface{}
+	var err error
+	if mediaType == "movie" {
+		results, err = h.searchMovies(r.Context(), fmt.Sprintf("tmdb:%d", tmdbID))
+	} else {
+		// Sonarr looks series up by TVDB id
+		ids, err := h.tmdb.GetExternalIDs(r.Context(), tmdbID, "tv")
+		if err != nil {
+			h.tmdbError(w, err)
+			return
+		}
+		tvdbID := ids.TvdbID
+		if tvdbID == 0 {
+			h.errorResponse(w, "This show has no TVDB id, so it can't be added to Sonarr", http.StatusNotFound)
+			return
+		}
+		results, err = h.searchSeries(r.Context(), fmt.Sprintf("tvdb:%d", tvdbID))
+	}
+	if err != nil {
+		h.errorResponse(w, err.Error(), arrErrorStatus(err))
+		return
+	}
+	if len(results) == 0 {
+		h.errorResponse(w, "Title not found", http.StatusNotFound)
+		return
+	}
+
+	result := results[0]
+	result["tmdbId"] = tmdbID
+	result["mediaType"] = "movie"
+	if mediaType == "tv" {
+		result["mediaType"] = "series"
+	}
+	h.jsonResponse(w, result)
+}
+
+// maxRatingsLookups bounds the concurrent ratings lookups for a page of results
+const maxRatingsLookups = 5
+
+// addRatings merges Rotten Tomatoes, IMDB, Metacritic and Trakt scores into
+// search results
+func (h *Handler) addRatings(ctx context.Context, results []map[string]interface{}, mediaType string) {
+	sem := make(chan struct{}, maxRatingsLookups)
+	var wg sync.WaitGroup
+
+	for _, item := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item map[string]interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			title, _ := item["title"].(string)
+			imdbID, _ := item["imdbId"].(string)
+			tmdbID, _ := item["tmdbId"].(int)
+			year := ""
+			if y := services.ParseYear(item["year"]); y > 0 {
+				year = strconv.Itoa(y)
+			}
+
+			ratings, err := h.ratings.GetRatings(ctx, title, year, mediaType, imdbID, tmdbID)
+			if err != nil {
+				return
+			}
+			item["rottenTomatoes"] = ratings.RottenTomatoes
+			item["imdb"] = ratings.IMDB
+			item["metacritic"] = ratings.Metacritic
+			item["trakt"] = ratings.Trakt
+		}(item)
+	}
+
+	wg.Wait()
+}
+
+func (h *Handler) SearchPerson(w http.ResponseWriter, r *http.Request) {
+	term := r.URL.Query().Get("term")
+	if len(term) < 2 {
+		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+		return
+	}
+
+	people, err := h.tmdb.SearchPerson(r.Context(), term)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	h.jsonResponse(w, people)
+}
+
+func (h *Handler) GetPersonCredits(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	personID, _ := strconv.Atoi(vars["id"])
+
+	mediaType := r.URL.Query().Get("type")
+	if mediaType != "" && mediaType != "movie" && mediaType != "tv" {
+		h.errorResponse(w, "type must be movie or tv", http.StatusBadRequest)
+		return
+	}
+
+	items, err := h.tmdb.PersonCredits(r.Context(), personID, mediaType)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results": items,
+	})
+}
+
+// Ratings
+func (h *Handler) GetRatings(w http.ResponseWriter, r *http.Request) {
+	title := r.URL.Query().Get("title")
+	year := r.URL.Query().Get("year")
+	mediaType := r.URL.Query().Get("type")
+	imdbID := r.URL.Query().Get("imdb_id")
+	tmdbID, _ := strconv.Atoi(r.URL.Query().Get("tmdb_id"))
+
+	if title == "" && imdbID == "" && tmdbID == 0 {
+		h.errorResponse(w, "Title or ID required", http.StatusBadRequest)
+		return
+	}
+
+	ratings, err := h.ratings.GetRatings(r.Context(), title, year, mediaType, imdbID, tmdbID)
+	if err != nil {
+		h.jsonResponse(w, map[string]interface{}{})
+		return
+	}
+
+	h.jsonResponse(w, ratings)
+}
+
+// Requests
+func (h *Handler) CreateRequest(w http.ResponseWriter, r *http.Request) {
+	var raw map[string]interface{}
+
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Extract fields with type flexibility
+	requesterName, _ := raw["requesterName"].(string)
+	requesterEmail, _ := raw["requesterEmail"].(string)
+	mediaType, _ := raw["mediaType"].(string)
+	title, _ := raw["title"].(string)
+	poster, _ := raw["poster"].(string)
+	imdbID, _ := raw["imdbId"].(string)
+
+	// Handle year - could be a number, a year string or a date; 0 means unknown
+	var year *int
+	if yi := services.ParseYear(raw["year"]); yi > 0 {
+		year = &yi
+	}
+
+	// Handle tmdbId - could be float64 from JSON
+	var tmdbID *int
+	if id, ok := raw["tmdbId"].(float64); ok {
+		i := int(id)
+		tmdbID = &i
+	}
+
+	// Handle tvdbId
+	var tvdbID *int
+	if id, ok := raw["tvdbId"].(float64); ok {
+		i := int(id)
+		tvdbID = &i
+	}
+
+	// Logged in users are attributed by account rather than the free-text name
+	var userID *int
+	autoApprove := false
+	if id, _ := h.sessionUser(r); id != 0 {
+		user, err := h.db.GetUser(id)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user != nil {
+			userID = &user.ID
+			requesterName = user.Username
+			autoApprove = user.AutoApprove
+		}
+	}
+
+	if h.db.GetSettingBool("maintenance_mode", false) {
+		h.errorResponse(w, "Requests are temporarily disabled for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+
+	if requesterName == "" || title == "" {
+		h.errorResponse(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	if requesterEmail == "" && h.db.GetSettingBool("require_email", false) {
+		h.errorResponse(w, "Email address is required", http.StatusBadRequest)
+		return
+	}
+
+	if mediaType == "" {
+		mediaType = "series"
+	}
+
+	// Optional season selection, all seasons when empty
+	var seasons []int
+	if list, ok := raw["seasons"].([]interface{}); ok {
+		if mediaType != "series" {
+			h.errorResponse(w, "Seasons can only be requested for series", http.StatusBadRequest)
+			return
+		}
+		for _, item := range list {
+			season, ok := item.(float64)
+			if !ok || season < 0 {
+				h.errorResponse(w, "Invalid season", http.StatusBadRequest)
+				return
+			}
+			seasons = append(seasons, int(season))
+		}
+	}
+
+	// Optional specific episodes, e.g. for daily and talk shows
+	var episodes []models.Episode
+	if list, ok := raw["episodes"].([]interface{}); ok {
+		if mediaType != "series" {
+			h.errorResponse(w, "Episodes can only be requested for series", http.StatusBadRequest)
+			return
+		}
+		for _, item := range list {
+			ep, ok := item.(map[string]interface{})
+			if !ok {
+				h.errorResponse(w, "Invalid episode", http.StatusBadRequest)
+				return
+			}
+			season, sok := ep["season"].(float64)
+			number, eok := ep["episode"].(float64)
+			if !sok || !eok || season < 0 || number < 1 {
+				h.errorResponse(w, "Invalid episode", http.StatusBadRequest)
+				return
+			}
+			episodes = append(episodes, models.Episode{Season: int(season), Episode: int(number)})
+		}
+	}
+
+	if len(seasons) > 0 && len(episodes) > 0 {
+		h.errorResponse(w, "Request either seasons or episodes, not both", http.StatusBadRequest)
+		return
+	}
+
+	// Anime and daily shows need a matching Sonarr series type, detect anime
+	// from TMDB when the requester didn't pick one
+	var seriesType *string
+	if st, _ := raw["seriesType"].(string); st != "" {
+		if mediaType != "series" || !services.IsValidSeriesType(st) {
+			h.errorResponse(w, "Invalid series type, expected one of: "+strings.Join(services.SeriesTypes, ", "), http.StatusBadRequest)
+			return
+		}
+		seriesType = &st
+	} else if mediaType == "series" && tmdbID != nil {
+		st := "standard"
+		if anime, _ := h.tmdb.IsAnime(r.Context(), *tmdbID); anime {
+			st = "anime"
+		}
+		seriesType = &st
+	}
+
+	is4K, _ := raw["is4k"].(bool)
+	if is4K && mediaType != "movie" {
+		h.errorResponse(w, "4K can only be requested for movies", http.StatusBadRequest)
+		return
+	}
+	if is4K && !h.radarr4k.IsConfigured() {
+		h.errorResponse(w, "4K Radarr is not configured", http.StatusBadRequest)
+		return
+	}
+
+	// Check if already exists
+	var warning string
+	if mediaType == "series" {
+		if tvdbID == nil {
+			h.errorResponse(w, "Missing tvdbId for series", http.StatusBadRequest)
+			return
+		}
+		exists, _ := h.sonarr.CheckExists(r.Context(), *tvdbID)
+		if exists && len(seasons) == 0 {
+			h.errorResponse(w, "Series already exists in library", http.StatusConflict)
+			return
+		}
+		// Seasons missing from a series in the library can still be requested
+		if exists {
+			var available []int
+			var err error
+			seasons, available, err = h.missingSeasons(r.Context(), *tvdbID, seasons)
+			if err != nil {
+				h.errorResponse(w, "Failed to check seasons in Sonarr: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			if len(seasons) == 0 {
+				h.errorResponse(w, "The requested seasons are already in the library", http.StatusConflict)
+				return
+			}
+			if len(available) > 0 {
+				warning = "Already in the library and left out of the request: " + seasonNames(available)
+			}
+		}
+	} else {
+		if tmdbID == nil {
+			h.errorResponse(w, "Missing tmdbId for movie", http.StatusBadRequest)
+			return
+		}
+		// A 4K copy covers regular requests, but not the other way around
+		exists, _ := h.radarr4k.CheckExists(r.Context(), *tmdbID)
+		if !exists && !is4K {
+			exists, _ = h.radarr.CheckExists(r.Context(), *tmdbID)
+		}
+		if exists {
+			h.errorResponse(w, "Movie already exists in library", http.StatusConflict)
+			return
+		}
+	}
+
+	// Check for duplicate request
+	switch status, _ := h.findDuplicateRequest(mediaType, tmdbID, tvdbID, seasons, is4K); status {
+	case "":
+	case "pending":
+		h.errorResponse(w, "This has already been requested", http.StatusConflict)
+		return
+	case "completed":
+		h.errorResponse(w, "This request has already been completed", http.StatusConflict)
+		return
+	default:
+		h.errorResponse(w, "This has already been approved", http.StatusConflict)
+		return
+	}
+
+	// Enforce the requester's quota for this media type (0 = unlimited)
+	quotaLimit := h.db.GetSettingInt("quota_"+mediaType+"_limit", 0)
+	quotaDays := h.db.GetSettingInt("quota_period_days", 7)
+	quotaUsed := 0
+	if quotaLimit > 0 {
+		var err error
+		quotaUsed, err = h.db.CountRequestsSince(requesterName, mediaType, time.Now().AddDate(0, 0, -quotaDays))
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if quotaUsed >= quotaLimit {
+			h.errorResponse(w, fmt.Sprintf("Request limit reached: %d %s requests per %d days", quotaLimit, mediaType, quotaDays), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Build request object
+	var reqEmail, reqPoster, reqImdbID *string
+	if requesterEmail != "" {
+		reqEmail = &requesterEmail
+	}
+	if poster != "" {
+		reqPoster = &poster
+	}
+	if imdbID != "" {
+		reqImdbID = &imdbID
+	}
+
+	// Keep the ratings the requester saw, they change over time
+	var ratingsSnapshot json.RawMessage
+	ratingsYear, ratingsTmdbID := "", 0
+	if year != nil {
+		ratingsYear = strconv.Itoa(*year)
+	}
+	if tmdbID != nil {
+		ratingsTmdbID = *tmdbID
+	}
+	if ratings, err := h.ratings.GetRatings(r.Context(), title, ratingsYear, mediaType, imdbID, ratingsTmdbID); err == nil && *ratings != (services.RatingsResult{}) {
+		ratingsSnapshot, _ = json.Marshal(ratings)
+	}
+
+	req := &models.Request{
+		RequesterName:   requesterName,
+		RequesterEmail:  reqEmail,
+		MediaType:       mediaType,
+		TmdbID:          tmdbID,
+		TvdbID:          tvdbID,
+		ImdbID:          reqImdbID,
+		Title:           title,
+		Year:            year,
+		Poster:          reqPoster,
+		Episodes:        episodes,
+		Seasons:         seasons,
+		UserID:          userID,
+		Is4K:            is4K,
+		SeriesType:      seriesType,
+		RatingsSnapshot: ratingsSnapshot,
+	}
+
+	requestID, err := h.db.CreateRequest(req)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.ID = int(requestID)
+
+	h.db.LogActivity("request_created", map[string]interface{}{
+		"request_id": requestID,
+		"media_type": mediaType,
+		"title":      title,
+		"requester":  requesterName,
+	})
+
+	h.notifyCreated(r.Context(), req)
+
+	response := map[string]interface{}{
+		"success":   true,
+		"requestId": requestID,
+		"message":   "Request submitted successfully",
+	}
+	if warning != "" {
+		response["warning"] = warning
+	}
+	if autoApprove && h.startAutoApproval(r.Context(), req) {
+		response["autoApproval"] = "pending"
+		response["message"] = "Request submitted, approving automatically"
+	}
+	if quotaLimit > 0 {
+		response["quota"] = map[string]int{
+			"limit":      quotaLimit,
+			"remaining":  quotaLimit - quotaUsed - 1,
+			"periodDays": quotaDays,
+		}
+	}
+
+	h.jsonResponse(w, response)
+}
+
+// missingSeasons splits the requested seasons of a library series into those
+// still missing episodes and those already complete
+func (h *Handler) missingSeasons(ctx context.Context, tvdbID int, seasons []int) ([]int, []int, error) {
+	availability, err := h.sonarr.GetSeasonAvailability(ctx, tvdbID)
+	if err != nil {
+		return nil, nil, err
+	}
+	complete := make(map[int]bool)
+	for _, season := range availability {
+		complete[season.SeasonNumber] = season.Available
+	}
+
+	var missing, available []int
+	for _, season := range seasons {
+		if complete[season] {
+			available = append(available, season)
+		} else {
+			missing = append(missing, season)
+		}
+	}
+	return missing, available, nil
+}
+
+// seasonNames lists seasons for messages, season 0 being the specials
+func seasonNames(seasons []int) string {
+	names := make([]string, len(seasons))
+	for i, season := range seasons {
+		names[i] = fmt.Sprintf("Season %d", season)
+		if season == 0 {
+			names[i] = "Specials"
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// GetSeasonAvailability lists how much of each season of a series is in
+// Sonarr
+func (h *Handler) GetSeasonAvailability(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tvdbID, _ := strconv.Atoi(vars["tvdbId"])
+
+	seasons, err := h.sonarr.GetSeasonAvailability(r.Context(), tvdbID)
+	if err != nil {
+		h.errorResponse(w, "Failed to reach Sonarr: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	inLibrary := seasons != nil
+	if seasons == nil {
+		seasons = []services.SeasonAvailability{}
+	}
+	h.jsonResponse(w, map[string]interface{}{
+		"tvdbId":    tvdbID,
+		"inLibrary": inLibrary,
+		"seasons":   seasons,
+	})
+}
+
+// RequestCollection requests every movie of a TMDB collection that isn't in
+// the library or requested yet, counting each against the requester's quota
+func (h *Handler) RequestCollection(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RequesterName  string `json:"requesterName"`
+		RequesterEmail string `json:"requesterEmail"`
+		CollectionID   int    `json:"collectionId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var userID *int
+	requesterName := body.RequesterName
+	autoApprove := false
+	if sessionID, _ := h.sessionUser(r); sessionID != 0 {
+		user, err := h.db.GetUser(sessionID)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user != nil {
+			userID = &user.ID
+			requesterName = user.Username
+			autoApprove = user.AutoApprove
+		}
+	}
+
+	if h.db.GetSettingBool("maintenance_mode", false) {
+		h.errorResponse(w, "Requests are temporarily disabled for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	if requesterName == "" || body.CollectionID <= 0 {
+		h.errorResponse(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if body.RequesterEmail == "" && h.db.GetSettingBool("require_email", false) {
+		h.errorResponse(w, "Email address is required", http.StatusBadRequest)
+		return
+	}
+
+	collection, err := h.tmdb.GetCollection(r.Context(), body.CollectionID)
+	if err != nil {
+		h.tmdbError(w, err)
+		return
+	}
+
+	type skippedMovie struct {
+		TmdbID int    `json:"tmdbId"`
+		Title  string `json:"title"`
+		Reason string `json:"reason"`
+	}
+	var wanted []services.MediaItem
+	skipped := []skippedMovie{}
+	for _, movie := range collection.Parts {
+		reason := ""
+		switch movie.RequestStatus {
+		case "exists":
+			reason = "Already in library"
+		case "requested":
+			reason = "Already requested"
+		default:
+			tmdbID := movie.TmdbID
+			if status, _ := h.findDuplicateRequest("movie", &tmdbID, nil, nil, false); status == "completed" {
+				reason = "Already completed"
+			} else if status != "" {
+				reason = "Already requested"
+			}
+		}
+		if reason != "" {
+			skipped = append(skipped, skippedMovie{movie.TmdbID, movie.Title, reason})
+			continue
+		}
+		wanted = append(wanted, movie)
+	}
+
+	if len(wanted) == 0 {
+		h.errorResponse(w, "Every movie in this collection is already in the library or requested", http.StatusConflict)
+		return
+	}
+
+	// The whole collection has to fit in the requester's quota
+	quotaLimit := h.db.GetSettingInt("quota_movie_limit", 0)
+	quotaDays := h.db.GetSettingInt("quota_period_days", 7)
+	quotaUsed := 0
+	if quotaLimit > 0 {
+		quotaUsed, err = h.db.CountRequestsSince(requesterName, "movie", time.Now().AddDate(0, 0, -quotaDays))
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if quotaUsed+len(wanted) > quotaLimit {
+			h.errorResponse(w, fmt.Sprintf("Request limit reached: this collection needs %d movie requests but only %d of %d per %d days are left", len(wanted), max(quotaLimit-quotaUsed, 0), quotaLimit, quotaDays), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	var email *string
+	if body.RequesterEmail != "" {
+		email = &body.RequesterEmail
+	}
+
+	type requestedMovie struct {
+		TmdbID       int    `json:"tmdbId"`
+		Title        string `json:"title"`
+		RequestID    int64  `json:"requestId"`
+		AutoApproval string `json:"autoApproval,omitempty"`
+	}
+	requested := []requestedMovie{}
+	var created []*models.Request
+	for _, movie := range wanted {
+		tmdbID := movie.TmdbID
+		req := &models.Request{
+			RequesterName:  requesterName,
+			RequesterEmail: email,
+			MediaType:      "movie",
+			TmdbID:         &tmdbID,
+			Title:          movie.Title,
+			UserID:         userID,
+		}
+		if movie.ImdbID != "" {
+			imdbID := movie.ImdbID
+			req.ImdbID = &imdbID
+		}
+		if movie.Year > 0 {
+			year := movie.Year
+			req.Year = &year
+		}
+		if movie.Poster != "" {
+			poster := movie.Poster
+			req.Poster = &poster
+		}
+
+		requestID, err := h.db.CreateRequest(req)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.ID = int(requestID)
+		created = append(created, req)
+
+		h.db.LogActivity("request_created", map[string]interface{}{
+			"request_id":    requestID,
+			"media_type":    "movie",
+			"title":         req.Title,
+			"requester":     requesterName,
+			"collection_id": collection.ID,
+		})
+		requested = append(requested, requestedMovie{TmdbID: tmdbID, Title: req.Title, RequestID: requestID})
+	}
+
+	h.notifyCollectionRequested(r.Context(), collection, requesterName, created)
+
+	if autoApprove && h.startAutoApproval(r.Context(), created...) {
+		for i := range requested {
+			requested[i].AutoApproval = "pending"
+		}
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"message":   fmt.Sprintf("Requested %d movies from %s", len(requested), collection.Name),
+		"requested": requested,
+		"skipped":   skipped,
+	}
+	if quotaLimit > 0 {
+		response["quota"] = map[string]int{
+			"limit":      quotaLimit,
+			"remaining":  quotaLimit - quotaUsed - len(requested),
+			"periodDays": quotaDays,
+		}
+	}
+
+	h.jsonResponse(w, response)
+}
+
+// notifyCollectionRequested sends a single notification for the movies
+// requested from a collection rather than one per movie
+func (h *Handler) notifyCollectionRequested(ctx context.Context, collection *services.Collection, requester string, requests []*models.Request) {
+	if !h.notify.Enabled(services.EventRequest) {
+		return
+	}
+
+	titles := make([]string, len(requests))
+	for i, req := range requests {
+		titles[i] = "• " + req.Title
+	}
+	message := fmt.Sprintf("**%s** requested %d movies from **%s**\n%s", requester, len(requests), collection.Name, strings.Join(titles, "\n"))
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendEvent(ctx, services.NotifyRequestCreated, "🎬 New Collection Request", message, "", collection.Poster); err != nil {
+			slog.Error("Failed to send collection request notification", "collection_id", collection.ID, "error", err)
+		}
+	})
+}
+
+// findDuplicateRequest returns the status of an existing request blocking a
+// new one for the title. Completed requests block it unless
+// allow_rerequest_completed is set, e.g. to allow requesting upgrades.
+func (h *Handler) findDuplicateRequest(mediaType string, tmdbID, tvdbID *int, seasons []int, is4K bool) (string, error) {
+	includeCompleted := h.db.GetSetting("allow_rerequest_completed") != "true"
+	return h.db.FindDuplicateRequest(mediaType, tmdbID, tvdbID, seasons, is4K, includeCompleted)
+}
+
+// RequestUpgrade asks for the title of a completed request to be grabbed
+// again in better quality. The upgrade is a request of its own; approving
+// it switches the arr's quality profile and searches instead of adding.
+func (h *Handler) RequestUpgrade(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	original, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if original == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	var body struct {
+		RequesterName  string `json:"requesterName"`
+		RequesterEmail string `json:"requesterEmail"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var userID *int
+	requesterName := body.RequesterName
+	if sessionID, _ := h.sessionUser(r); sessionID != 0 {
+		user, err := h.db.GetUser(sessionID)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user != nil {
+			userID = &user.ID
+			requesterName = user.Username
+		}
+	}
+
+	if h.db.GetSettingBool("maintenance_mode", false) {
+		h.errorResponse(w, "Requests are temporarily disabled for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	if requesterName == "" {
+		h.errorResponse(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if original.Status != "completed" {
+		h.errorResponse(w, "Only completed requests can be upgraded", http.StatusBadRequest)
+		return
+	}
+
+	open, err := h.db.HasOpenUpgrade(original.MediaType, original.TmdbID, original.TvdbID, original.Is4K)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if open {
+		h.errorResponse(w, "An upgrade has already been requested", http.StatusConflict)
+		return
+	}
+
+	// Upgrades count against the same quota as new requests
+	quotaLimit := h.db.GetSettingInt("quota_"+original.MediaType+"_limit", 0)
+	quotaDays := h.db.GetSettingInt("quota_period_days", 7)
+	quotaUsed := 0
+	if quotaLimit > 0 {
+		quotaUsed, err = h.db.CountRequestsSince(requesterName, original.MediaType, time.Now().AddDate(0, 0, -quotaDays))
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if quotaUsed >= quotaLimit {
+			h.errorResponse(w, fmt.Sprintf("Request limit reached: %d %s requests per %d days", quotaLimit, original.MediaType, quotaDays), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	var email *string
+	if body.RequesterEmail != "" {
+		email = &body.RequesterEmail
+	}
+	req := &models.Request{
+		RequesterName:  requesterName,
+		RequesterEmail: email,
+		MediaType:      original.MediaType,
+		TmdbID:         original.TmdbID,
+		TvdbID:         original.TvdbID,
+		ImdbID:         original.ImdbID,
+		Title:          original.Title,
+		Year:           original.Year,
+		Poster:         original.Poster,
+		Seasons:        original.Seasons,
+		UserID:         userID,
+		Is4K:           original.Is4K,
+		SeriesType:     original.SeriesType,
+		IsUpgrade:      true,
+	}
+
+	requestID, err := h.db.CreateRequest(req)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req.ID = int(requestID)
+
+	h.db.LogActivity("upgrade_requested", map[string]interface{}{
+		"request_id":  requestID,
+		"original_id": original.ID,
+		"media_type":  req.MediaType,
+		"title":       req.Title,
+		"requester":   requesterName,
+	})
+
+	h.notifyCreated(r.Context(), req)
+
+	response := map[string]interface{}{
+		"success":   true,
+		"requestId": requestID,
+		"message":   "Upgrade requested",
+	}
+	if quotaLimit > 0 {
+		response["quota"] = map[string]int{
+			"limit":      quotaLimit,
+			"remaining":  quotaLimit - quotaUsed - 1,
+			"periodDays": quotaDays,
+		}
+	}
+	h.jsonResponse(w, response)
+}
+
+func (h *Handler) notifyCreated(ctx context.Context, req *models.Request) {
+	if !h.notify.Enabled(services.EventRequest) {
+		return
+	}
+
+	emoji := "📺"
+	typeWord := "Series"
+	if req.MediaType == "movie" {
+		emoji = "🎬"
+		typeWord = "Movie"
+	}
+	heading, action := "Request", "requested"
+	if req.IsUpgrade {
+		heading, action = "Upgrade Request", "asked for a better quality copy of"
+	}
+	title, message := fmt.Sprintf("%s New %s %s", emoji, typeWord, heading), fmt.Sprintf("**%s** %s **%s**", req.RequesterName, action, req.Title)
+	created := *req
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendRequestEvent(ctx, created, services.NotifyRequestCreated, title, message); err != nil {
+			slog.Error("Failed to send request notification", "error", err)
+		}
+	})
+}
+
+// SyncPlexWatchlists requests the titles on the Plex watchlists of users
+// signed in with Plex, when plex_watchlist_sync is enabled
+func (h *Handler) SyncPlexWatchlists(ctx context.Context) {
+	if h.db.GetSetting("plex_watchlist_sync") != "true" {
+		return
+	}
+	// New requests are refused during maintenance, watchlists included
+	if h.db.GetSettingBool("maintenance_mode", false) {
+		slog.Info("Skipping Plex watchlist sync during maintenance")
+		return
+	}
+
+	tokens, err := h.db.GetPlexTokens()
+	if err != nil {
+		slog.Error("Failed to get Plex users", "error", err)
+		return
+	}
+
+	for userID, encrypted := range tokens {
+		if ctx.Err() != nil {
+			return
+		}
+
+		user, err := h.db.GetUser(userID)
+		if err != nil || user == nil {
+			continue
+		}
+		token, err := auth.Decrypt(h.secretKey, encrypted)
+		if err != nil {
+			slog.Warn("Failed to decrypt Plex token", "user", user.Username, "error", err)
+			continue
+		}
+		items, err := h.plex.GetWatchlist(ctx, token)
+		if err != nil {
+			slog.Warn("Failed to get Plex watchlist", "user", user.Username, "error", err)
+			continue
+		}
+
+		created := 0
+		for _, item := range items {
+			if h.requestWatchlistItem(ctx, user, item) {
+				created++
+			}
+		}
+		if created > 0 {
+			slog.Info("Requested Plex watchlist titles", "user", user.Username, "created", created)
+		}
+	}
+}
+
+// requestWatchlistItem requests a watchlist title for user unless it's in
+// the library, pending, or was requested by them before (so rejected titles
+// aren't requested again), returning whether a request was created
+func (h *Handler) requestWatchlistItem(ctx context.Context, user *models.User, item services.PlexWatchlistItem) bool {
+	mediaType, tmdbType := "movie", "movie"
+	if item.Type == "show" {
+		mediaType, tmdbType = "series", "tv"
+	}
+
+	tmdbID := item.TmdbID
+	if tmdbID == 0 && item.ImdbID != "" {
+		tmdbID, _, _ = h.tmdb.FindByIMDbID(ctx, item.ImdbID, tmdbType)
+	}
+	if tmdbID == 0 {
+		slog.Debug("Skipping watchlist title without a TMDB id", "title", item.Title)
+		return false
+	}
+
+	var tvdbID *int
+	if mediaType == "series" {
+		id := item.TvdbID
+		if id == 0 {
+			id = h.tmdb.ResolveExternalIDs(ctx, []int{tmdbID}, "tv")[tmdbID].TvdbID
+		}
+		if id == 0 || !h.sonarr.IsConfigured() {
+			return false
+		}
+		if exists, err := h.sonarr.CheckExists(ctx, id); err != nil || exists {
+			return false
+		}
+		tvdbID = &id
+	} else {
+		if !h.radarr.IsConfigured() {
+			return false
+		}
+		if exists, _ := h.radarr4k.CheckExists(ctx, tmdbID); exists {
+			return false
+		}
+		if exists, err := h.radarr.CheckExists(ctx, tmdbID); err != nil || exists {
+			return false
+		}
+	}
+
+	if status, err := h.findDuplicateRequest(mediaType, &tmdbID, tvdbID, nil, false); err != nil || status != "" {
+		return false
+	}
+	if requested, err := h.db.UserRequestedTitle(user.ID, mediaType, &tmdbID, tvdbID); err != nil || requested {
+		return false
+	}
+
+	// Leave titles over the quota for a later sync
+	if quotaLimit := h.db.GetSettingInt("quota_"+mediaType+"_limit", 0); quotaLimit > 0 {
+		quotaDays := h.db.GetSettingInt("quota_period_days", 7)
+		used, err := h.db.CountRequestsSince(user.Username, mediaType, time.Now().AddDate(0, 0, -quotaDays))
+		if err != nil || used >= quotaLimit {
+			return false
+		}
+	}
+
+	req := &models.Request{
+		RequesterName: user.Username,
+		MediaType:     mediaType,
+		TmdbID:        &tmdbID,
+		TvdbID:        tvdbID,
+		Title:         item.Title,
+		UserID:        &user.ID,
+	}
+	if item.ImdbID != "" {
+		req.ImdbID = &item.ImdbID
+	}
+	if item.Year > 0 {
+		req.Year = &item.Year
+	}
+	if info, err := h.tmdb.LookupTitle(ctx, tmdbType, tmdbID); err == nil {
+		req.Title = info.Title
+		if info.Poster != "" {
+			req.Poster = &info.Poster
+		}
+	}
+	if mediaType == "series" {
+		seriesType := "standard"
+		if anime, _ := h.tmdb.IsAnime(ctx, tmdbID); anime {
+			seriesType = "anime"
+		}
+		req.SeriesType = &seriesType
+	}
+
+	requestID, err := h.db.CreateRequest(req)
+	if err != nil {
+		slog.Error("Failed to create watchlist request", "title", req.Title, "error", err)
+		return false
+	}
+	req.ID = int(requestID)
+
+	h.db.LogActivity("request_created", map[string]interface{}{
+		"request_id": requestID,
+		"media_type": mediaType,
+		"title":      req.Title,
+		"requester":  user.Username,
+		"source":     "plex_watchlist",
+	})
+	h.notifyCreated(ctx, req)
+	if user.AutoApprove {
+		h.autoApprove(ctx, req)
+	}
+	return true
+}
+
+const (
+	defaultRequestsPageSize = 20
+	maxRequestsPageSize     = 100
+	defaultActivityPageSize = 50
+)
+
+func (h *Handler) GetRequests(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	mediaType := r.URL.Query().Get("mediaType")
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if pageSize < 1 {
+		pageSize = defaultRequestsPageSize
+	}
+	if pageSize > maxRequestsPageSize {
+		pageSize = maxRequestsPageSize
+	}
+
+	requests, total, err := h.db.GetRequests(status, mediaType, pageSize, (page-1)*pageSize)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if requests == nil {
+		requests = []models.Request{}
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results":    requests,
+		"page":       page,
+		"pageSize":   pageSize,
+		"total":      total,
+		"totalPages": (total + pageSize - 1) / pageSize,
+	})
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Category    string  `xml:"category"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// RequestsFeed serves the most recent requests as RSS 2.0. Feed readers
+// can't log in, so besides an admin session it accepts ?token=<feed_token>.
+func (h *Handler) RequestsFeed(w http.ResponseWriter, r *http.Request) {
+	token := h.db.GetSetting("feed_token")
+	tokenValid := token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1
+	if _, role := h.sessionUser(r); role != "admin" && !tokenValid {
+		h.errorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 {
+		limit = defaultActivityPageSize
+	}
+	if limit > maxRequestsPageSize {
+		limit = maxRequestsPageSize
+	}
+
+	requests, _, err := h.db.GetRequests(r.URL.Query().Get("status"), "", limit, 0)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	instanceName := h.db.GetSetting("instance_name")
+	if instanceName == "" {
+		instanceName = settingDefaults["instance_name"]
+	}
+
+	// The feed is served from <site>/api/requests/feed.xml, BASE_URL included
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	site := scheme + "://" + r.Host + strings.TrimSuffix(r.URL.Path, "/api/requests/feed.xml") + "/"
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       instanceName + " requests",
+			Link:        site,
+			Description: "Recent media requests",
+			Items:       make([]rssItem, 0, len(requests)),
+		},
+	}
+	for _, req := range requests {
+		title := req.Title
+		if req.Year != nil {
+			title = fmt.Sprintf("%s (%d)", req.Title, *req.Year)
+		}
+		typeWord := "Series"
+		if req.MediaType == "movie" {
+			typeWord = "Movie"
+		}
+		// Each item links to its request, public_url when set like the
+		// notifications, and that link doubles as a guid no other feed has
+		link := h.notify.RequestURL(req.ID)
+		if link == "" {
+			link = fmt.Sprintf("%s#request-%d", site, req.ID)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       title,
+			Link:        link,
+			Description: fmt.Sprintf("%s requested by %s, %s", typeWord, req.RequesterName, req.Status),
+			Category:    req.Status,
+			GUID:        rssGUID{Value: link, IsPermaLink: true},
+			PubDate:     req.CreatedAt.Format(time.RFC1123Z),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		slog.Error("Failed to write requests feed", "error", err)
+	}
+}
+
+func (h *Handler) SearchRequests(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(query) < 2 {
+		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > maxRequestsPageSize {
+		limit = maxRequestsPageSize
+	}
+
+	requests, err := h.db.SearchRequests(query, limit)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if requests == nil {
+		requests = []models.Request{}
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results": requests,
+		"total":   len(requests),
+	})
+}
+
+func (h *Handler) GetMyRequests(w http.ResponseWriter, r *http.Request) {
+	userID, _ := h.sessionUser(r)
+	if userID == 0 {
+		h.errorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	requests, err := h.db.GetRequestsByUser(userID)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if requests == nil {
+		requests = []models.Request{}
+	}
+
+	h.jsonResponse(w, requests)
+}
+
+func (h *Handler) GetRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	// Approved requests include live progress from the arr queue
+	var download *services.QueueStatus
+	if (req.Status == "approved" || req.Status == "downloading") && req.ArrID != nil {
+		download = h.queueStatus(r.Context(), req)
+	}
+
+	comments, err := h.db.GetComments(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, struct {
+		*models.Request
+		Download *services.QueueStatus `json:"download,omitempty"`
+		Comments []models.Comment      `json:"comments"`
+	}{req, download, comments})
+}
+
+const maxCommentLength = 2000
+
+func (h *Handler) GetComments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	comments, err := h.db.GetComments(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, comments)
+}
+
+// participant is someone taking part in a request's comments or issues
+type participant struct {
+	userID  *int
+	name    string
+	isAdmin bool
+}
+
+// requestParticipant works out who is acting on req: an admin, the account
+// that made it, or for requests made without an account, anyone giving the
+// requester's name. It writes the error response when they may not.
+func (h *Handler) requestParticipant(w http.ResponseWriter, r *http.Request, req *models.Request, requesterName string) (*participant, bool) {
+	p := &participant{name: strings.TrimSpace(requesterName)}
+	if sessionID, role := h.sessionUser(r); sessionID != 0 {
+		user, err := h.db.GetUser(sessionID)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		if user != nil {
+			p.userID = &user.ID
+			p.name = user.Username
+			p.isAdmin = role == "admin"
+		}
+	}
+
+	switch {
+	case p.isAdmin:
+	case req.UserID != nil:
+		if p.userID == nil || *p.userID != *req.UserID {
+			h.errorResponse(w, "Only the requester can do this", http.StatusForbidden)
+			return nil, false
+		}
+	case p.name == "":
+		h.errorResponse(w, "Missing required fields", http.StatusBadRequest)
+		return nil, false
+	case !strings.EqualFold(p.name, req.RequesterName):
+		h.errorResponse(w, "Only the requester can do this", http.StatusForbidden)
+		return nil, false
+	}
+	return p, true
+}
+
+// AddComment adds to a request's discussion thread, for admins and the
+// requester
+func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	var body struct {
+		RequesterName string `json:"requesterName"`
+		Body          string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body.Body = strings.TrimSpace(body.Body)
+	if body.Body == "" {
+		h.errorResponse(w, "Comment cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(body.Body)) > maxCommentLength {
+		h.errorResponse(w, fmt.Sprintf("Comments are limited to %d characters", maxCommentLength), http.StatusBadRequest)
+		return
+	}
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	author, ok := h.requestParticipant(w, r, req, body.RequesterName)
+	if !ok {
+		return
+	}
+
+	commentID, err := h.db.AddComment(id, author.userID, author.name, author.isAdmin, body.Body)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.db.LogActivity("request_comment", map[string]interface{}{
+		"request_id": id,
+		"comment_id": commentID,
+		"title":      req.Title,
+		"author":     author.name,
+	})
+
+	h.notifyComment(r.Context(), req, author.name, author.isAdmin, body.Body)
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success":   true,
+		"commentId": commentID,
+	})
+}
+
+// notifyComment lets the other side of the conversation know about a new
+// comment: the requester when an admin commented, the admins otherwise
+func (h *Handler) notifyComment(ctx context.Context, req *models.Request, author string, isAdmin bool, body string) {
+	if !h.notify.Enabled(services.EventComment) {
+		return
+	}
+
+	title := "💬 New Comment"
+	commented := *req
+	if isAdmin {
+		message := fmt.Sprintf("**%s** commented on your request for **%s**:\n%s", author, req.Title, body)
+		h.goBackground(ctx, func(ctx context.Context) {
+			if err := h.notify.SendToRequester(ctx, commented, services.NotifyRequestComment, title, message); err != nil {
+				slog.Error("Failed to notify requester of comment", "request_id", commented.ID, "error", err)
+			}
+		})
+		return
+	}
+
+	message := fmt.Sprintf("**%s** commented on **%s**:\n%s", author, req.Title, body)
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendRequestEvent(ctx, commented, services.NotifyRequestComment, title, message); err != nil {
+			slog.Error("Failed to send comment notification", "request_id", commented.ID, "error", err)
+		}
+	})
+}
+
+// Kinds of problems that can be reported with completed media
+var issueTypes = []string{"video", "audio", "subtitle", "wrong"}
+
+var issueTypeLabels = map[string]string{
+	"video":    "Video",
+	"audio":    "Audio",
+	"subtitle": "Subtitles",
+	"wrong":    "Wrong media",
+}
+
+// ReportIssue files a problem with the media of a completed request, for
+// admins and the requester. With issue_auto_search on, the arr is asked to
+// search for the title again.
+func (h *Handler) ReportIssue(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	var body struct {
+		RequesterName string `json:"requesterName"`
+		Type          string `json:"type"`
+		Description   string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if issueTypeLabels[body.Type] == "" {
+		h.errorResponse(w, "Invalid issue type, expected one of: "+strings.Join(issueTypes, ", "), http.StatusBadRequest)
+		return
+	}
+	body.Description = strings.TrimSpace(body.Description)
+	if len([]rune(body.Description)) > maxCommentLength {
+		h.errorResponse(w, fmt.Sprintf("Descriptions are limited to %d characters", maxCommentLength), http.StatusBadRequest)
+		return
+	}
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	reporter, ok := h.requestParticipant(w, r, req, body.RequesterName)
+	if !ok {
+		return
+	}
+	if req.Status != "completed" {
+		h.errorResponse(w, "Issues can only be reported for completed requests", http.StatusBadRequest)
+		return
+	}
+
+	open, err := h.db.HasOpenIssue(id, body.Type)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if open {
+		h.errorResponse(w, "This issue has already been reported", http.StatusConflict)
+		return
+	}
+
+	issue := &models.Issue{
+		RequestID:   id,
+		UserID:      reporter.userID,
+		Reporter:    reporter.name,
+		IssueType:   body.Type,
+		Description: body.Description,
+	}
+	issueID, err := h.db.CreateIssue(issue)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	issue.ID = int(issueID)
+
+	h.db.LogActivity("issue_reported", map[string]interface{}{
+		"issue_id":   issueID,
+		"request_id": id,
+		"title":      req.Title,
+		"type":       body.Type,
+		"reporter":   reporter.name,
+	})
+
+	searchTriggered := false
+	if h.db.GetSettingBool("issue_auto_search", false) {
+		if err := h.searchAgain(r.Context(), req); err != nil {
+			slog.Error("Failed to search again for reported issue", "request_id", id, "error", err)
+		} else {
+			searchTriggered = true
+		}
+	}
+
+	h.notifyIssueReported(r.Context(), req, issue)
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success":         true,
+		"issueId":         issueID,
+		"searchTriggered": searchTriggered,
+	})
+}
+
+// searchAgain has the arr holding req's title search for a new release. The
+// file on disk stays, Sonarr and Radarr only replace it with a release that
+// is an upgrade, so this doesn't promise a fix.
+func (h *Handler) searchAgain(ctx context.Context, req *models.Request) error {
+	if req.ArrID == nil {
+		return fmt.Errorf("Request has no arr id")
+	}
+	if req.MediaType == "movie" {
+		return h.radarrFor(req).SearchMovie(ctx, *req.ArrID)
+	}
+	return h.sonarr.SearchSeries(ctx, *req.ArrID)
+}
+
+func (h *Handler) notifyIssueReported(ctx context.Context, req *models.Request, issue *models.Issue) {
+	if !h.notify.Enabled(services.EventIssue) {
+		return
+	}
+
+	message := fmt.Sprintf("**%s** reported a problem with **%s**: %s", issue.Reporter, req.Title, issueTypeLabels[issue.IssueType])
+	if issue.Description != "" {
+		message += "\n" + issue.Description
+	}
+	reported, issueID := *req, issue.ID
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendRequestEvent(ctx, reported, services.NotifyIssueReported, "⚠️ New Issue", message); err != nil {
+			slog.Error("Failed to send issue notification", "issue_id", issueID, "error", err)
+		}
+	})
+}
+
+func (h *Handler) GetIssues(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "open" && status != "resolved" {
+		h.errorResponse(w, "Invalid status, expected open or resolved", http.StatusBadRequest)
+		return
+	}
+
+	issues, err := h.db.GetIssues(status)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonResponse(w, issues)
+}
+
+// ResolveIssue closes an issue and lets the reporter know
+func (h *Handler) ResolveIssue(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	issue, err := h.db.GetIssue(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if issue == nil {
+		h.errorResponse(w, "Issue not found", http.StatusNotFound)
+		return
+	}
+	if issue.Status == "resolved" {
+		h.errorResponse(w, "Issue already resolved", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := h.sessionUser(r)
+	if err := h.db.ResolveIssue(id, userID); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.db.LogActivity("issue_resolved", map[string]interface{}{
+		"issue_id":   id,
+		"request_id": issue.RequestID,
+		"title":      issue.Title,
+	})
+
+	if req, err := h.db.GetRequest(issue.RequestID); err == nil && req != nil && h.notify.Enabled(services.EventIssue) {
+		message := fmt.Sprintf("The %s issue you reported with **%s** has been resolved", strings.ToLower(issueTypeLabels[issue.IssueType]), req.Title)
+		h.goBackground(r.Context(), func(ctx context.Context) {
+			if err := h.notify.SendToRequester(ctx, *req, services.NotifyIssueResolved, "✅ Issue Resolved", message); err != nil {
+				slog.Error("Failed to notify requester of resolved issue", "issue_id", id, "error", err)
+			}
+		})
+	}
+
+	h.jsonResponse(w, map[string]interface{}{"success": true})
+}
+
+const queueCacheTTL = 15 * time.Second
+
+// queueStatus looks up req in the Sonarr/Radarr queue, caching each queue
+// briefly so clients polling request details don't hammer the arr instances
+func (h *Handler) queueStatus(ctx context.Context, req *models.Request) *services.QueueStatus {
+	cacheKey, idField, fetch := "queue_sonarr", "seriesId", h.sonarr.GetQueue
+	if req.MediaType == "movie" {
+		cacheKey, idField, fetch = "queue_radarr", "movieId", h.radarrFor(req).GetQueue
+		if req.Is4K {
+			cacheKey = "queue_radarr_4k"
+		}
+	}
+
+	var records []map[string]interface{}
+	if cached, found := h.cache.Get(cacheKey); found {
+		records = cached.([]map[string]interface{})
+	} else {
+		var err error
+		records, err = fetch(ctx)
+		if err != nil {
+			slog.Error("Failed to fetch queue", "key", cacheKey, "error", err)
+			return nil
+		}
+		h.cache.SetWithTTL(cacheKey, records, queueCacheTTL)
+	}
+
+	return services.SummarizeQueue(records, idField, *req.ArrID)
+}
+
+func (h *Handler) UpdateRequestStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	var data struct {
+		Status     string `json:"status"`
+		AdminNotes string `json:"adminNotes"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	validStatuses := map[string]bool{"pending": true, "approved": true, "downloading": true, "rejected": true, "completed": true}
+	if !validStatuses[data.Status] {
+		h.errorResponse(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.UpdateRequestStatus(id, data.Status, data.AdminNotes); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.db.LogActivity("request_status_updated", map[string]interface{}{
+		"request_id": id,
+		"new_status": data.Status,
+	})
+
+	if data.Status == "rejected" {
+		h.notifyRejected(r.Context(), id)
+	}
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+func (h *Handler) RejectRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	var data struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	data.Reason = strings.TrimSpace(data.Reason)
+	if data.Reason == "" {
+		h.errorResponse(w, "A rejection reason is required", http.StatusBadRequest)
+		return
+	}
+
+	// Holding the approval lock keeps an approval from undoing the rejection
+	if !h.approvals.acquire(id) {
+		h.errorResponse(w, "Request is being approved", http.StatusConflict)
+		return
+	}
+	defer h.approvals.release(id)
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+	// Anything past pending is in Sonarr/Radarr already
+	if req.Status != "pending" {
+		h.errorResponse(w, "Only pending requests can be rejected", http.StatusConflict)
+		return
+	}
+
+	if err := h.db.UpdateRequestStatus(id, "rejected", data.Reason); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.db.LogActivity("request_rejected", map[string]interface{}{
+		"request_id": id,
+		"title":      req.Title,
+		"reason":     data.Reason,
+	})
+
+	h.notifyRejected(r.Context(), id)
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// notifyRejected tells the requester why their request was rejected and
+// records when they were notified
+func (h *Handler) notifyRejected(ctx context.Context, id int) {
+	if !h.notify.Enabled(services.EventReject) {
+		return
+	}
+
+	req, err := h.db.GetRequest(id)
+	if err != nil || req == nil {
+		return
+	}
+
+	typeWord := "Series"
+	if req.MediaType == "movie" {
+		typeWord = "Movie"
+	}
+
+	message := fmt.Sprintf("**%s** requested by %s was not approved", req.Title, req.RequesterName)
+	personal := fmt.Sprintf("Your request for **%s** was not approved", req.Title)
+	if req.AdminNotes != nil && *req.AdminNotes != "" {
+		message += fmt.Sprintf("\n**Reason:** %s", *req.AdminNotes)
+		personal += fmt.Sprintf("\n**Reason:** %s", *req.AdminNotes)
+	}
+
+	title := fmt.Sprintf("❌ %s Rejected", typeWord)
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendRequestEvent(ctx, *req, services.NotifyRequestRejected, title, message); err != nil {
+			slog.Error("Failed to send rejection notification", "request_id", req.ID, "error", err)
+		}
+		if err := h.notify.SendToRequester(ctx, *req, services.NotifyRequestRejected, title, personal); err != nil {
+			slog.Error("Failed to notify requester of rejection", "request_id", req.ID, "error", err)
+		}
+		h.db.MarkRequestNotified(id)
+	})
+}
+
+func (h *Handler) ApproveRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	// Parse with flexible types
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	opts := approvalOptions{}
+	opts.RootFolder, _ = raw["rootFolder"].(string)
+	opts.Monitor, _ = raw["monitor"].(string)
+	opts.MinimumAvailability, _ = raw["minimumAvailability"].(string)
+	opts.SeriesType, _ = raw["seriesType"].(string)
+	if is4K, ok := raw["is4k"].(bool); ok && req.MediaType == "movie" && is4K != req.Is4K {
+		req.Is4K = is4K
+		h.db.UpdateRequest4K(id, is4K)
+	}
+
+	// Handle qualityProfile - could be string or number
+	if qp, ok := raw["qualityProfile"].(float64); ok {
+		opts.QualityProfileID = int(qp)
+	} else if qp, ok := raw["qualityProfile"].(string); ok && qp != "" {
+		opts.QualityProfileID, _ = strconv.Atoi(qp)
+	}
+
+	// Optional, Sonarr v4 has no language profiles
+	if lp, ok := raw["languageProfile"].(float64); ok {
+		opts.LanguageProfileID = int(lp)
+	} else if lp, ok := raw["languageProfile"].(string); ok && lp != "" {
+		opts.LanguageProfileID, _ = strconv.Atoi(lp)
+	}
+
+	// Let the arr calls finish if the client goes away, an approval
+	// cut off halfway would leave the request pending but in the library
+	approvedBy, _ := h.sessionUser(r)
+	arrID, err := h.approve(context.WithoutCancel(r.Context()), req, approvedBy, opts)
+	if errors.Is(err, errAlreadyApproved) {
+		h.jsonResponse(w, map[string]interface{}{
+			"success":         true,
+			"arrId":           arrID,
+			"alreadyApproved": true,
+		})
+		return
+	}
+	if err != nil {
+		status := arrErrorStatus(err)
+		if ae, ok := err.(*approvalError); ok {
+			status = ae.status
+		}
+		h.errorResponse(w, err.Error(), status)
+		return
+	}
+
+	h.db.LogActivity("request_approved", map[string]interface{}{
+		"request_id":  id,
+		"title":       req.Title,
+		"arr_id":      arrID,
+		"approved_by": approvedBy,
+	})
+	h.notifyApproved(r.Context(), req)
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success": true,
+		"arrId":   arrID,
+	})
+}
+
+// startAutoApproval auto-approves reqs in the background, one after another,
+// so adding them to Sonarr/Radarr doesn't hold up the response. It returns
+// false without starting when the approval defaults are missing, since the
+// requests would stay pending anyway.
+func (h *Handler) startAutoApproval(ctx context.Context, reqs ...*models.Request) bool {
+	for _, req := range reqs {
+		opts := h.withApprovalDefaults(req, approvalOptions{})
+		if opts.RootFolder == "" || opts.QualityProfileID == 0 {
+			return false
+		}
+	}
+
+	// Tracked so shutdown waits for the approval to be recorded before
+	// closing the database
+	h.goBackground(ctx, func(ctx context.Context) {
+		for _, req := range reqs {
+			h.autoApprove(ctx, req)
+		}
+	})
+	return true
+}
+
+// autoApprove approves a trusted user's request with the default root folder
+// and quality profile. The request stays pending when no defaults are
+// configured or the approval fails.
+func (h *Handler) autoApprove(ctx context.Context, req *models.Request) bool {
+	opts := h.withApprovalDefaults(req, approvalOptions{})
+	if opts.RootFolder == "" || opts.QualityProfileID == 0 {
+		return false
+	}
+
+	arrID, err := h.approve(ctx, req, 0, opts)
+	if err != nil {
+		slog.Warn("Auto-approval failed, leaving request pending", "request_id", req.ID, "title", req.Title, "error", err)
+		return false
+	}
+
+	h.db.LogActivity("request_auto_approved", map[string]interface{}{
+		"request_id": req.ID,
+		"title":      req.Title,
+		"requester":  req.RequesterName,
+		"arr_id":     arrID,
+	})
+	h.notifyApproved(ctx, req)
+	return true
+}
+
+// approvalOptions are the choices made when approving a request
+type approvalOptions struct {
+	RootFolder          string
+	QualityProfileID    int
+	LanguageProfileID   int
+	Monitor             string
+	MinimumAvailability string
+	SeriesType          string
+}
+
+// approvalError is an approval failure with the HTTP status it maps to
+type approvalError struct {
+	message string
+	status  int
+}
+
+func (e *approvalError) Error() string {
+	return e.message
+}
+
+// errAlreadyApproved is returned with the existing arr id when approving a
+// request that has already been added
+var errAlreadyApproved = errors.New("Request already approved")
+
+// approvalLocks tracks the requests being approved, so a repeated approval
+// can't add the title again while the first is still talking to the arr
+type approvalLocks struct {
+	mu       sync.Mutex
+	inFlight map[int]bool
+}
+
+func (l *approvalLocks) acquire(id int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[id] {
+		return false
+	}
+	l.inFlight[id] = true
+	return true
+}
+
+func (l *approvalLocks) release(id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.inFlight, id)
+}
+
+// arrOptionLister lists the root folders and quality profiles an arr
+// accepts, implemented by the Sonarr and Radarr services
+type arrOptionLister interface {
+	GetRootFolders(ctx context.Context) ([]map[string]interface{}, error)
+	GetQualityProfiles(ctx context.Context) ([]map[string]interface{}, error)
+}
+
+// validateArrOptions checks the root folder and quality profile against
+// the ones the arr has, so a typo fails with the valid choices rather than
+// an arr validation error
+func validateArrOptions(ctx context.Context, arr arrOptionLister, name string, opts approvalOptions) error {
+	if err := validateRootFolder(ctx, arr, name, opts.RootFolder); err != nil {
+		return err
+	}
+	return validateQualityProfile(ctx, arr, name, opts.QualityProfileID)
+}
+
+func validateRootFolder(ctx context.Context, arr arrOptionLister, name, rootFolder string) error {
+	rootFolders, err := arr.GetRootFolders(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get %s root folders: %w", name, err)
+	}
+	paths := make([]string, 0, len(rootFolders))
+	found := false
+	for _, folder := range rootFolders {
+		path, _ := folder["path"].(string)
+		paths = append(paths, path)
+		if strings.TrimRight(path, "/") == strings.TrimRight(rootFolder, "/") {
+			found = true
+		}
+	}
+	if !found {
+		return &approvalError{fmt.Sprintf("Root folder %q not found in %s, expected one of: %s", rootFolder, name, strings.Join(paths, ", ")), http.StatusBadRequest}
+	}
+	return nil
+}
+
+func validateQualityProfile(ctx context.Context, arr arrOptionLister, name string, qualityProfileID int) error {
+	profiles, err := arr.GetQualityProfiles(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get %s quality profiles: %w", name, err)
+	}
+	choices := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		id, _ := profile["id"].(float64)
+		if int(id) == qualityProfileID {
+			return nil
+		}
+		profileName, _ := profile["name"].(string)
+		choices = append(choices, fmt.Sprintf("%d (%s)", int(id), profileName))
+	}
+	return &approvalError{fmt.Sprintf("Quality profile %d not found in %s, expected one of: %s", qualityProfileID, name, strings.Join(choices, ", ")), http.StatusBadRequest}
+}
+
+// withApprovalDefaults fills in options left empty from the requester's
+// profile mapping and the default_* settings. 4K movies use the _movie_4k
+// defaults since the second Radarr has its own folders and profiles.
+func (h *Handler) withApprovalDefaults(req *models.Request, opts approvalOptions) approvalOptions {
+	defaults := req.MediaType
+	if req.Is4K {
+		defaults = "movie_4k"
+	}
+
+	if opts.RootFolder == "" {
+		opts.RootFolder = h.db.GetSetting("default_root_folder_" + defaults)
+	}
+	if opts.QualityProfileID == 0 {
+		opts.QualityProfileID = h.requesterQualityProfile(req.RequesterName, req.MediaType)
+	}
+	if opts.QualityProfileID == 0 {
+		opts.QualityProfileID = h.db.GetSettingInt("default_quality_profile_"+defaults, 0)
+	}
+	if opts.Monitor == "" {
+		opts.Monitor = h.db.GetSetting("default_series_monitor")
+	}
+	if opts.MinimumAvailability == "" {
+		opts.MinimumAvailability = h.db.GetSetting("default_minimum_availability")
+	}
+	return opts
+}
+
+// approve adds a request to Sonarr or Radarr and marks it approved by the
+// given user (0 when automatic), returning the arr's id for it. Approving a
+// request again returns its arr id with errAlreadyApproved instead of adding
+// it twice.
+func (h *Handler) approve(ctx context.Context, req *models.Request, approvedBy int, opts approvalOptions) (int, error) {
+	if !h.approvals.acquire(req.ID) {
+		return 0, &approvalError{"Request is already being approved", http.StatusConflict}
+	}
+	defer h.approvals.release(req.ID)
+
+	// Re-read it, an earlier approval may have finished since req was loaded
+	current, err := h.db.GetRequest(req.ID)
+	if err != nil {
+		return 0, err
+	}
+	if current == nil {
+		return 0, &approvalError{"Request not found", http.StatusNotFound}
+	}
+	if current.ArrID != nil || current.Status == "approved" || current.Status == "downloading" || current.Status == "completed" {
+		arrID := 0
+		if current.ArrID != nil {
+			arrID = *current.ArrID
+		}
+		return arrID, errAlreadyApproved
+	}
+
+	if req.IsUpgrade {
+		return h.approveUpgrade(ctx, req, approvedBy, opts)
+	}
+
+	// Seasons missing from a series already in Sonarr are monitored there
+	// rather than adding the series
+	if req.MediaType == "series" && len(req.Seasons) > 0 && req.TvdbID != nil {
+		series, err := h.sonarr.FindSeries(ctx, *req.TvdbID)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to find series in Sonarr: %w", err)
+		}
+		if series != nil {
+			return h.approveSeasons(ctx, req, series, approvedBy)
+		}
+	}
+
+	opts = h.withApprovalDefaults(req, opts)
+
+	if opts.RootFolder == "" {
+		return 0, &approvalError{"Root folder required", http.StatusBadRequest}
+	}
+	if opts.QualityProfileID == 0 {
+		return 0, &approvalError{"Quality profile required", http.StatusBadRequest}
+	}
+	if err := h.checkActiveDownloads(); err != nil {
+		return 0, err
+	}
+
+	var arrID int
+	var monitor string
+	if req.MediaType == "series" {
+		if req.TvdbID == nil {
+			return 0, &approvalError{"No TVDB ID for series", http.StatusBadRequest}
+		}
+		monitor = opts.Monitor
+		if monitor == "" {
+			monitor = "all"
+		}
+		if !services.IsValidSeriesMonitor(monitor) {
+			return 0, &approvalError{"Invalid monitor option, expected one of: " + strings.Join(services.SeriesMonitorOptions, ", "), http.StatusBadRequest}
+		}
+		seriesType := opts.SeriesType
+		if seriesType != "" {
+			if !services.IsValidSeriesType(seriesType) {
+				return 0, &approvalError{"Invalid series type, expected one of: " + strings.Join(services.SeriesTypes, ", "), http.StatusBadRequest}
+			}
+			h.db.UpdateRequestSeriesType(req.ID, seriesType)
+		} else if req.SeriesType != nil {
+			seriesType = *req.SeriesType
+		} else {
+			seriesType = "standard"
+		}
+		if err := validateArrOptions(ctx, h.sonarr, "Sonarr", opts); err != nil {
+			return 0, err
+		}
+		if len(req.Episodes) > 0 {
+			if err := h.sonarr.CheckEpisodeSeasons(ctx, *req.TvdbID, req.Episodes); err != nil {
+				return 0, &approvalError{"Episodes could not be requested: " + err.Error(), http.StatusBadRequest}
+			}
+		}
+		var result map[string]interface{}
+		var err error
+		if len(req.Episodes) > 0 {
+			result, err = h.sonarr.AddSeriesUnmonitored(ctx, *req.TvdbID, opts.RootFolder, opts.QualityProfileID, opts.LanguageProfileID, seriesType)
+		} else {
+			result, err = h.sonarr.AddSeries(ctx, *req.TvdbID, opts.RootFolder, opts.QualityProfileID, opts.LanguageProfileID, seriesType, monitor, req.Seasons)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Failed to add to Sonarr: %w", err)
+		}
+		if id, ok := result["id"].(float64); ok {
+			arrID = int(id)
+		}
+		if len(req.Episodes) > 0 {
+			if err := h.sonarr.MonitorEpisodes(ctx, arrID, req.Episodes); err != nil {
+				// Take the unmonitored series out again, or keep track of it
+				// when that fails too
+				if deleteErr := h.sonarr.DeleteSeries(context.WithoutCancel(ctx), arrID, false); deleteErr != nil {
+					h.db.UpdateRequestArrID(req.ID, arrID)
+					return 0, &approvalError{"Series added but episodes could not be monitored: " + err.Error(), http.StatusBadRequest}
+				}
+				return 0, &approvalError{"Episodes could not be monitored: " + err.Error(), http.StatusBadRequest}
+			}
+		}
+	} else {
+		if req.TmdbID == nil {
+			return 0, &approvalError{"No TMDB ID for movie", http.StatusBadRequest}
+		}
+		minimumAvailability := opts.MinimumAvailability
+		if minimumAvailability == "" {
+			minimumAvailability = "announced"
+		}
+		if !services.IsValidMinimumAvailability(minimumAvailability) {
+			return 0, &approvalError{"Invalid minimum availability, expected one of: " + strings.Join(services.MinimumAvailabilityOptions, ", "), http.StatusBadRequest}
+		}
+		radarr := h.radarrFor(req)
+		if err := validateArrOptions(ctx, radarr, "Radarr", opts); err != nil {
+			return 0, err
+		}
+		result, err := radarr.AddMovie(ctx, *req.TmdbID, opts.RootFolder, opts.QualityProfileID, minimumAvailability)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to add to Radarr: %w", err)
+		}
+		if id, ok := result["id"].(float64); ok {
+			arrID = int(id)
+		}
+	}
+
+	h.db.UpdateRequestStatus(req.ID, "approved", "")
+	h.db.UpdateRequestArrID(req.ID, arrID)
+	h.db.UpdateRequestApproval(req.ID, approvedBy, opts.RootFolder, opts.QualityProfileID, monitor)
+	return arrID, nil
+}
+
+// approveUpgrade moves the title already in Sonarr or Radarr to the chosen
+// quality profile and searches for a better release. The profile has to be
+// picked explicitly, the defaults are what the title was added with.
+func (h *Handler) approveUpgrade(ctx context.Context, req *models.Request, approvedBy int, opts approvalOptions) (int, error) {
+	if opts.QualityProfileID == 0 {
+		return 0, &approvalError{"Quality profile required", http.StatusBadRequest}
+	}
+	if err := h.checkActiveDownloads(); err != nil {
+		return 0, err
+	}
+
+	var arrID int
+	if req.MediaType == "series" {
+		if req.TvdbID == nil {
+			return 0, &approvalError{"No TVDB ID for series", http.StatusBadRequest}
+		}
+		if err := validateQualityProfile(ctx, h.sonarr, "Sonarr", opts.QualityProfileID); err != nil {
+			return 0, err
+		}
+		series, err := h.sonarr.FindSeries(ctx, *req.TvdbID)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to find series in Sonarr: %w", err)
+		}
+		if series == nil {
+			return 0, &approvalError{"Series is no longer in Sonarr", http.StatusNotFound}
+		}
+		if id, ok := series["id"].(float64); ok {
+			arrID = int(id)
+		}
+		if err := h.sonarr.UpgradeSeries(ctx, arrID, opts.QualityProfileID); err != nil {
+			return 0, fmt.Errorf("Failed to upgrade in Sonarr: %w", err)
+		}
+	} else {
+		if req.TmdbID == nil {
+			return 0, &approvalError{"No TMDB ID for movie", http.StatusBadRequest}
+		}
+		radarr := h.radarrFor(req)
+		if err := validateQualityProfile(ctx, radarr, "Radarr", opts.QualityProfileID); err != nil {
+			return 0, err
+		}
+		movie, err := radarr.FindMovie(ctx, *req.TmdbID)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to find movie in Radarr: %w", err)
+		}
+		if movie == nil {
+			return 0, &approvalError{"Movie is no longer in Radarr", http.StatusNotFound}
+		}
+		if id, ok := movie["id"].(float64); ok {
+			arrID = int(id)
+		}
+		if err := radarr.UpgradeMovie(ctx, arrID, opts.QualityProfileID); err != nil {
+			return 0, fmt.Errorf("Failed to upgrade in Radarr: %w", err)
+		}
+	}
+
+	h.db.UpdateRequestStatus(req.ID, "approved", "")
+	h.db.UpdateRequestArrID(req.ID, arrID)
+	h.db.UpdateRequestApproval(req.ID, approvedBy, "", opts.QualityProfileID, "")
+	return arrID, nil
+}
+
+// approveSeasons monitors and searches for the requested seasons of a series
+// already in Sonarr, keeping its root folder and quality profile
+func (h *Handler) approveSeasons(ctx context.Context, req *models.Request, series map[string]interface{}, approvedBy int) (int, error) {
+	if err := h.checkActiveDownloads(); err != nil {
+		return 0, err
+	}
+
+	id, _ := series["id"].(float64)
+	arrID := int(id)
+	if err := h.sonarr.MonitorSeasons(ctx, arrID, req.Seasons); err != nil {
+		return 0, fmt.Errorf("Failed to monitor seasons in Sonarr: %w", err)
+	}
+
+	rootFolder, _ := series["rootFolderPath"].(string)
+	qualityProfile, _ := series["qualityProfileId"].(float64)
+	h.db.UpdateRequestStatus(req.ID, "approved", "")
+	h.db.UpdateRequestArrID(req.ID, arrID)
+	h.db.UpdateRequestApproval(req.ID, approvedBy, rootFolder, int(qualityProfile), "")
+	return arrID, nil
+}
+
+// checkActiveDownloads enforces the cap on concurrently downloading items
+// (0 = unlimited)
+func (h *Handler) checkActiveDownloads() error {
+	maxActive := h.db.GetSettingInt("max_active_downloads", 0)
+	if maxActive <= 0 {
+		return nil
+	}
+	active, err := h.db.CountActiveDownloads()
+	if err != nil {
+		return err
+	}
+	if active >= maxActive {
+		return &approvalError{fmt.Sprintf("Maximum of %d active downloads reached, try again once some complete", maxActive), http.StatusTooManyRequests}
+	}
+	return nil
+}
+
+func (h *Handler) notifyApproved(ctx context.Context, req *models.Request) {
+	if !h.notify.Enabled(services.EventApprove) {
+		return
+	}
+
+	emoji := "📺"
+	typeWord := "Series"
+	if req.MediaType == "movie" {
+		emoji = "🎬"
+		typeWord = "Movie"
+	}
+	title := fmt.Sprintf("%s %s Approved", emoji, typeWord)
+	message := fmt.Sprintf("**%s** has been approved and is being downloaded!", req.Title)
+	approved := *req
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendRequestEvent(ctx, approved, services.NotifyRequestApproved, title, message); err != nil {
+			slog.Error("Failed to send approval notification", "request_id", approved.ID, "error", err)
+		}
+		if err := h.notify.SendToRequester(ctx, approved, services.NotifyRequestApproved, title, message); err != nil {
+			slog.Error("Failed to notify requester of approval", "request_id", approved.ID, "error", err)
+		}
+	})
+}
+
+func (h *Handler) DeleteRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	req, err := h.db.GetRequest(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	removeFromArr := r.URL.Query().Get("removeFromArr") == "true"
+	if removeFromArr && req.ArrID != nil {
+		// Removing the title would pull it out from under the other requests
+		shared, err := h.db.ArrIDShared(req.ID, req.MediaType, *req.ArrID, req.Is4K)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if shared {
+			h.errorResponse(w, "Other requests use this title in the library, delete the request without removeFromArr", http.StatusConflict)
+			return
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+		if req.MediaType == "series" {
+			err = h.sonarr.DeleteSeries(ctx, *req.ArrID, false)
+		} else {
+			err = h.radarrFor(req).DeleteMovie(ctx, *req.ArrID, false)
+		}
+		if err != nil {
+			h.errorResponse(w, "Failed to remove from library: "+err.Error(), arrErrorStatus(err))
+			return
+		}
+	}
+
+	if err := h.db.DeleteRequest(id); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.db.LogActivity("request_deleted", map[string]interface{}{
+		"request_id":       id,
+		"title":            req.Title,
+		"removed_from_arr": removeFromArr && req.ArrID != nil,
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// radarrFor returns the Radarr instance a movie request belongs to
+func (h *Handler) radarrFor(req *models.Request) *services.RadarrService {
+	if req.Is4K {
+		return h.radarr4k
+	}
+	return h.radarr
+}
+
+// requesterQualityProfile looks up the quality profile mapped to a requester
+// in the requester_profile_map setting, e.g. {"alice": {"series": 4, "movie": 6}}.
+// Returns 0 when there is no mapping.
+func (h *Handler) requesterQualityProfile(requester, mediaType string) int {
+	profiles, err := parseRequesterProfileMap(h.db.GetSetting("requester_profile_map"))
+	if err != nil {
+		return 0
+	}
+
+	for name, byType := range profiles {
+		if strings.EqualFold(name, requester) {
+			return byType[mediaType]
+		}
+	}
+	return 0
+}
+
+func parseRequesterProfileMap(value string) (map[string]map[string]int, error) {
+	profiles := make(map[string]map[string]int)
+	if value == "" {
+		return profiles, nil
+	}
+	if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// Admin
+func (h *Handler) AdminCheck(w http.ResponseWriter, r *http.Request) {
+	userID, role := h.sessionUser(r)
+	h.jsonResponse(w, map[string]interface{}{
+		"isAdmin":  role == "admin",
+		"loggedIn": userID != 0,
+		"role":     role,
+	})
+}
+
+const (
+	maxLoginFailures   = 5
+	loginFailureWindow = 15 * time.Minute
+)
+
+// loginLimiter counts failed logins per client IP
+type loginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+// blocked reports whether ip has used up its attempts in the current window
+func (l *loginLimiter) blocked(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[ip]
+	return ok && time.Since(f.since) < loginFailureWindow && f.count >= maxLoginFailures
+}
+
+// fail records a failed login and returns the failures in the current window
+func (l *loginLimiter) fail(ip string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, f := range l.failures {
+		if now.Sub(f.since) >= loginFailureWindow {
+			delete(l.failures, key)
+		}
+	}
+
+	f, ok := l.failures[ip]
+	if !ok {
+		f = &loginFailures{since: now}
+		l.failures[ip] = f
+	}
+	f.count++
+	return f.count
+}
+
+func (l *loginLimiter) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, ip)
+}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For is believed,
+// set from TRUSTED_PROXIES at startup
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the networks of the reverse proxies in front of
+// Requestarr
+func SetTrustedProxies(networks []*net.IPNet) {
+	trustedProxies = networks
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only read when
+// the connection comes from a trusted proxy, and then the right-most address
+// that isn't a trusted proxy is used, since everything left of it can be
+// made up by the client.
+func clientIP(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip != "" && !isTrustedProxy(ip) {
+			return ip
+		}
+	}
+	return remote
+}
+
+func (h *Handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if h.logins.blocked(ip) {
+		h.errorResponse(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var data struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The password-only login form signs in as the bootstrap admin
+	if data.Username == "" {
+		data.Username = "admin"
+	}
+
+	user, err := h.db.GetUserByUsername(data.Username)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(data.Password)) != nil {
+		h.loginFailed(ip, data.Username)
+		h.errorResponse(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	if h.db.GetSetting(totpSetting("totp_secret", user.ID)) != "" {
+		if data.Code == "" {
+			h.errorResponse(w, "Two-factor code required", http.StatusUnauthorized)
+			return
+		}
+		if !h.checkTwoFactor(user.ID, data.Code) {
+			h.loginFailed(ip, data.Username)
+			h.errorResponse(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+	}
+	h.logins.reset(ip)
+
+	h.startSession(w, r, user)
+	h.db.LogActivity("user_login", map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+	})
+
+	h.jsonResponse(w, map[string]interface{}{"success": true, "role": user.Role})
+}
+
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) {
+	session, _ := h.store.Get(r, "session")
+	session.Values["user_id"] = user.ID
+	session.Values["session_version"] = user.SessionVersion
+	delete(session.Values, "role")
+	session.Save(r, w)
+}
+
+// Plex sign-in
+// The client opens the URL returned by PlexAuthStart and polls PlexAuthPoll
+// with the PIN id until the user has approved it on plex.tv. The PIN id is
+// kept in the session, so only the browser that started a sign-in can finish
+// it. Plex accounts get their own local user, matched by Plex account ID on
+// later sign-ins.
+func (h *Handler) PlexAuthStart(w http.ResponseWriter, r *http.Request) {
+	pin, err := h.plex.CreatePin(r.Context())
+	if err != nil {
+		h.errorResponse(w, "Failed to reach Plex: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	session.Values["plex_pin_id"] = pin.ID
+	if err := session.Save(r, w); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.jsonResponse(w, pin)
+}
+
+func (h *Handler) PlexAuthPoll(w http.ResponseWriter, r *http.Request) {
+	pinID, err := strconv.Atoi(r.URL.Query().Get("pinId"))
+	if err != nil || pinID <= 0 {
+		h.errorResponse(w, "Invalid pinId", http.StatusBadRequest)
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	if startedPin, _ := session.Values["plex_pin_id"].(int); startedPin != pinID {
+		h.errorResponse(w, "This Plex sign-in was not started in this session", http.StatusForbidden)
+		return
+	}
+
+	token, err := h.plex.CheckPin(r.Context(), pinID)
+	if err != nil {
+		h.errorResponse(w, "Failed to reach Plex: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if token == "" {
+		h.jsonResponse(w, map[string]interface{}{"authorized": false})
+		return
+	}
+
+	account, err := h.plex.GetAccount(r.Context(), token)
+	if err != nil {
+		h.errorResponse(w, "Failed to get Plex account: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if machineID := h.db.GetSetting("plex_server_machine_id"); machineID != "" {
+		hasAccess, err := h.plex.HasServerAccess(r.Context(), token, machineID)
+		if err != nil {
+			h.errorResponse(w, "Failed to check Plex server access: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if !hasAccess {
+			h.db.LogActivity("plex_login_denied", map[string]interface{}{
+				"plex_username": account.Username,
+			})
+			h.errorResponse(w, "Your Plex account does not have access to this server", http.StatusForbidden)
+			return
+		}
+	}
+
+	encryptedToken, err := auth.Encrypt(h.secretKey, token)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.db.GetUserByPlexID(account.ID)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		existing, err := h.db.GetUserByUsername(account.Username)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if existing != nil {
+			h.errorResponse(w, "A local user named "+account.Username+" already exists", http.StatusConflict)
+			return
+		}
+
+		userID, err := h.db.CreatePlexUser(account.Username, account.ID, encryptedToken)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.db.LogActivity("user_created", map[string]interface{}{
+			"username": account.Username,
+			"role":     "user",
+			"plex_id":  account.ID,
+		})
+
+		if user, err = h.db.GetUser(int(userID)); err != nil || user == nil {
+			h.errorResponse(w, "Failed to load new user", http.StatusInternalServerError)
+			return
+		}
+	} else if err := h.db.UpdateUserPlexToken(user.ID, encryptedToken); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	delete(session.Values, "plex_pin_id")
+	h.startSession(w, r, user)
+	h.db.LogActivity("user_login", map[string]interface{}{
+		"username": user.Username,
+		"role":     user.Role,
+		"method":   "plex",
+	})
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success":    true,
+		"authorized": true,
+		"username":   user.Username,
+		"role":       user.Role,
+	})
+}
+
+// loginFailed counts a failed login, logging when the IP gets blocked
+func (h *Handler) loginFailed(ip, username string) {
+	if failures := h.logins.fail(ip); failures == maxLoginFailures {
+		h.db.LogActivity("login_blocked", map[string]interface{}{
+			"ip":       ip,
+			"username": username,
+			"failures": failures,
+		})
+	}
+}
+
+func (h *Handler) AdminLogout(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.store.Get(r, "session")
+	delete(session.Values, "user_id")
+	delete(session.Values, "session_version")
+	delete(session.Values, "role")
+	session.Save(r, w)
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// Two-factor authentication
+// A user's TOTP secret is kept encrypted with SECRET_KEY in the
+// totp_secret_<id> setting, alongside hashed single-use recovery codes in
+// totp_recovery_<id>. Enabling stores the secret as pending until a code
+// from the authenticator app is verified.
+const recoveryCodeCount = 8
+
+func totpSetting(prefix string, userID int) string {
+	return fmt.Sprintf("%s_%d", prefix, userID)
+}
+
+func (h *Handler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, _ := h.sessionUser(r)
+	user, err := h.db.GetUser(userID)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		h.errorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if h.db.GetSetting(totpSetting("totp_secret", user.ID)) != "" {
+		h.errorResponse(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encrypted, err := auth.Encrypt(h.secretKey, secret)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	hashesJSON, _ := json.Marshal(hashes)
+
+	if err := h.db.SetSetting(totpSetting("totp_pending", user.ID), encrypted); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.db.SetSetting(totpSetting("totp_recovery", user.ID), string(hashesJSON)); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	issuer := h.db.GetSetting("instance_name")
+	if issuer == "" {
+		issuer = settingDefaults["instance_name"]
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"secret":        secret,
+		"otpauthUrl":    auth.TOTPURL(issuer, user.Username, secret),
+		"recoveryCodes": codes,
+	})
+}
+
+func (h *Handler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := h.sessionUser(r)
+	pending := h.db.GetSetting(totpSetting("totp_pending", userID))
+	if pending == "" {
+		h.errorResponse(w, "Two-factor setup has not been started", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := auth.Decrypt(h.secretKey, pending)
+	if err != nil {
+		h.errorResponse(w, "Could not read two-factor secret, start setup again", http.StatusInternalServerError)
+		return
+	}
+	if !auth.ValidateTOTP(secret, strings.TrimSpace(data.Code), time.Now()) {
+		h.errorResponse(w, "Invalid two-factor code", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.SetSetting(totpSetting("totp_secret", userID), pending); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.db.SetSetting(totpSetting("totp_pending", userID), "")
+
+	h.db.LogActivity("two_factor_enabled", map[string]interface{}{
+		"user_id": userID,
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// DisableTwoFactor turns 2FA off for the logged in user, who has to confirm
+// it with their password and a current or recovery code
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := h.sessionUser(r)
+	user, err := h.db.GetUser(userID)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		h.errorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if h.db.GetSetting(totpSetting("totp_secret", user.ID)) == "" {
+		h.errorResponse(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(data.Password)) != nil {
+		h.errorResponse(w, "Current password is incorrect", http.StatusBadRequest)
+		return
+	}
+	if !h.checkTwoFactor(user.ID, data.Code) {
+		h.errorResponse(w, "Invalid two-factor code", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.clearTwoFactor(user.ID); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.db.LogActivity("two_factor_disabled", map[string]interface{}{
+		"user_id": user.ID,
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// clearTwoFactor removes a user's TOTP secret, pending setup and recovery
+// codes
+func (h *Handler) clearTwoFactor(userID int) error {
+	for _, prefix := range []string{"totp_secret", "totp_pending", "totp_recovery"} {
+		if err := h.db.DeleteSetting(totpSetting(prefix, userID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTwoFactor accepts a current TOTP code or an unused recovery code,
+// which is then removed
+func (h *Handler) checkTwoFactor(userID int, code string) bool {
+	code = strings.TrimSpace(code)
+
+	secret, err := auth.Decrypt(h.secretKey, h.db.GetSetting(totpSetting("totp_secret", userID)))
+	if err == nil && auth.ValidateTOTP(secret, code, time.Now()) {
+		return true
+	}
+
+	var hashes []string
+	json.Unmarshal([]byte(h.db.GetSetting(totpSetting("totp_recovery", userID))), &hashes)
+	hash := auth.HashRecoveryCode(code)
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			remaining, _ := json.Marshal(append(hashes[:i], hashes[i+1:]...))
+			h.db.SetSetting(totpSetting("totp_recovery", userID), string(remaining))
+			h.db.LogActivity("recovery_code_used", map[string]interface{}{
+				"user_id":   userID,
+				"remaining": len(hashes) - 1,
+			})
+			return true
+		}
+	}
+	return false
+}
+
+// ChangePassword changes the logged in user's password. ADMIN_PASSWORD only
+// seeds the first account, so this is how the admin password is rotated.
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := h.sessionUser(r)
+	user, err := h.db.GetUser(userID)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		h.errorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(data.CurrentPassword)) != nil {
+		h.errorResponse(w, "Current password is incorrect", http.StatusBadRequest)
+		return
+	}
+	if len(data.NewPassword) < minPasswordLength {
+		h.errorResponse(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(data.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.UpdateUserPassword(user.ID, string(hash)); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Changing the password signs out every other session, this one is
+	// renewed with the new session version
+	if r.Header.Get("X-Api-Key") == "" {
+		if updated, err := h.db.GetUser(user.ID); err == nil && updated != nil {
+			h.startSession(w, r, updated)
+		}
+	}
+
+	h.db.LogActivity("password_changed", map[string]interface{}{
+		"username": user.Username,
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+func (h *Handler) GetAdminSettings(w http.ResponseWriter, r *http.Request) {
+	settings, _ := h.db.GetAllSettings()
+
+	// Initialize as empty slices (not nil) so JSON returns [] instead of null
+	sonarrRootFolders := make([]map[string]interface{}, 0)
+	sonarrQualityProfiles := make([]map[string]interface{}, 0)
+	sonarrLanguageProfiles := make([]map[string]interface{}, 0)
+	var sonarrError string
+	if settings["sonarr_url"] != "" && settings["sonarr_api_key"] != "" {
+		rf, err := h.sonarr.GetRootFolders(r.Context())
+		if err != nil {
+			sonarrError = err.Error()
+		} else if rf != nil {
+			sonarrRootFolders = rf
+		}
+		qp, err := h.sonarr.GetQualityProfiles(r.Context())
+		if err != nil && sonarrError == "" {
+			sonarrError = err.Error()
+		} else if qp != nil {
+			sonarrQualityProfiles = qp
+		}
+		lp, err := h.sonarr.GetLanguageProfiles(r.Context())
+		if err != nil && sonarrError == "" {
+			sonarrError = err.Error()
+		} else if lp != nil {
+			sonarrLanguageProfiles = lp
+		}
+	}
+
+	// Secrets are only shown masked, configured tells which ones are set
+	configured := make(map[string]bool, len(secretSettings))
+	for key := range secretSettings {
+		configured[key] = settings[key] != ""
+		settings[key] = maskSecret(settings[key])
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"configured": configured,
+		"settings": map[string]string{
+			"sonarr_url":                       settings["sonarr_url"],
+			"sonarr_api_key":                   settings["sonarr_api_key"],
+			"radarr_url":                       settings["radarr_url"],
+			"radarr_api_key":                   settings["radarr_api_key"],
+			"discord_webhook":                  settings["discord_webhook"],
+			"ntfy_url":                         settings["ntfy_url"],
+			"ntfy_topic":                       settings["ntfy_topic"],
+			"tmdb_api_key":                     settings["tmdb_api_key"],
+			"mdblist_api_key":                  settings["mdblist_api_key"],
+			"instance_name":                    settings["instance_name"],
+			"maintenance_mode":                 settings["maintenance_mode"],
+			"maintenance_message":              settings["maintenance_message"],
+			"require_email":                    settings["require_email"],
+			"max_active_downloads":             settings["max_active_downloads"],
+			"default_series_monitor":           settings["default_series_monitor"],
+			"requester_profile_map":            settings["requester_profile_map"],
+			"telegram_bot_token":               settings["telegram_bot_token"],
+			"telegram_chat_id":                 settings["telegram_chat_id"],
+			"notify_on_request":                settings["notify_on_request"],
+			"notify_on_approve":                settings["notify_on_approve"],
+			"notify_on_complete":               settings["notify_on_complete"],
+			"notify_on_reject":                 settings["notify_on_reject"],
+			"smtp_host":                        settings["smtp_host"],
+			"smtp_port":                        settings["smtp_port"],
+			"smtp_username":                    settings["smtp_username"],
+			"smtp_password":                    settings["smtp_password"],
+			"smtp_from":                        settings["smtp_from"],
+			"smtp_to":                          settings["smtp_to"],
+			"quota_movie_limit":                settings["quota_movie_limit"],
+			"quota_series_limit":               settings["quota_series_limit"],
+			"quota_period_days":                settings["quota_period_days"],
+			"trakt_client_id":                  settings["trakt_client_id"],
+			"tmdb_region":                      settings["tmdb_region"],
+			"radarr_4k_url":                    settings["radarr_4k_url"],
+			"radarr_4k_api_key":                settings["radarr_4k_api_key"],
+			"webhook_token":                    settings["webhook_token"],
+			"notify_on_first_episode":          settings["notify_on_first_episode"],
+			"webhook_notify_url":               settings["webhook_notify_url"],
+			"default_root_folder_series":       settings["default_root_folder_series"],
+			"default_quality_profile_series":   settings["default_quality_profile_series"],
+			"default_root_folder_movie":        settings["default_root_folder_movie"],
+			"default_quality_profile_movie":    settings["default_quality_profile_movie"],
+			"default_root_folder_movie_4k":     settings["default_root_folder_movie_4k"],
+			"default_quality_profile_movie_4k": settings["default_quality_profile_movie_4k"],
+			"default_minimum_availability":     settings["default_minimum_availability"],
+			"plex_server_machine_id":           settings["plex_server_machine_id"],
+			"tmdb_concurrency":                 settings["tmdb_concurrency"],
+			"activity_retention_days":          settings["activity_retention_days"],
+			"feed_token":                       settings["feed_token"],
+			"plex_watchlist_sync":              settings["plex_watchlist_sync"],
+			"allow_rerequest_completed":        settings["allow_rerequest_completed"],
+			"notify_requesters":                settings["notify_requesters"],
+			"ntfy_user_topic_prefix":           settings["ntfy_user_topic_prefix"],
+			"public_url":                       settings["public_url"],
+			"notify_on_comment":                settings["notify_on_comment"],
+			"notify_on_issue":                  settings["notify_on_issue"],
+			"issue_auto_search":                settings["issue_auto_search"],
+			"discover_language":                settings["discover_language"],
+			"discover_region":                  settings["discover_region"],
+			"discover_min_votes_movie":         settings["discover_min_votes_movie"],
+			"discover_min_votes_tv":            settings["discover_min_votes_tv"],
+			"tmdb_language":                    settings["tmdb_language"],
+		},
+		"sonarr": map[string]interface{}{
+			"rootFolders":      sonarrRootFolders,
+			"qualityProfiles":  sonarrQualityProfiles,
+			"languageProfiles": sonarrLanguageProfiles,
+			"error":            sonarrError,
+		},
+		"radarr":   radarrOptions(r.Context(), h.radarr),
+		"radarr4k": radarrOptions(r.Context(), h.radarr4k),
+	})
+}
+
+// radarrOptions lists a Radarr instance's root folders and quality profiles
+func radarrOptions(ctx context.Context, radarr *services.RadarrService) map[string]interface{} {
+	// Initialize as empty slices (not nil) so JSON returns [] instead of null
+	rootFolders := make([]map[string]interface{}, 0)
+	qualityProfiles := make([]map[string]interface{}, 0)
+	var radarrError string
+	if radarr.IsConfigured() {
+		rf, err := radarr.GetRootFolders(ctx)
+		if err != nil {
+			radarrError = err.Error()
+		} else if rf != nil {
+			rootFolders = rf
+		}
+		qp, err := radarr.GetQualityProfiles(ctx)
+		if err != nil && radarrError == "" {
+			radarrError = err.Error()
+		} else if qp != nil {
+			qualityProfiles = qp
+		}
+	}
+
+	return map[string]interface{}{
+		"rootFolders":     rootFolders,
+		"qualityProfiles": qualityProfiles,
+		"error":           radarrError,
+	}
+}
+
+// Settings that can be changed from the admin panel
+var allowedSettings = map[string]bool{
+	"sonarr_url":                       true,
+	"sonarr_api_key":                   true,
+	"radarr_url":                       true,
+	"radarr_api_key":                   true,
+	"discord_webhook":                  true,
+	"ntfy_url":                         true,
+	"ntfy_topic":                       true,
+	"tmdb_api_key":                     true,
+	"mdblist_api_key":                  true,
+	"instance_name":                    true,
+	"maintenance_mode":                 true,
+	"maintenance_message":              true,
+	"require_email":                    true,
+	"max_active_downloads":             true,
+	"default_series_monitor":           true,
+	"requester_profile_map":            true,
+	"telegram_bot_token":               true,
+	"telegram_chat_id":                 true,
+	"notify_on_request":                true,
+	"notify_on_approve":                true,
+	"notify_on_complete":               true,
+	"notify_on_reject":                 true,
+	"smtp_host":                        true,
+	"smtp_port":                        true,
+	"smtp_username":                    true,
+	"smtp_password":                    true,
+	"smtp_from":                        true,
+	"smtp_to":                          true,
+	"quota_movie_limit":                true,
+	"quota_series_limit":               true,
+	"quota_period_days":                true,
+	"trakt_client_id":                  true,
+	"tmdb_region":                      true,
+	"radarr_4k_url":                    true,
+	"radarr_4k_api_key":                true,
+	"webhook_token":                    true,
+	"notify_on_first_episode":          true,
+	"webhook_notify_url":               true,
+	"default_root_folder_series":       true,
+	"default_quality_profile_series":   true,
+	"default_root_folder_movie":        true,
+	"default_quality_profile_movie":    true,
+	"default_root_folder_movie_4k":     true,
+	"default_quality_profile_movie_4k": true,
+	"default_minimum_availability":     true,
+	"plex_server_machine_id":           true,
+	"tmdb_concurrency":                 true,
+	"activity_retention_days":          true,
+	"feed_token":                       true,
+	"plex_watchlist_sync":              true,
+	"allow_rerequest_completed":        true,
+	"notify_requesters":                true,
+	"ntfy_user_topic_prefix":           true,
+	"public_url":                       true,
+	"notify_on_comment":                true,
+	"notify_on_issue":                  true,
+	"issue_auto_search":                true,
+	"discover_language":                true,
+	"discover_region":                  true,
+	"discover_min_votes_movie":         true,
+	"discover_min_votes_tv":            true,
+	"tmdb_language":                    true,
+}
+
+// Settings only ever returned masked, the settings form sends the masked
+// value back when a secret wasn't changed
+var secretSettings = map[string]bool{
+	"sonarr_api_key":     true,
+	"radarr_api_key":     true,
+	"discord_webhook":    true,
+	"telegram_bot_token": true,
+	"smtp_password":      true,
+	"tmdb_api_key":       true,
+	"mdblist_api_key":    true,
+	"trakt_client_id":    true,
+	"radarr_4k_api_key":  true,
+	"webhook_token":      true,
+	"webhook_notify_url": true,
+	"feed_token":         true,
+}
+
+// Values used when a setting isn't stored
+var settingDefaults = map[string]string{
+	"instance_name":                "Requestarr",
+	"maintenance_mode":             "false",
+	"require_email":                "false",
+	"max_active_downloads":         "0",
+	"default_series_monitor":       "all",
+	"default_minimum_availability": "announced",
+	"notify_on_request":            "true",
+	"notify_on_approve":            "true",
+	"notify_on_complete":           "true",
+	"notify_on_reject":             "true",
+	"quota_movie_limit":            "0",
+	"quota_series_limit":           "0",
+	"quota_period_days":            "7",
+	"tmdb_region":                  "US",
+	"notify_on_first_episode":      "true",
+	"tmdb_concurrency":             strconv.Itoa(services.DefaultTMDBConcurrency),
+	"plex_watchlist_sync":          "false",
+	"allow_rerequest_completed":    "false",
+	"notify_requesters":            "false",
+	"notify_on_comment":            "true",
+	"notify_on_issue":              "true",
+	"issue_auto_search":            "false",
+	"activity_retention_days":      strconv.Itoa(models.DefaultActivityRetentionDays),
+	"discover_language":            services.DefaultDiscoverLanguage,
+	"tmdb_language":                services.DefaultTMDBLanguage,
+	"discover_min_votes_movie":     strconv.Itoa(services.DefaultDiscoverMinVotesMovie),
+	"discover_min_votes_tv":        strconv.Itoa(services.DefaultDiscoverMinVotesTV),
+}
+
+func (h *Handler) UpdateAdminSettings(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if monitor := data["default_series_monitor"]; monitor != "" && !services.IsValidSeriesMonitor(monitor) {
+		h.errorResponse(w, "Invalid default_series_monitor, expected one of: "+strings.Join(services.SeriesMonitorOptions, ", "), http.StatusBadRequest)
+		return
+	}
+
+	if availability := data["default_minimum_availability"]; availability != "" && !services.IsValidMinimumAvailability(availability) {
+		h.errorResponse(w, "Invalid default_minimum_availability, expected one of: "+strings.Join(services.MinimumAvailabilityOptions, ", "), http.StatusBadRequest)
+		return
+	}
+
+	for _, key := range []string{"default_quality_profile_series", "default_quality_profile_movie", "default_quality_profile_movie_4k"} {
+		if value := data[key]; value != "" {
+			if _, err := strconv.Atoi(value); err != nil {
+				h.errorResponse(w, "Invalid "+key+", expected a quality profile id", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	if value := data["tmdb_concurrency"]; value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			h.errorResponse(w, "Invalid tmdb_concurrency, expected a number of at least 1", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if value := data["activity_retention_days"]; value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			h.errorResponse(w, "Invalid activity_retention_days, expected a number of days or 0 to keep everything", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if language := data["discover_language"]; language != "" && !services.IsValidLanguage(language) {
+		h.errorResponse(w, "Invalid discover_language, expected a two letter language code such as en, or "+services.AnyLanguage, http.StatusBadRequest)
+		return
+	}
+
+	if language := data["tmdb_language"]; language != "" && !services.IsValidMetadataLanguage(language) {
+		h.errorResponse(w, "Invalid tmdb_language, expected a language such as en-US or de", http.StatusBadRequest)
+		return
+	}
+
+	if region := data["discover_region"]; region != "" && !services.IsValidRegion(region) {
+		h.errorResponse(w, "Invalid discover_region, expected a two letter country code such as US", http.StatusBadRequest)
+		return
+	}
+
+	for _, key := range []string{"discover_min_votes_movie", "discover_min_votes_tv"} {
+		if value := data[key]; value != "" {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				h.errorResponse(w, "Invalid "+key+", expected a number of votes", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	for _, key := range []string{"sonarr_url", "radarr_url", "radarr_4k_url"} {
+		if value, ok := data[key]; ok {
+			normalized, err := services.NormalizeArrURL(value)
+			if err != nil {
+				h.errorResponse(w, fmt.Sprintf("Invalid %s: %v", key, err), http.StatusBadRequest)
+				return
+			}
+			data[key] = normalized
+		}
+	}
+
+	if value := data["public_url"]; value != "" {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			h.errorResponse(w, "Invalid public_url, expected an http(s) URL such as https://requests.example.com", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if prefix := data["ntfy_user_topic_prefix"]; prefix != "" && !services.IsValidNtfyTopicPrefix(prefix) {
+		h.errorResponse(w, "Invalid ntfy_user_topic_prefix, use up to 32 letters, digits, - and _", http.StatusBadRequest)
+		return
+	}
+
+	if profileMap := data["requester_profile_map"]; profileMap != "" {
+		if _, err := parseRequesterProfileMap(profileMap); err != nil {
+			h.errorResponse(w, "Invalid requester_profile_map: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	for key, value := range data {
+		if !allowedSettings[key] {
+			continue
+		}
+		// A masked secret is the form echoing back the current value
+		if secretSettings[key] && value != "" && value == maskSecret(h.db.GetSetting(key)) {
+			delete(data, key)
+			continue
+		}
+		h.db.SetSetting(key, value)
+	}
+
+	h.db.LogActivity("settings_updated", map[string]interface{}{
+		"keys": getKeys(data),
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+func (h *Handler) TestConnection(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Service string `json:"service"`
+		URL     string `json:"url"`
+		APIKey  string `json:"apiKey"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if data.URL == "" || data.APIKey == "" {
+		h.errorResponse(w, "URL and API key are required", http.StatusBadRequest)
+		return
+	}
+
+	normalized, err := services.NormalizeArrURL(data.URL)
+	if err != nil {
+		h.errorResponse(w, "Invalid URL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	data.URL = normalized
+
+	if data.Service != "sonarr" && data.Service != "radarr" && data.Service != "radarr_4k" {
+		h.errorResponse(w, "Invalid service, expected sonarr, radarr or radarr_4k", http.StatusBadRequest)
+		return
+	}
+
+	// Testing saved settings sends the masked key. The stored key is only
+	// sent to the stored URL, never to one the caller picked.
+	if stored := h.db.GetSetting(data.Service + "_api_key"); stored != "" && data.APIKey == maskSecret(stored) {
+		storedURL, err := services.NormalizeArrURL(h.db.GetSetting(data.Service + "_url"))
+		if err != nil || storedURL != data.URL {
+			h.errorResponse(w, "Enter the API key to test a URL other than the saved one", http.StatusBadRequest)
+			return
+		}
+		data.APIKey = stored
+	}
+
+	var result map[string]interface{}
+
+	switch data.Service {
+	case "sonarr":
+		result, err = h.sonarr.TestConnection(r.Context(), data.URL, data.APIKey)
+	case "radarr_4k":
+		result, err = h.radarr4k.TestConnection(r.Context(), data.URL, data.APIKey)
+	default:
+		result, err = h.radarr.TestConnection(r.Context(), data.URL, data.APIKey)
+	}
+
+	if err != nil {
+		h.jsonResponse(w, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success": true,
+		"version": result["version"],
+		"appName": result["appName"],
+	})
+}
+
+// TestNotification sends a sample notification through one channel, or all
+// configured channels, reporting how each one went
+func (h *Handler) TestNotification(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Channel string `json:"channel"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if data.Channel != "" {
+		known := false
+		for _, channel := range services.NotificationChannels {
+			if channel == data.Channel {
+				known = true
+			}
+		}
+		if !known {
+			h.errorResponse(w, "Invalid channel, expected one of: "+strings.Join(services.NotificationChannels, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sent, err := h.notify.SendTest(r.Context(), data.Channel)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(sent) == 0 {
+		h.errorResponse(w, "No notification channels configured", http.StatusBadRequest)
+		return
+	}
+
+	success := true
+	results := make(map[string]interface{}, len(sent))
+	for channel, err := range sent {
+		if err != nil {
+			success = false
+			results[channel] = map[string]interface{}{"success": false, "error": err.Error()}
+		} else {
+			results[channel] = map[string]interface{}{"success": true}
+		}
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success": success,
+		"results": results,
+	})
+}
+
+// parseDateRange reads the inclusive from/to dates (YYYY-MM-DD) of a query
+// as [since, until), leaving either zero when not given
+func parseDateRange(query url.Values) (since, until time.Time, err error) {
+	if from := query.Get("from"); from != "" {
+		if since, err = time.Parse("2006-01-02", from); err != nil {
+			return since, until, fmt.Errorf("Invalid from date, expected YYYY-MM-DD")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return since, until, fmt.Errorf("Invalid to date, expected YYYY-MM-DD")
+		}
+		until = t.AddDate(0, 0, 1)
+	}
+	return since, until, nil
+}
+
+// GetActivity returns a page of the activity log, filtered by action and
+// by an inclusive from/to date range (YYYY-MM-DD)
+func (h *Handler) GetActivity(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(query.Get("pageSize"))
+	if pageSize < 1 {
+		// limit is the older name for the page size
+		pageSize, _ = strconv.Atoi(query.Get("limit"))
+	}
+	if pageSize < 1 {
+		pageSize = defaultActivityPageSize
+	}
+	if pageSize > maxRequestsPageSize {
+		pageSize = maxRequestsPageSize
+	}
+
+	since, until, err := parseDateRange(query)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	activities, total, err := h.db.GetActivity(query.Get("action"), since, until, pageSize, (page-1)*pageSize)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if activities == nil {
+		activities = []models.Activity{}
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"results":    activities,
+		"page":       page,
+		"pageSize":   pageSize,
+		"total":      total,
+		"totalPages": (total + pageSize - 1) / pageSize,
+	})
+}
+
+// ClearActivity deletes the whole activity log
+func (h *Handler) ClearActivity(w http.ResponseWriter, r *http.Request) {
+	deleted, err := h.db.ClearActivity()
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, _ := h.sessionUser(r)
+	slog.Info("Cleared activity log", "deleted", deleted, "user_id", userID)
+	h.db.LogActivity("activity_cleared", map[string]interface{}{
+		"deleted": deleted,
+		"user_id": userID,
+	})
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success": true,
+		"deleted": deleted,
+	})
+}
+
+// maxImportErrors caps how many per-request errors an import reports
+const maxImportErrors = 20
+
+// ImportOverseerr imports the request history of Overseerr or Jellyseerr,
+// either fetched from its API ({"url": ..., "apiKey": ...}) or from a saved
+// /api/v1/request response. Requests already present are skipped.
+func (h *Handler) ImportOverseerr(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var source struct {
+		URL    string `json:"url"`
+		APIKey string `json:"apiKey"`
+	}
+	json.Unmarshal(body, &source)
+
+	var requests []services.OverseerrRequest
+	if source.URL != "" {
+		requests, err = services.FetchOverseerrRequests(r.Context(), source.URL, source.APIKey)
+		if err != nil {
+			h.errorResponse(w, "Failed to fetch Overseerr requests: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	} else {
+		requests, err = services.ParseOverseerrExport(body)
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	imported, skipped := 0, 0
+	failures := []string{}
+	for i := range requests {
+		req, err := h.fromOverseerr(r.Context(), &requests[i])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("Request %d: %s", requests[i].ID, err.Error()))
+			continue
+		}
+
+		status, err := h.findDuplicateRequest(req.MediaType, req.TmdbID, req.TvdbID, req.Seasons, req.Is4K)
+		duplicate := status != ""
+		if err == nil && !duplicate {
+			duplicate, err = h.db.HasRequestAt(req.MediaType, req.TmdbID, req.TvdbID, req.CreatedAt)
 		}
-		enhancedResults = append(enhancedResults, enhanced)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("Request %d: %s", requests[i].ID, err.Error()))
+			continue
+		}
+		if duplicate {
+			skipped++
+			continue
+		}
+
+		if _, err := h.db.ImportRequest(req); err != nil {
+			failures = append(failures, fmt.Sprintf("Request %d: %s", requests[i].ID, err.Error()))
+			continue
+		}
+		imported++
 	}
 
-	h.jsonResponse(w, enhancedResults)
+	slog.Info("Imported Overseerr requests", "imported", imported, "skipped", skipped, "failed", len(failures))
+	h.db.LogActivity("requests_imported", map[string]interface{}{
+		"source":   "overseerr",
+		"imported": imported,
+		"skipped":  skipped,
+		"failed":   len(failures),
+	})
+
+	reported := failures
+	if len(reported) > maxImportErrors {
+		reported = reported[:maxImportErrors]
+	}
+	h.jsonResponse(w, map[string]interface{}{
+		"imported": imported,
+		"skipped":  skipped,
+		"failed":   len(failures),
+		"errors":   reported,
+	})
 }
 
-// Ratings
-func (h *Handler) GetRatings(w http.ResponseWriter, r *http.Request) {
-	title := r.URL.Query().Get("title")
-	year := r.URL.Query().Get("year")
-	mediaType := r.URL.Query().Get("type")
-	imdbID := r.URL.Query().Get("imdb_id")
-	tmdbID, _ := strconv.Atoi(r.URL.Query().Get("tmdb_id"))
+// fromOverseerr maps an Overseerr request to a request, looking up the title
+// (Overseerr only stores ids) and, for series without one, the TVDB id
+func (h *Handler) fromOverseerr(ctx context.Context, o *services.OverseerrRequest) (*models.Request, error) {
+	if o.Media.TmdbID == 0 {
+		return nil, fmt.Errorf("no TMDB ID")
+	}
+	name := o.RequesterName()
+	if name == "" {
+		return nil, fmt.Errorf("no requester")
+	}
 
-	if title == "" && imdbID == "" && tmdbID == 0 {
-		h.errorResponse(w, "Title or ID required", http.StatusBadRequest)
+	req := &models.Request{
+		RequesterName: name,
+		TmdbID:        &o.Media.TmdbID,
+		Is4K:          o.Is4K,
+		CreatedAt:     o.CreatedAt,
+	}
+	if o.RequestedBy.Email != "" {
+		req.RequesterEmail = &o.RequestedBy.Email
+	}
+	if o.Media.ImdbID != "" {
+		req.ImdbID = &o.Media.ImdbID
+	}
+	if req.CreatedAt.IsZero() {
+		req.CreatedAt = time.Now()
+	}
+	if user, err := h.db.GetUserByUsername(name); err == nil && user != nil {
+		req.UserID = &user.ID
+	}
+
+	switch o.Type {
+	case "movie":
+		req.MediaType = "movie"
+	case "tv":
+		req.MediaType = "series"
+		req.Is4K = false
+		tvdbID := o.Media.TvdbID
+		if tvdbID == 0 {
+			tvdbID = h.tmdb.ResolveExternalIDs(ctx, []int{o.Media.TmdbID}, "tv")[o.Media.TmdbID].TvdbID
+		}
+		if tvdbID == 0 {
+			return nil, fmt.Errorf("no TVDB ID for TMDB %d", o.Media.TmdbID)
+		}
+		req.TvdbID = &tvdbID
+		for _, season := range o.Seasons {
+			req.Seasons = append(req.Seasons, season.SeasonNumber)
+		}
+	default:
+		return nil, fmt.Errorf("unknown media type %q", o.Type)
+	}
+
+	switch {
+	case o.Status == services.OverseerrRequestDeclined:
+		req.Status = "rejected"
+	case o.Status == services.OverseerrRequestCompleted || o.Media.Status == services.OverseerrMediaAvailable:
+		req.Status = "completed"
+	case o.Status == services.OverseerrRequestApproved:
+		req.Status = "approved"
+	default:
+		req.Status = "pending"
+	}
+
+	info, err := h.tmdb.LookupTitle(ctx, o.Type, o.Media.TmdbID)
+	if err != nil {
+		return nil, fmt.Errorf("looking up title: %w", err)
+	}
+	req.Title = info.Title
+	if info.Year > 0 {
+		req.Year = &info.Year
+	}
+	if info.Poster != "" {
+		req.Poster = &info.Poster
+	}
+	return req, nil
+}
+
+func (h *Handler) GetEffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	runtime := make([]ConfigEntry, 0, len(h.runtimeConfig))
+	for _, entry := range h.runtimeConfig {
+		if entry.Credential {
+			configured := entry.Source != "default"
+			entry.Value, entry.Configured = "", &configured
+		} else if entry.Secret {
+			entry.Value = maskSecret(entry.Value)
+		}
+		runtime = append(runtime, entry)
+	}
+
+	keys := make([]string, 0, len(allowedSettings))
+	for key := range allowedSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	settings := make([]ConfigEntry, 0, len(keys))
+	for _, key := range keys {
+		value, source, found := h.db.GetSettingWithSource(key)
+		if !found || value == "" {
+			value, source = settingDefaults[key], "default"
+		}
+		if secretSettings[key] {
+			value = maskSecret(value)
+		}
+		settings = append(settings, ConfigEntry{
+			Key:    key,
+			Value:  value,
+			Source: source,
+			// Env values only seed settings that aren't stored yet
+			EnvSet: os.Getenv(strings.ToUpper(key)) != "",
+		})
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"runtime":  runtime,
+		"settings": settings,
+	})
+}
+
+func (h *Handler) AdminSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(query) < 2 {
+		h.errorResponse(w, "Search term too short", http.StatusBadRequest)
 		return
 	}
 
-	ratings, err := h.ratings.GetRatings(title, year, mediaType, imdbID, tmdbID)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+
+	requests, activities, err := h.db.FullTextSearch(query, limit)
 	if err != nil {
-		h.jsonResponse(w, map[string]interface{}{})
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	h.jsonResponse(w, ratings)
+	if requests == nil {
+		requests = []models.Request{}
+	}
+	if activities == nil {
+		activities = []models.Activity{}
+	}
+
+	h.jsonResponse(w, map[string]interface{}{
+		"requests": requests,
+		"activity": activities,
+	})
 }
 
-// Requests
-func (h *Handler) CreateRequest(w http.ResponseWriter, r *http.Request) {
-	var raw map[string]interface{}
+// Users
+const minPasswordLength = 8
 
-	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		Role        string `json:"role"`
+		AutoApprove bool   `json:"autoApprove"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Extract fields with type flexibility
-	requesterName, _ := raw["requesterName"].(string)
-	requesterEmail, _ := raw["requesterEmail"].(string)
-	mediaType, _ := raw["mediaType"].(string)
-	title, _ := raw["title"].(string)
-	poster, _ := raw["poster"].(string)
-	imdbID, _ := raw["imdbId"].(string)
+	data.Username = strings.TrimSpace(data.Username)
+	if data.Username == "" {
+		h.errorResponse(w, "Username is required", http.StatusBadRequest)
+		return
+	}
+	if len(data.Password) < minPasswordLength {
+		h.errorResponse(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if data.Role == "" {
+		data.Role = "user"
+	}
+	if data.Role != "admin" && data.Role != "user" {
+		h.errorResponse(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
 
-	// Handle year - could be string or number
-	var year *int
-	if y, ok := raw["year"].(float64); ok {
-		yi := int(y)
-		year = &yi
-	} else if y, ok := raw["year"].(string); ok && y != "" {
-		if yi, err := strconv.Atoi(y); err == nil {
-			year = &yi
-		}
+	existing, err := h.db.GetUserByUsername(data.Username)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		h.errorResponse(w, "Username already exists", http.StatusConflict)
+		return
 	}
 
-	// Handle tmdbId - could be float64 from JSON
-	var tmdbID *int
-	if id, ok := raw["tmdbId"].(float64); ok {
-		i := int(id)
-		tmdbID = &i
+	hash, err := bcrypt.GenerateFromPassword([]byte(data.Password), bcrypt.DefaultCost)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	// Handle tvdbId
-	var tvdbID *int
-	if id, ok := raw["tvdbId"].(float64); ok {
-		i := int(id)
-		tvdbID = &i
+	userID, err := h.db.CreateUser(data.Username, string(hash), data.Role, data.AutoApprove)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if requesterName == "" || title == "" {
-		h.errorResponse(w, "Missing required fields", http.StatusBadRequest)
+	h.db.LogActivity("user_created", map[string]interface{}{
+		"user_id":  userID,
+		"username": data.Username,
+		"role":     data.Role,
+	})
+
+	h.jsonResponse(w, map[string]interface{}{
+		"success": true,
+		"userId":  userID,
+	})
+}
+
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.db.GetUsers()
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if mediaType == "" {
-		mediaType = "series"
+	if users == nil {
+		users = []models.User{}
 	}
 
-	// Check if already exists
-	if mediaType == "series" {
-		if tvdbID == nil {
-			h.errorResponse(w, "Missing tvdbId for series", http.StatusBadRequest)
+	h.jsonResponse(w, users)
+}
+
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	var data struct {
+		AutoApprove    *bool   `json:"autoApprove"`
+		Role           *string `json:"role"`
+		ResetTwoFactor bool    `json:"resetTwoFactor"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.db.GetUser(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		h.errorResponse(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if data.Role != nil && *data.Role != user.Role {
+		if *data.Role != "admin" && *data.Role != "user" {
+			h.errorResponse(w, "Invalid role", http.StatusBadRequest)
 			return
 		}
-		exists, _ := h.sonarr.CheckExists(*tvdbID)
-		if exists {
-			h.errorResponse(w, "Series already exists in library", http.StatusConflict)
+		if user.Role == "admin" {
+			admins, err := h.db.CountUsers("admin")
+			if err != nil {
+				h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if admins <= 1 {
+				h.errorResponse(w, "Cannot demote the last admin", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	// Users turn off their own 2FA with a code, a reset is for another
+	// admin to let them back in
+	if data.ResetTwoFactor {
+		if currentID, _ := h.sessionUser(r); currentID == id {
+			h.errorResponse(w, "Use /api/admin/2fa/disable to turn off your own two-factor authentication", http.StatusBadRequest)
 			return
 		}
-	} else {
-		if tmdbID == nil {
-			h.errorResponse(w, "Missing tmdbId for movie", http.StatusBadRequest)
+	}
+
+	if data.AutoApprove != nil {
+		if err := h.db.UpdateUserAutoApprove(id, *data.AutoApprove); err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		exists, _ := h.radarr.CheckExists(*tmdbID)
-		if exists {
-			h.errorResponse(w, "Movie already exists in library", http.StatusConflict)
+	}
+
+	if data.ResetTwoFactor {
+		if err := h.clearTwoFactor(id); err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
-	// Check for duplicate request
-	duplicate, _ := h.db.CheckDuplicateRequest(mediaType, tmdbID, tvdbID)
-	if duplicate {
-		h.errorResponse(w, "This has already been requested", http.StatusConflict)
+	// A role change signs the user out, so the new role applies to fresh
+	// sessions only
+	if data.Role != nil && *data.Role != user.Role {
+		if err := h.db.UpdateUserRole(id, *data.Role); err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.db.LogActivity("user_updated", map[string]interface{}{
+		"user_id":          id,
+		"username":         user.Username,
+		"auto_approve":     data.AutoApprove,
+		"role":             data.Role,
+		"reset_two_factor": data.ResetTwoFactor,
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
+
+	user, err := h.db.GetUser(id)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		h.errorResponse(w, "User not found", http.StatusNotFound)
 		return
 	}
 
-	// Build request object
-	var reqEmail, reqPoster, reqImdbID *string
-	if requesterEmail != "" {
-		reqEmail = &requesterEmail
+	if currentID, _ := h.sessionUser(r); currentID == id {
+		h.errorResponse(w, "You cannot delete your own account", http.StatusBadRequest)
+		return
 	}
-	if poster != "" {
-		reqPoster = &poster
+
+	if user.Role == "admin" {
+		admins, err := h.db.CountUsers("admin")
+		if err != nil {
+			h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if admins <= 1 {
+			h.errorResponse(w, "Cannot delete the last admin", http.StatusBadRequest)
+			return
+		}
 	}
-	if imdbID != "" {
-		reqImdbID = &imdbID
+
+	if err := h.db.DeleteUser(id); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// User ids can be reused, a new account mustn't inherit the 2FA
+	if err := h.clearTwoFactor(id); err != nil {
+		slog.Error("Failed to remove deleted user's two-factor settings", "user_id", id, "error", err)
 	}
 
-	req := &models.Request{
-		RequesterName:  requesterName,
-		RequesterEmail: reqEmail,
-		MediaType:      mediaType,
-		TmdbID:         tmdbID,
-		TvdbID:         tvdbID,
-		ImdbID:         reqImdbID,
-		Title:          title,
-		Year:           year,
-		Poster:         reqPoster,
+	h.db.LogActivity("user_deleted", map[string]interface{}{
+		"user_id":  id,
+		"username": user.Username,
+	})
+
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// API keys
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name   string `json:"name"`
+		UserID int    `json:"userId"`
 	}
 
-	requestID, err := h.db.CreateRequest(req)
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" {
+		h.errorResponse(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	// Keys act as the creating admin unless issued for another user
+	if data.UserID == 0 {
+		data.UserID, _ = h.sessionUser(r)
+	}
+	user, err := h.db.GetUser(data.UserID)
 	if err != nil {
 		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if user == nil {
+		h.errorResponse(w, "User not found", http.StatusBadRequest)
+		return
+	}
 
-	h.db.LogActivity("request_created", map[string]interface{}{
-		"request_id": requestID,
-		"media_type": mediaType,
-		"title":      title,
-		"requester":  requesterName,
-	})
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := "rqa_" + hex.EncodeToString(b)
 
-	emoji := "📺"
-	typeWord := "Series"
-	if mediaType == "movie" {
-		emoji = "🎬"
-		typeWord = "Movie"
+	keyID, err := h.db.CreateAPIKey(data.Name, hashAPIKey(key), user.ID)
+	if err != nil {
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	h.notify.Send(fmt.Sprintf("%s New %s Request", emoji, typeWord), fmt.Sprintf("**%s** requested **%s**", requesterName, title), "")
+
+	h.db.LogActivity("api_key_created", map[string]interface{}{
+		"key_id":   keyID,
+		"name":     data.Name,
+		"username": user.Username,
+	})
 
 	h.jsonResponse(w, map[string]interface{}{
-		"success":   true,
-		"requestId": requestID,
-		"message":   "Request submitted successfully",
+		"success": true,
+		"id":      keyID,
+		"key":     key,
+		"message": "Store this key now, it won't be shown again",
 	})
 }
 
-func (h *Handler) GetRequests(w http.ResponseWriter, r *http.Request) {
-	status := r.URL.Query().Get("status")
-	mediaType := r.URL.Query().Get("mediaType")
-
-	requests, err := h.db.GetRequests(status, mediaType)
+func (h *Handler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.db.GetAPIKeys()
 	if err != nil {
 		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if requests == nil {
-		requests = []models.Request{}
+	if keys == nil {
+		keys = []models.APIKey{}
 	}
 
-	h.jsonResponse(w, requests)
+	h.jsonResponse(w, keys)
 }
 
-func (h *Handler) GetRequest(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, _ := strconv.Atoi(vars["id"])
 
-	req, err := h.db.GetRequest(id)
+	deleted, err := h.db.DeleteAPIKey(id)
 	if err != nil {
 		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if req == nil {
-		h.errorResponse(w, "Request not found", http.StatusNotFound)
+	if !deleted {
+		h.errorResponse(w, "API key not found", http.StatusNotFound)
 		return
 	}
 
-	h.jsonResponse(w, req)
-}
+	h.db.LogActivity("api_key_revoked", map[string]interface{}{
+		"key_id": id,
+	})
 
-func (h *Handler) UpdateRequestStatus(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, _ := strconv.Atoi(vars["id"])
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
 
-	var data struct {
-		Status     string `json:"status"`
-		AdminNotes string `json:"adminNotes"`
+// Webhooks
+// Sonarr and Radarr webhooks are authenticated with ?token=<webhook_token>,
+// and are rejected until that setting is configured.
+func (h *Handler) webhookAuthorized(r *http.Request) bool {
+	token := h.db.GetSetting("webhook_token")
+	if token == "" {
+		return false
 	}
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+func (h *Handler) SonarrWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.webhookAuthorized(r) {
+		h.errorResponse(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	validStatuses := map[string]bool{"pending": true, "approved": true, "rejected": true, "completed": true}
-	if !validStatuses[data.Status] {
-		h.errorResponse(w, "Invalid status", http.StatusBadRequest)
-		return
+	var payload struct {
+		EventType string `json:"eventType"`
+		Series    struct {
+			TvdbID int `json:"tvdbId"`
+		} `json:"series"`
 	}
 
-	if err := h.db.UpdateRequestStatus(id, data.Status, data.AdminNotes); err != nil {
-		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	h.db.LogActivity("request_status_updated", map[string]interface{}{
-		"request_id": id,
-		"new_status": data.Status,
-	})
+	switch payload.EventType {
+	case "SeriesAdd", "SeriesDelete":
+		h.sonarr.InvalidateExisting()
+	case "Grab":
+		h.markDownloading("series", payload.Series.TvdbID, false)
+	case "Download":
+		h.updateSeriesProgress(r.Context(), payload.Series.TvdbID)
+	}
 
 	h.jsonResponse(w, map[string]bool{"success": true})
 }
 
-func (h *Handler) ApproveRequest(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, _ := strconv.Atoi(vars["id"])
-
-	req, err := h.db.GetRequest(id)
-	if err != nil {
-		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
+// RadarrWebhook handles events from either Radarr, the 4K instance should
+// call it with ?instance=4k
+func (h *Handler) RadarrWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.webhookAuthorized(r) {
+		h.errorResponse(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if req == nil {
-		h.errorResponse(w, "Request not found", http.StatusNotFound)
-		return
+
+	var payload struct {
+		EventType string `json:"eventType"`
+		Movie     struct {
+			TmdbID int `json:"tmdbId"`
+		} `json:"movie"`
 	}
 
-	// Parse with flexible types
-	var raw map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	rootFolder, _ := raw["rootFolder"].(string)
-	monitor, _ := raw["monitor"].(string)
-	minimumAvailability, _ := raw["minimumAvailability"].(string)
+	is4K := r.URL.Query().Get("instance") == "4k"
+	switch payload.EventType {
+	case "MovieAdded", "MovieDelete":
+		if is4K {
+			h.radarr4k.InvalidateExisting()
+		} else {
+			h.radarr.InvalidateExisting()
+		}
+	case "Grab":
+		h.markDownloading("movie", payload.Movie.TmdbID, is4K)
+	case "Download", "MovieFileImport":
+		h.completeRequests(r.Context(), "movie", payload.Movie.TmdbID, is4K)
+	}
 
-	// Handle qualityProfile - could be string or number
-	var qualityProfileID int
-	if qp, ok := raw["qualityProfile"].(float64); ok {
-		qualityProfileID = int(qp)
-	} else if qp, ok := raw["qualityProfile"].(string); ok && qp != "" {
-		qualityProfileID, _ = strconv.Atoi(qp)
+	h.jsonResponse(w, map[string]bool{"success": true})
+}
+
+// markDownloading moves approved requests for a grabbed title to downloading
+func (h *Handler) markDownloading(mediaType string, externalID int, is4K bool) {
+	if externalID == 0 {
+		return
 	}
 
-	if qualityProfileID == 0 {
-		h.errorResponse(w, "Quality profile required", http.StatusBadRequest)
+	requests, err := h.db.GetApprovedRequestsByExternalID(mediaType, externalID)
+	if err != nil {
 		return
 	}
 
-	var arrID int
-	if req.MediaType == "series" {
-		if req.TvdbID == nil {
-			h.errorResponse(w, "No TVDB ID for series", http.StatusBadRequest)
-			return
-		}
-		if monitor == "" {
-			monitor = "all"
-		}
-		result, err := h.sonarr.AddSeries(*req.TvdbID, rootFolder, qualityProfileID, monitor)
-		if err != nil {
-			h.errorResponse(w, "Failed to add to Sonarr: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if id, ok := result["id"].(float64); ok {
-			arrID = int(id)
-		}
-	} else {
-		if req.TmdbID == nil {
-			h.errorResponse(w, "No TMDB ID for movie", http.StatusBadRequest)
-			return
-		}
-		if minimumAvailability == "" {
-			minimumAvailability = "announced"
-		}
-		result, err := h.radarr.AddMovie(*req.TmdbID, rootFolder, qualityProfileID, minimumAvailability)
-		if err != nil {
-			h.errorResponse(w, "Failed to add to Radarr: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if id, ok := result["id"].(float64); ok {
-			arrID = int(id)
+	for _, req := range requests {
+		if req.Is4K == is4K && req.Status == "approved" {
+			h.db.MarkRequestDownloading(req.ID)
 		}
 	}
+}
 
-	h.db.UpdateRequestStatus(id, "approved", "")
-	h.db.UpdateRequestArrID(id, arrID)
-
-	h.db.LogActivity("request_approved", map[string]interface{}{
-		"request_id": id,
-		"title":      req.Title,
-		"arr_id":     arrID,
-	})
-
-	emoji := "📺"
-	typeWord := "Series"
-	if req.MediaType == "movie" {
-		emoji = "🎬"
-		typeWord = "Movie"
+// completeRequests marks approved requests for an imported title as
+// completed. The background poller still catches anything a webhook missed.
+func (h *Handler) completeRequests(ctx context.Context, mediaType string, externalID int, is4K bool) {
+	if externalID == 0 {
+		return
 	}
-	h.notify.Send(fmt.Sprintf("%s %s Approved", emoji, typeWord), fmt.Sprintf("**%s** has been approved and is being downloaded!", req.Title), "")
-
-	h.jsonResponse(w, map[string]interface{}{
-		"success": true,
-		"arrId":   arrID,
-	})
-}
 
-// Admin
-func (h *Handler) AdminCheck(w http.ResponseWriter, r *http.Request) {
-	session, _ := h.store.Get(r, "session")
-	isAdmin := session.Values["is_admin"] == true
-	h.jsonResponse(w, map[string]bool{"isAdmin": isAdmin})
-}
+	requests, err := h.db.GetApprovedRequestsByExternalID(mediaType, externalID)
+	if err != nil {
+		return
+	}
 
-func (h *Handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
-	var data struct {
-		Password string `json:"password"`
+	for _, req := range requests {
+		if req.Is4K != is4K {
+			continue
+		}
+		// Any import completes a request, an upgrade only one meeting the cutoff
+		if req.IsUpgrade {
+			completed, progress, err := h.CheckCompletion(ctx, req)
+			if err != nil {
+				continue
+			}
+			h.applyCompletion(ctx, req, completed, progress, "webhook")
+			continue
+		}
+		h.completeRequest(ctx, req, "webhook")
 	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+// updateSeriesProgress checks a series after an episode import, completing
+// its requests once every monitored episode is in
+func (h *Handler) updateSeriesProgress(ctx context.Context, tvdbID int) {
+	if tvdbID == 0 {
 		return
 	}
 
-	if data.Password != h.adminPassword {
-		h.errorResponse(w, "Invalid password", http.StatusUnauthorized)
+	requests, err := h.db.GetApprovedRequestsByExternalID("series", tvdbID)
+	if err != nil {
 		return
 	}
 
-	session, _ := h.store.Get(r, "session")
-	session.Values["is_admin"] = true
-	session.Save(r, w)
-
-	h.db.LogActivity("admin_login", nil)
-
-	h.jsonResponse(w, map[string]bool{"success": true})
+	for _, req := range requests {
+		completed, progress, err := h.CheckCompletion(ctx, req)
+		if err != nil {
+			continue
+		}
+		h.applyCompletion(ctx, req, completed, progress, "webhook")
+	}
 }
 
-func (h *Handler) AdminLogout(w http.ResponseWriter, r *http.Request) {
-	session, _ := h.store.Get(r, "session")
-	session.Values["is_admin"] = false
-	session.Save(r, w)
+// CheckCompletedDownloads checks every approved request against Sonarr and
+// Radarr, completing the ones that are available. Requests found in a
+// download queue are marked downloading, catching grabs the webhooks missed.
+func (h *Handler) CheckCompletedDownloads(ctx context.Context) {
+	requests, err := h.db.GetApprovedRequests()
+	if err != nil {
+		slog.Error("Failed to get approved requests", "error", err)
+		return
+	}
 
-	h.jsonResponse(w, map[string]bool{"success": true})
-}
+	// Queues are fetched at most once per check, and only when needed
+	queues := make(map[string][]map[string]interface{})
+	queueFor := func(name string, getQueue func(context.Context) ([]map[string]interface{}, error)) []map[string]interface{} {
+		if queue, ok := queues[name]; ok {
+			return queue
+		}
+		queue, err := getQueue(ctx)
+		if err != nil {
+			slog.Error("Failed to get queue", "instance", name, "error", err)
+		}
+		queues[name] = queue
+		return queue
+	}
 
-func (h *Handler) GetAdminSettings(w http.ResponseWriter, r *http.Request) {
-	settings, _ := h.db.GetAllSettings()
+	for _, req := range requests {
+		if req.ArrID == nil {
+			continue
+		}
 
-	// Initialize as empty slices (not nil) so JSON returns [] instead of null
-	sonarrRootFolders := make([]map[string]interface{}, 0)
-	sonarrQualityProfiles := make([]map[string]interface{}, 0)
-	var sonarrError string
-	if settings["sonarr_url"] != "" && settings["sonarr_api_key"] != "" {
-		rf, err := h.sonarr.GetRootFolders()
-		if err != nil {
-			sonarrError = err.Error()
-		} else if rf != nil {
-			sonarrRootFolders = rf
+		// An arr that can't be reached now is tried again on the next check
+		completed, progress, err := h.CheckCompletion(ctx, req)
+		if err == nil {
+			h.applyCompletion(ctx, req, completed, progress, "poll")
 		}
-		qp, err := h.sonarr.GetQualityProfiles()
-		if err != nil && sonarrError == "" {
-			sonarrError = err.Error()
-		} else if qp != nil {
-			sonarrQualityProfiles = qp
+		if completed || req.Status != "approved" {
+			continue
 		}
-	}
 
-	radarrRootFolders := make([]map[string]interface{}, 0)
-	radarrQualityProfiles := make([]map[string]interface{}, 0)
-	var radarrError string
-	if settings["radarr_url"] != "" && settings["radarr_api_key"] != "" {
-		rf, err := h.radarr.GetRootFolders()
-		if err != nil {
-			radarrError = err.Error()
-		} else if rf != nil {
-			radarrRootFolders = rf
+		var status *services.QueueStatus
+		if req.MediaType == "series" {
+			status = services.SummarizeQueue(queueFor("sonarr", h.sonarr.GetQueue), "seriesId", *req.ArrID)
+		} else {
+			instanceName := "radarr"
+			if req.Is4K {
+				instanceName = "radarr_4k"
+			}
+			status = services.SummarizeQueue(queueFor(instanceName, h.radarrFor(&req).GetQueue), "movieId", *req.ArrID)
 		}
-		qp, err := h.radarr.GetQualityProfiles()
-		if err != nil && radarrError == "" {
-			radarrError = err.Error()
-		} else if qp != nil {
-			radarrQualityProfiles = qp
+		if status != nil {
+			h.db.MarkRequestDownloading(req.ID)
 		}
 	}
+}
 
-	h.jsonResponse(w, map[string]interface{}{
-		"settings": map[string]string{
-			"sonarr_url":      settings["sonarr_url"],
-			"sonarr_api_key":  settings["sonarr_api_key"],
-			"radarr_url":      settings["radarr_url"],
-			"radarr_api_key":  settings["radarr_api_key"],
-			"discord_webhook": settings["discord_webhook"],
-			"ntfy_url":        settings["ntfy_url"],
-			"ntfy_topic":      settings["ntfy_topic"],
-			"tmdb_api_key":    settings["tmdb_api_key"],
-			"mdblist_api_key": settings["mdblist_api_key"],
-		},
-		"sonarr": map[string]interface{}{
-			"rootFolders":     sonarrRootFolders,
-			"qualityProfiles": sonarrQualityProfiles,
-			"error":           sonarrError,
-		},
-		"radarr": map[string]interface{}{
-			"rootFolders":     radarrRootFolders,
-			"qualityProfiles": radarrQualityProfiles,
-			"error":           radarrError,
-		},
-	})
+// seriesProgress is what completion checks need from Sonarr
+type seriesProgress interface {
+	EpisodeProgress(ctx context.Context, seriesID int) (int, int, error)
+	CutoffMet(ctx context.Context, seriesID int) (bool, error)
 }
 
-func (h *Handler) UpdateAdminSettings(w http.ResponseWriter, r *http.Request) {
-	var data map[string]string
+// movieProgress is what completion checks need from Radarr
+type movieProgress interface {
+	GetMovie(ctx context.Context, id int) (map[string]interface{}, error)
+	CutoffMet(ctx context.Context, id int) (bool, error)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+// CheckCompletion asks Sonarr or Radarr whether an approved request is
+// available, see checkCompletion
+func (h *Handler) CheckCompletion(ctx context.Context, req models.Request) (bool, int, error) {
+	return checkCompletion(ctx, req, h.sonarr, h.radarrFor(&req))
+}
 
-	allowedSettings := map[string]bool{
-		"sonarr_url":      true,
-		"sonarr_api_key":  true,
-		"radarr_url":      true,
-		"radarr_api_key":  true,
-		"discord_webhook": true,
-		"ntfy_url":        true,
-		"ntfy_topic":      true,
-		"tmdb_api_key":    true,
-		"mdblist_api_key": true,
+// checkCompletion reports whether req is available and its progress. Series
+// are complete once every monitored episode has a file, with progress the
+// percent downloaded so far; movies once the file is in. Upgrades wait for
+// files meeting the new quality profile's cutoff.
+func checkCompletion(ctx context.Context, req models.Request, sonarr seriesProgress, radarr movieProgress) (bool, int, error) {
+	if req.ArrID == nil {
+		return false, 0, nil
 	}
 
-	for key, value := range data {
-		if allowedSettings[key] {
-			h.db.SetSetting(key, value)
+	if req.IsUpgrade {
+		var met bool
+		var err error
+		if req.MediaType == "series" {
+			met, err = sonarr.CutoffMet(ctx, *req.ArrID)
+		} else {
+			met, err = radarr.CutoffMet(ctx, *req.ArrID)
 		}
+		return met, req.DownloadProgress, err
 	}
 
-	h.db.LogActivity("settings_updated", map[string]interface{}{
-		"keys": getKeys(data),
-	})
-
-	h.jsonResponse(w, map[string]bool{"success": true})
-}
+	if req.MediaType == "series" {
+		files, monitored, err := sonarr.EpisodeProgress(ctx, *req.ArrID)
+		if err != nil || files == 0 {
+			return false, 0, err
+		}
+		return files >= monitored, services.SeriesProgress(files, monitored), nil
+	}
 
-func (h *Handler) TestConnection(w http.ResponseWriter, r *http.Request) {
-	var data struct {
-		Service string `json:"service"`
-		URL     string `json:"url"`
-		APIKey  string `json:"apiKey"`
+	movie, err := radarr.GetMovie(ctx, *req.ArrID)
+	if err != nil || movie == nil {
+		return false, 0, err
 	}
+	hasFile, _ := movie["hasFile"].(bool)
+	return hasFile, req.DownloadProgress, nil
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		h.errorResponse(w, "Invalid request body", http.StatusBadRequest)
+// applyCompletion records the outcome of CheckCompletion, completing the
+// request or updating its progress and announcing the first episode
+func (h *Handler) applyCompletion(ctx context.Context, req models.Request, completed bool, progress int, source string) {
+	if completed {
+		h.completeRequest(ctx, req, source)
 		return
 	}
-
-	if data.URL == "" || data.APIKey == "" {
-		h.errorResponse(w, "URL and API key are required", http.StatusBadRequest)
+	if progress == 0 || progress == req.DownloadProgress {
 		return
 	}
 
-	var result map[string]interface{}
-	var err error
-
-	if data.Service == "sonarr" {
-		result, err = h.sonarr.TestConnection(data.URL, data.APIKey)
-	} else {
-		result, err = h.radarr.TestConnection(data.URL, data.APIKey)
+	if req.DownloadProgress == 0 {
+		h.goBackground(ctx, func(ctx context.Context) {
+			if err := h.notify.SendFirstEpisodeReady(ctx, req); err != nil {
+				slog.Error("Failed to send first episode notification", "request_id", req.ID, "error", err)
+			}
+		})
 	}
+	h.db.UpdateRequestProgress(req.ID, progress)
+}
+
+// RefreshRequest checks an approved request against Sonarr or Radarr right
+// away instead of waiting for the next poll, returning its updated status
+func (h *Handler) RefreshRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, _ := strconv.Atoi(vars["id"])
 
+	req, err := h.db.GetRequest(id)
 	if err != nil {
-		h.jsonResponse(w, map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		})
+		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if req == nil {
+		h.errorResponse(w, "Request not found", http.StatusNotFound)
+		return
+	}
+
+	// Only approved and downloading requests are waiting on the arr
+	if req.Status == "approved" || req.Status == "downloading" {
+		completed, progress, err := h.CheckCompletion(r.Context(), *req)
+		if err != nil {
+			h.errorResponse(w, "Failed to check availability: "+err.Error(), arrErrorStatus(err))
+			return
+		}
+		h.applyCompletion(r.Context(), *req, completed, progress, "refresh")
+
+		if req, err = h.db.GetRequest(id); err != nil || req == nil {
+			h.errorResponse(w, "Failed to reload request", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	h.jsonResponse(w, map[string]interface{}{
-		"success": true,
-		"version": result["version"],
-		"appName": result["appName"],
+		"success":          true,
+		"status":           req.Status,
+		"downloadProgress": req.DownloadProgress,
 	})
 }
 
-func (h *Handler) GetActivity(w http.ResponseWriter, r *http.Request) {
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	if limit <= 0 {
-		limit = 50
-	}
+func (h *Handler) completeRequest(ctx context.Context, req models.Request, source string) {
+	h.db.UpdateRequestStatus(req.ID, "completed", "")
+	h.db.UpdateRequestProgress(req.ID, 100)
+	h.db.LogActivity("request_completed", map[string]interface{}{
+		"request_id": req.ID,
+		"title":      req.Title,
+		"source":     source,
+	})
+	h.goBackground(ctx, func(ctx context.Context) {
+		if err := h.notify.SendRequestReady(ctx, req); err != nil {
+			slog.Error("Failed to send ready notification", "request_id", req.ID, "error", err)
+		}
+	})
+}
 
-	activities, err := h.db.GetActivity(limit)
-	if err != nil {
-		h.errorResponse(w, err.Error(), http.StatusInternalServerError)
-		return
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
 	}
-
-	if activities == nil {
-		activities = []models.Activity{}
+	if len(value) <= 4 {
+		return "****"
 	}
-
-	h.jsonResponse(w, activities)
+	return "****" + value[len(value)-4:]
 }
 
 func getKeys(m map[string]string) []string {
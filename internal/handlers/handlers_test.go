@@ -0,0 +1,702 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IcarusCore/Requestarr/internal/auth"
+	"github.com/IcarusCore/Requestarr/internal/cache"
+	"github.com/IcarusCore/Requestarr/internal/models"
+	"github.com/IcarusCore/Requestarr/internal/services"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeSonarr answers completion checks with fixed episode counts
+type fakeSonarr struct {
+	files, monitored int
+	cutoffMet        bool
+	err              error
+}
+
+func (f *fakeSonarr) EpisodeProgress(ctx context.Context, seriesID int) (int, int, error) {
+	return f.files, f.monitored, f.err
+}
+
+func (f *fakeSonarr) CutoffMet(ctx context.Context, seriesID int) (bool, error) {
+	return f.cutoffMet, f.err
+}
+
+// fakeRadarr answers completion checks with a fixed movie
+type fakeRadarr struct {
+	movie     map[string]interface{}
+	cutoffMet bool
+	err       error
+}
+
+func (f *fakeRadarr) GetMovie(ctx context.Context, id int) (map[string]interface{}, error) {
+	return f.movie, f.err
+}
+
+func (f *fakeRadarr) CutoffMet(ctx context.Context, id int) (bool, error) {
+	return f.cutoffMet, f.err
+}
+
+func TestCheckCompletion(t *testing.T) {
+	arrID := 7
+	errArr := errors.New("Sonarr returned 500")
+
+	tests := []struct {
+		name         string
+		req          models.Request
+		sonarr       *fakeSonarr
+		radarr       *fakeRadarr
+		wantComplete bool
+		wantProgress int
+		wantErr      bool
+	}{
+		{
+			name:   "series without episode files",
+			req:    models.Request{MediaType: "series", ArrID: &arrID},
+			sonarr: &fakeSonarr{files: 0, monitored: 10},
+		},
+		{
+			name:         "series with some episode files",
+			req:          models.Request{MediaType: "series", ArrID: &arrID},
+			sonarr:       &fakeSonarr{files: 3, monitored: 10},
+			wantProgress: 30,
+		},
+		{
+			name:         "series with every episode file",
+			req:          models.Request{MediaType: "series", ArrID: &arrID},
+			sonarr:       &fakeSonarr{files: 10, monitored: 10},
+			wantComplete: true,
+			wantProgress: 100,
+		},
+		{
+			name:    "series lookup failing",
+			req:     models.Request{MediaType: "series", ArrID: &arrID},
+			sonarr:  &fakeSonarr{err: errArr},
+			wantErr: true,
+		},
+		{
+			name:         "series upgrade meeting the cutoff",
+			req:          models.Request{MediaType: "series", ArrID: &arrID, IsUpgrade: true, DownloadProgress: 40},
+			sonarr:       &fakeSonarr{cutoffMet: true},
+			wantComplete: true,
+			wantProgress: 40,
+		},
+		{
+			name:   "movie without a file",
+			req:    models.Request{MediaType: "movie", ArrID: &arrID},
+			radarr: &fakeRadarr{movie: map[string]interface{}{"hasFile": false}},
+		},
+		{
+			name:         "movie with a file",
+			req:          models.Request{MediaType: "movie", ArrID: &arrID},
+			radarr:       &fakeRadarr{movie: map[string]interface{}{"hasFile": true}},
+			wantComplete: true,
+		},
+		{
+			name:   "movie removed from Radarr",
+			req:    models.Request{MediaType: "movie", ArrID: &arrID},
+			radarr: &fakeRadarr{},
+		},
+		{
+			name:   "movie upgrade below the cutoff",
+			req:    models.Request{MediaType: "movie", ArrID: &arrID, IsUpgrade: true},
+			radarr: &fakeRadarr{movie: map[string]interface{}{"hasFile": true}},
+		},
+		{
+			name: "request not in an arr yet",
+			req:  models.Request{MediaType: "movie"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.sonarr == nil {
+				tt.sonarr = &fakeSonarr{err: errors.New("Sonarr called")}
+			}
+			if tt.radarr == nil {
+				tt.radarr = &fakeRadarr{err: errors.New("Radarr called")}
+			}
+
+			complete, progress, err := checkCompletion(context.Background(), tt.req, tt.sonarr, tt.radarr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if complete != tt.wantComplete {
+				t.Errorf("complete = %v, want %v", complete, tt.wantComplete)
+			}
+			if progress != tt.wantProgress {
+				t.Errorf("progress = %d, want %d", progress, tt.wantProgress)
+			}
+		})
+	}
+}
+
+func TestRadarrWebhookCompletesUpgradeAtCutoff(t *testing.T) {
+	tests := []struct {
+		name         string
+		cutoffNotMet bool
+		wantRequest  string
+		wantUpgrade  string
+	}{
+		{"below the cutoff", true, "completed", "approved"},
+		{"cutoff met", false, "completed", "completed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie, _ := json.Marshal(map[string]interface{}{
+				"id":        42,
+				"hasFile":   true,
+				"movieFile": map[string]interface{}{"qualityCutoffNotMet": tt.cutoffNotMet},
+			})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(movie)
+			}))
+			t.Cleanup(server.Close)
+
+			settings := radarrSettings(server)
+			settings["webhook_token"] = "secret"
+			h := newTestHandler(t, settings)
+
+			approved := func(isUpgrade bool) int {
+				tmdbID := 603
+				id, err := h.db.CreateRequest(&models.Request{RequesterName: "alice", MediaType: "movie", TmdbID: &tmdbID, Title: "The Matrix", IsUpgrade: isUpgrade})
+				if err != nil {
+					t.Fatal(err)
+				}
+				h.db.UpdateRequestStatus(int(id), "approved", "")
+				h.db.UpdateRequestArrID(int(id), 42)
+				return int(id)
+			}
+			requestID, upgradeID := approved(false), approved(true)
+
+			w := httptest.NewRecorder()
+			h.RadarrWebhook(w, httptest.NewRequest("POST", "/api/webhook/radarr?token=secret", strings.NewReader(`{"eventType": "Download", "movie": {"tmdbId": 603}}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			for id, want := range map[int]string{requestID: tt.wantRequest, upgradeID: tt.wantUpgrade} {
+				req, err := h.db.GetRequest(id)
+				if err != nil || req == nil {
+					t.Fatalf("GetRequest(%d): %v", id, err)
+				}
+				if req.Status != want {
+					t.Errorf("request %d (upgrade %v) status = %s, want %s", id, req.IsUpgrade, req.Status, want)
+				}
+			}
+		})
+	}
+}
+
+// newTestHandler returns a handler on a fresh database with the given
+// settings
+func newTestHandler(t *testing.T, settings map[string]string) *Handler {
+	t.Helper()
+
+	db, err := models.InitDB(filepath.Join(t.TempDir(), "requestarr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for key, value := range settings {
+		db.SetSetting(key, value)
+	}
+
+	appCache := cache.NewCache(time.Minute, 0, "")
+	h := &Handler{
+		db:        db,
+		store:     sessions.NewCookieStore([]byte("test")),
+		secretKey: "test",
+		sonarr:    services.NewSonarrService(db, appCache),
+		radarr:    services.NewRadarrService(db, appCache, "radarr"),
+		radarr4k:  services.NewRadarrService(db, appCache, "radarr_4k"),
+		notify:    services.NewNotificationService(db),
+		cache:     appCache,
+		approvals: &approvalLocks{inFlight: make(map[int]bool)},
+	}
+	// Background work has to finish before the database closes
+	t.Cleanup(h.Wait)
+	return h
+}
+
+// radarrSettings points a test handler at a fake Radarr
+func radarrSettings(server *httptest.Server) map[string]string {
+	return map[string]string{"radarr_url": server.URL, "radarr_api_key": "test"}
+}
+
+// fakeRadarrServer is a Radarr that counts the movies added to it. Adding
+// is slow, so concurrent approvals overlap.
+func fakeRadarrServer(t *testing.T, adds *int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v3/rootfolder":
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 1, "path": "/movies"}})
+		case r.URL.Path == "/api/v3/qualityprofile":
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"id": 1, "name": "HD"}})
+		case r.URL.Path == "/api/v3/movie/lookup/tmdb":
+			json.NewEncoder(w).Encode(map[string]interface{}{"title": "The Matrix", "tmdbId": 603})
+		case r.URL.Path == "/api/v3/movie" && r.Method == "POST":
+			atomic.AddInt32(adds, 1)
+			time.Sleep(50 * time.Millisecond)
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 42})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func createMovieRequest(t *testing.T, h *Handler) *models.Request {
+	t.Helper()
+
+	tmdbID := 603
+	id, err := h.db.CreateRequest(&models.Request{RequesterName: "alice", MediaType: "movie", TmdbID: &tmdbID, Title: "The Matrix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := h.db.GetRequest(int(id))
+	if err != nil || req == nil {
+		t.Fatalf("GetRequest: %v", err)
+	}
+	return req
+}
+
+var testApproval = approvalOptions{RootFolder: "/movies", QualityProfileID: 1, MinimumAvailability: "released"}
+
+func TestApproveTwiceAddsOnce(t *testing.T) {
+	var adds int32
+	h := newTestHandler(t, radarrSettings(fakeRadarrServer(t, &adds)))
+	req := createMovieRequest(t, h)
+
+	arrID, err := h.approve(context.Background(), req, 1, testApproval)
+	if err != nil || arrID != 42 {
+		t.Fatalf("first approve = %d, %v, want 42, nil", arrID, err)
+	}
+
+	// req is stale, approve has to notice the request was approved since
+	arrID, err = h.approve(context.Background(), req, 1, testApproval)
+	if !errors.Is(err, errAlreadyApproved) || arrID != 42 {
+		t.Fatalf("second approve = %d, %v, want 42, %v", arrID, err, errAlreadyApproved)
+	}
+
+	if adds != 1 {
+		t.Errorf("Radarr got %d adds, want 1", adds)
+	}
+}
+
+func TestApproveConcurrentlyAddsOnce(t *testing.T) {
+	var adds int32
+	h := newTestHandler(t, radarrSettings(fakeRadarrServer(t, &adds)))
+	req := createMovieRequest(t, h)
+
+	const approvals = 5
+	errs := make([]error, approvals)
+	var wg sync.WaitGroup
+	for i := 0; i < approvals; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = h.approve(context.Background(), req, 1, testApproval)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		var approvalErr *approvalError
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, errAlreadyApproved):
+		case errors.As(err, &approvalErr) && approvalErr.status == http.StatusConflict:
+		default:
+			t.Errorf("approve failed: %v", err)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Errorf("%d approvals succeeded, want 1", succeeded)
+	}
+	if adds != 1 {
+		t.Errorf("Radarr got %d adds, want 1", adds)
+	}
+}
+
+func TestRequestUpgradeAppliesQuota(t *testing.T) {
+	tests := []struct {
+		name       string
+		quota      string
+		wantStatus int
+	}{
+		{"unlimited", "0", http.StatusOK},
+		{"room left", "2", http.StatusOK},
+		{"quota used up by the original", "1", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, map[string]string{"quota_movie_limit": tt.quota})
+			original := createMovieRequest(t, h)
+			if err := h.db.UpdateRequestStatus(original.ID, "completed", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("POST", "/api/requests/1/upgrade", strings.NewReader(`{"requesterName": "alice"}`))
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(original.ID)})
+			w := httptest.NewRecorder()
+			h.RequestUpgrade(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestRequestsFeedLinksEachRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		publicURL string
+		wantLink  string
+	}{
+		{"public url", "https://requests.example.com/", "https://requests.example.com/#request-%d"},
+		{"request host", "", "http://requestarr.local/#request-%d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, map[string]string{"public_url": tt.publicURL, "feed_token": "secret"})
+			reqs := []*models.Request{createMovieRequest(t, h), createMovieRequest(t, h)}
+
+			w := httptest.NewRecorder()
+			h.RequestsFeed(w, httptest.NewRequest("GET", "http://requestarr.local/api/requests/feed.xml?token=secret", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var feed rssFeed
+			if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]bool{}
+			for _, req := range reqs {
+				want[fmt.Sprintf(tt.wantLink, req.ID)] = true
+			}
+			for _, item := range feed.Channel.Items {
+				if !want[item.Link] {
+					t.Errorf("link = %s, want one of %v", item.Link, want)
+				}
+				if item.GUID.Value != item.Link || !item.GUID.IsPermaLink {
+					t.Errorf("guid = %+v, want permalink %s", item.GUID, item.Link)
+				}
+				delete(want, item.Link)
+			}
+			if len(want) > 0 {
+				t.Errorf("no items link to %v", want)
+			}
+		})
+	}
+}
+
+// statusServer is an arr answering status checks, sending the API key of
+// each one on keys
+func statusServer(t *testing.T, keys chan<- string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("X-Api-Key")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"appName": "Radarr", "version": "5.0.0"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestConnectionSendsStoredKeyOnlyToStoredURL(t *testing.T) {
+	const storedKey = "0123456789abcdef"
+
+	for _, service := range []string{"sonarr", "radarr", "radarr_4k"} {
+		t.Run(service, func(t *testing.T) {
+			keys := make(chan string, 1)
+			stored, other := statusServer(t, keys), statusServer(t, keys)
+			h := newTestHandler(t, map[string]string{service + "_url": stored.URL, service + "_api_key": storedKey})
+
+			test := func(url, apiKey string) *httptest.ResponseRecorder {
+				body, _ := json.Marshal(map[string]string{"service": service, "url": url, "apiKey": apiKey})
+				w := httptest.NewRecorder()
+				h.TestConnection(w, httptest.NewRequest("POST", "/api/admin/test-connection", strings.NewReader(string(body))))
+				return w
+			}
+
+			if w := test(stored.URL, maskSecret(storedKey)); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"success":true`) {
+				t.Fatalf("stored URL: status = %d: %s", w.Code, w.Body)
+			}
+			if got := <-keys; got != storedKey {
+				t.Errorf("stored URL got key %q, want the stored key", got)
+			}
+
+			if w := test(other.URL, maskSecret(storedKey)); w.Code != http.StatusBadRequest {
+				t.Errorf("other URL: status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			select {
+			case got := <-keys:
+				t.Errorf("other URL got key %q", got)
+			default:
+			}
+		})
+	}
+}
+
+func TestDeleteRequestKeepsSharedArrItem(t *testing.T) {
+	tests := []struct {
+		name        string
+		shared      bool
+		wantStatus  int
+		wantDeletes int32
+	}{
+		{"only request", false, http.StatusOK, 1},
+		{"another request shares the movie", true, http.StatusConflict, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deletes int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "DELETE" && r.URL.Path == "/api/v3/movie/42" {
+					atomic.AddInt32(&deletes, 1)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte("{}"))
+			}))
+			t.Cleanup(server.Close)
+			h := newTestHandler(t, radarrSettings(server))
+
+			requests := []*models.Request{createMovieRequest(t, h)}
+			if tt.shared {
+				requests = append(requests, createMovieRequest(t, h))
+			}
+			for _, req := range requests {
+				h.db.UpdateRequestArrID(req.ID, 42)
+			}
+
+			r := httptest.NewRequest("DELETE", "/api/requests/1?removeFromArr=true", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(requests[0].ID)})
+			w := httptest.NewRecorder()
+			h.DeleteRequest(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if deletes != tt.wantDeletes {
+				t.Errorf("Radarr got %d deletes, want %d", deletes, tt.wantDeletes)
+			}
+		})
+	}
+}
+
+func TestRejectOnlyPendingRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		approving  bool
+		wantStatus int
+		wantState  string
+	}{
+		{"pending", "pending", false, http.StatusOK, "rejected"},
+		{"approved", "approved", false, http.StatusConflict, "approved"},
+		{"completed", "completed", false, http.StatusConflict, "completed"},
+		{"being approved", "pending", true, http.StatusConflict, "pending"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, nil)
+			req := createMovieRequest(t, h)
+			h.db.UpdateRequestStatus(req.ID, tt.status, "")
+			if tt.approving {
+				h.approvals.acquire(req.ID)
+				defer h.approvals.release(req.ID)
+			}
+
+			r := httptest.NewRequest("POST", "/api/requests/1/reject", strings.NewReader(`{"reason": "Not available"}`))
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(req.ID)})
+			w := httptest.NewRecorder()
+			h.RejectRequest(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if req, _ = h.db.GetRequest(req.ID); req.Status != tt.wantState {
+				t.Errorf("request is %s, want %s", req.Status, tt.wantState)
+			}
+		})
+	}
+}
+
+const (
+	testPassword     = "correct horse"
+	testRecoveryCode = "abcde-12345"
+)
+
+// createTwoFactorAdmin adds an admin with 2FA on, whose recovery code is
+// testRecoveryCode
+func createTwoFactorAdmin(t *testing.T, h *Handler, username string) *models.User {
+	t.Helper()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	id, err := h.db.CreateUser(username, string(hash), "admin", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := auth.GenerateTOTPSecret()
+	encrypted, err := auth.Encrypt(h.secretKey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, _ := json.Marshal([]string{auth.HashRecoveryCode(testRecoveryCode)})
+	h.db.SetSetting(totpSetting("totp_secret", int(id)), encrypted)
+	h.db.SetSetting(totpSetting("totp_recovery", int(id)), string(recovery))
+
+	user, err := h.db.GetUser(int(id))
+	if err != nil || user == nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	return user
+}
+
+// signedIn returns r with a session cookie for user
+func signedIn(h *Handler, r *http.Request, user *models.User) *http.Request {
+	w := httptest.NewRecorder()
+	h.startSession(w, httptest.NewRequest("GET", "/", nil), user)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func hasTwoFactor(h *Handler, userID int) bool {
+	return h.db.GetSetting(totpSetting("totp_secret", userID)) != "" || h.db.GetSetting(totpSetting("totp_recovery", userID)) != ""
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		code       string
+		wantStatus int
+	}{
+		{"wrong password", "wrong password", testRecoveryCode, http.StatusBadRequest},
+		{"wrong code", testPassword, "000000", http.StatusBadRequest},
+		{"password and code", testPassword, testRecoveryCode, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, nil)
+			user := createTwoFactorAdmin(t, h, "alice")
+
+			body, _ := json.Marshal(map[string]string{"password": tt.password, "code": tt.code})
+			r := signedIn(h, httptest.NewRequest("POST", "/api/admin/2fa/disable", strings.NewReader(string(body))), user)
+			w := httptest.NewRecorder()
+			h.DisableTwoFactor(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if enabled := hasTwoFactor(h, user.ID); enabled != (tt.wantStatus != http.StatusOK) {
+				t.Errorf("2FA still enabled = %v", enabled)
+			}
+		})
+	}
+}
+
+func TestAdminResetsTwoFactor(t *testing.T) {
+	h := newTestHandler(t, nil)
+	alice, bob := createTwoFactorAdmin(t, h, "alice"), createTwoFactorAdmin(t, h, "bob")
+
+	reset := func(by, user *models.User) int {
+		r := signedIn(h, httptest.NewRequest("PUT", "/api/users/1", strings.NewReader(`{"resetTwoFactor": true}`)), by)
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(user.ID)})
+		w := httptest.NewRecorder()
+		h.UpdateUser(w, r)
+		return w.Code
+	}
+
+	if status := reset(alice, alice); status != http.StatusBadRequest || !hasTwoFactor(h, alice.ID) {
+		t.Errorf("resetting your own 2FA: status = %d, enabled = %v", status, hasTwoFactor(h, alice.ID))
+	}
+	if status := reset(bob, alice); status != http.StatusOK || hasTwoFactor(h, alice.ID) {
+		t.Errorf("resetting another admin's 2FA: status = %d, enabled = %v", status, hasTwoFactor(h, alice.ID))
+	}
+
+	r := signedIn(h, httptest.NewRequest("DELETE", "/api/users/1", nil), alice)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(bob.ID)})
+	w := httptest.NewRecorder()
+	h.DeleteUser(w, r)
+	if w.Code != http.StatusOK || hasTwoFactor(h, bob.ID) {
+		t.Errorf("deleting a user: status = %d, 2FA left = %v", w.Code, hasTwoFactor(h, bob.ID))
+	}
+}
+
+func TestSearchOmitsUnknownYear(t *testing.T) {
+	lookup := `[{"title": "Aired", "tvdbId": 1, "tmdbId": 1, "year": 2011}, {"title": "Not aired", "tvdbId": 2, "tmdbId": 2, "year": 0}, {"title": "No year", "tvdbId": 3, "tmdbId": 3}]`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/lookup") {
+			w.Write([]byte(lookup))
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(server.Close)
+
+	h := newTestHandler(t, map[string]string{
+		"sonarr_url": server.URL, "sonarr_api_key": "test",
+		"radarr_url": server.URL, "radarr_api_key": "test",
+	})
+
+	searches := map[string]func(context.Context, string) ([]map[string]interface{}, error){
+		"series": h.searchSeries,
+		"movies": h.searchMovies,
+	}
+	for name, search := range searches {
+		t.Run(name, func(t *testing.T) {
+			results, err := search(context.Background(), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 3 {
+				t.Fatalf("got %d results, want 3", len(results))
+			}
+			for i, wantYear := range []bool{true, false, false} {
+				if _, hasYear := results[i]["year"]; hasYear != wantYear {
+					t.Errorf("%s: year present = %v, want %v", results[i]["title"], hasYear, wantYear)
+				}
+			}
+		})
+	}
+}
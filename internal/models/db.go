@@ -3,8 +3,12 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,7 +17,8 @@ import (
 
 type DB struct {
 	*sql.DB
-	mu sync.RWMutex
+	mu  sync.RWMutex
+	fts bool
 }
 
 type Request struct {
@@ -33,6 +38,93 @@ type Request struct {
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 	NotifiedAt    *time.Time `json:"notified_at"`
+	Episodes      []Episode  `json:"episodes,omitempty"`
+	Seasons       []int      `json:"seasons,omitempty"`
+	UserID        *int       `json:"user_id"`
+	Is4K          bool       `json:"is_4k"`
+	SeriesType    *string    `json:"series_type"`
+	// Percent of monitored episodes downloaded, for series
+	DownloadProgress int `json:"download_progress"`
+	// Ratings as the requester saw them when requesting
+	RatingsSnapshot json.RawMessage `json:"ratings_snapshot,omitempty"`
+	// Set on approval, ApprovedBy is nil for automatic approvals
+	ApprovedBy             *int    `json:"approved_by"`
+	ApprovedRootFolder     *string `json:"approved_root_folder"`
+	ApprovedQualityProfile *int    `json:"approved_quality_profile"`
+	ApprovedMonitor        *string `json:"approved_monitor"`
+	// Upgrades re-grab a title already in the library in better quality
+	IsUpgrade bool `json:"is_upgrade"`
+}
+
+// Episode identifies a single episode of a series request
+type Episode struct {
+	Season  int `json:"season"`
+	Episode int `json:"episode"`
+}
+
+type User struct {
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+	AutoApprove  bool      `json:"auto_approve"`
+	// SessionVersion is stored in session cookies, bumping it signs the user
+	// out everywhere
+	SessionVersion int `json:"-"`
+}
+
+// APIKey is an API key's metadata, the key itself is only shown once
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type QueuedNotification struct {
+	ID            int       `json:"id"`
+	Channel       string    `json:"channel"`
+	Event         string    `json:"event"`
+	Title         string    `json:"title"`
+	Message       string    `json:"message"`
+	URL           string    `json:"url"`
+	Image         string    `json:"image"`
+	Fields        string    `json:"fields"` // JSON list of name/value pairs
+	Attempts      int       `json:"attempts"`
+	LastError     *string   `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Comment is a message in a request's discussion thread
+type Comment struct {
+	ID        int       `json:"id"`
+	RequestID int       `json:"request_id"`
+	UserID    *int      `json:"user_id"`
+	Author    string    `json:"author"`
+	IsAdmin   bool      `json:"is_admin"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Issue is a problem reported with the media of a completed request
+type Issue struct {
+	ID          int        `json:"id"`
+	RequestID   int        `json:"request_id"`
+	UserID      *int       `json:"user_id"`
+	Reporter    string     `json:"reporter"`
+	IssueType   string     `json:"issue_type"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"` // open or resolved
+	ResolvedBy  *int       `json:"resolved_by"`
+	ResolvedAt  *time.Time `json:"resolved_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	// From the request, for listing
+	Title     string `json:"title"`
+	MediaType string `json:"media_type"`
 }
 
 type Activity struct {
@@ -42,6 +134,10 @@ type Activity struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// DefaultActivityRetentionDays is how long activity is kept when the
+// activity_retention_days setting is unset
+const DefaultActivityRetentionDays = 90
+
 func InitDB(dbPath string) (*DB, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
@@ -65,6 +161,17 @@ func InitDB(dbPath string) (*DB, error) {
 		return nil, err
 	}
 
+	if err := db.migrate(); err != nil {
+		return nil, err
+	}
+
+	// Full-text search needs the sqlite_fts5 build tag, fall back to LIKE without it
+	if err := db.createSearchTables(); err != nil {
+		slog.Warn("Full-text search unavailable, using basic search", "error", err)
+	} else {
+		db.fts = true
+	}
+
 	return db, nil
 }
 
@@ -98,6 +205,24 @@ func (db *DB) createTables() error {
 			details TEXT,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
+		`CREATE TABLE IF NOT EXISTS notification_queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel TEXT NOT NULL,
+			title TEXT NOT NULL,
+			message TEXT NOT NULL,
+			url TEXT,
+			attempts INTEGER DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'user',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_requests_status ON requests(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_requests_media_type ON requests(media_type)`,
 	}
@@ -108,9 +233,232 @@ func (db *DB) createTables() error {
 		}
 	}
 
+	// Columns added after the initial schema
+	columns := []struct{ table, column, definition string }{
+		{"requests", "episodes", "TEXT"},
+		{"settings", "source", "TEXT DEFAULT 'db'"},
+		{"requests", "seasons", "TEXT"},
+		{"requests", "user_id", "INTEGER REFERENCES users(id) ON DELETE SET NULL"},
+		{"requests", "is_4k", "INTEGER DEFAULT 0"},
+		{"requests", "series_type", "TEXT"},
+		{"requests", "download_progress", "INTEGER DEFAULT 0"},
+		{"notification_queue", "event", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := db.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_requests_user_id ON requests(user_id)"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrations are applied once each, in order, and recorded in
+// schema_migrations by their 1-based position. Only append to this list.
+var migrations = []string{
+	// 1: external ID lookups for duplicate checks and download webhooks
+	`CREATE INDEX IF NOT EXISTS idx_requests_tmdb_id ON requests(tmdb_id);
+	CREATE INDEX IF NOT EXISTS idx_requests_tvdb_id ON requests(tvdb_id);`,
+	// 2: users whose requests skip manual approval
+	`ALTER TABLE users ADD COLUMN auto_approve INTEGER DEFAULT 0`,
+	// 3: ratings shown to the requester when the request was made
+	`ALTER TABLE requests ADD COLUMN ratings_snapshot TEXT`,
+	// 4: API keys for scripts and integrations, stored as SHA-256 hashes
+	`CREATE TABLE api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP
+	)`,
+	// 5: accounts that sign in with Plex, the token is encrypted
+	`ALTER TABLE users ADD COLUMN plex_id INTEGER;
+	ALTER TABLE users ADD COLUMN plex_token TEXT;
+	CREATE UNIQUE INDEX idx_users_plex_id ON users(plex_id);`,
+	// 6: who approved a request and the arr settings they chose
+	`ALTER TABLE requests ADD COLUMN approved_by INTEGER;
+	ALTER TABLE requests ADD COLUMN approved_root_folder TEXT;
+	ALTER TABLE requests ADD COLUMN approved_quality_profile INTEGER;
+	ALTER TABLE requests ADD COLUMN approved_monitor TEXT;`,
+	// 7: activity log filtering by action and date
+	`CREATE INDEX IF NOT EXISTS idx_activity_log_action ON activity_log(action);
+	CREATE INDEX IF NOT EXISTS idx_activity_log_created_at ON activity_log(created_at);`,
+	// 8: requests to upgrade the quality of a title already in the library
+	`ALTER TABLE requests ADD COLUMN is_upgrade INTEGER DEFAULT 0`,
+	// 9: picture shown with a queued notification, e.g. the request's poster
+	`ALTER TABLE notification_queue ADD COLUMN image TEXT`,
+	// 10: details listed with a queued notification
+	`ALTER TABLE notification_queue ADD COLUMN fields TEXT`,
+	// 11: discussion threads on requests
+	`CREATE TABLE request_comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		request_id INTEGER NOT NULL REFERENCES requests(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		author TEXT NOT NULL,
+		is_admin INTEGER DEFAULT 0,
+		body TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_request_comments_request_id ON request_comments(request_id);`,
+	// 12: problems reported with completed media
+	`CREATE TABLE issues (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		request_id INTEGER NOT NULL REFERENCES requests(id) ON DELETE CASCADE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		reporter TEXT NOT NULL,
+		issue_type TEXT NOT NULL,
+		description TEXT,
+		status TEXT NOT NULL DEFAULT 'open',
+		resolved_by INTEGER,
+		resolved_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX idx_issues_status ON issues(status);
+	CREATE INDEX idx_issues_request_id ON issues(request_id);`,
+	// 13: invalidates sessions started before a password or role change
+	`ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0`,
+}
+
+// migrate applies pending migrations, each in its own transaction
+func (db *DB) migrate() error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	// Close before migrating; the pool only holds a single connection
+	rows.Close()
+
+	for i, migration := range migrations {
+		version := i + 1
+		if applied[version] {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migration); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Migration %d failed: %w", version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		slog.Info("Applied database migration", "version", version)
+	}
+
+	return nil
+}
+
+func (db *DB) createSearchTables() error {
+	var existing int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ('requests_fts', 'activity_fts')").Scan(&existing); err != nil {
+		return err
+	}
+
+	queries := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS requests_fts USING fts5(
+			title, requester_name, admin_notes,
+			content='requests', content_rowid='id'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS requests_fts_insert AFTER INSERT ON requests BEGIN
+			INSERT INTO requests_fts(rowid, title, requester_name, admin_notes) VALUES (new.id, new.title, new.requester_name, new.admin_notes);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS requests_fts_delete AFTER DELETE ON requests BEGIN
+			INSERT INTO requests_fts(requests_fts, rowid, title, requester_name, admin_notes) VALUES ('delete', old.id, old.title, old.requester_name, old.admin_notes);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS requests_fts_update AFTER UPDATE ON requests BEGIN
+			INSERT INTO requests_fts(requests_fts, rowid, title, requester_name, admin_notes) VALUES ('delete', old.id, old.title, old.requester_name, old.admin_notes);
+			INSERT INTO requests_fts(rowid, title, requester_name, admin_notes) VALUES (new.id, new.title, new.requester_name, new.admin_notes);
+		END`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS activity_fts USING fts5(
+			action, details,
+			content='activity_log', content_rowid='id'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS activity_fts_insert AFTER INSERT ON activity_log BEGIN
+			INSERT INTO activity_fts(rowid, action, details) VALUES (new.id, new.action, new.details);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS activity_fts_delete AFTER DELETE ON activity_log BEGIN
+			INSERT INTO activity_fts(activity_fts, rowid, action, details) VALUES ('delete', old.id, old.action, old.details);
+		END`,
+	}
+
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	// Index rows that existed before the search tables were created
+	if existing < 2 {
+		if _, err := db.Exec("INSERT INTO requests_fts(requests_fts) VALUES ('rebuild')"); err != nil {
+			return err
+		}
+		if _, err := db.Exec("INSERT INTO activity_fts(activity_fts) VALUES ('rebuild')"); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	// Close before altering; the pool only holds a single connection
+	rows.Close()
+
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // Settings functions
 func (db *DB) GetSetting(key string) string {
 	db.mu.RLock()
@@ -124,22 +472,66 @@ func (db *DB) GetSetting(key string) string {
 	return value
 }
 
+func (db *DB) GetSettingBool(key string, defaultValue bool) bool {
+	switch db.GetSetting(key) {
+	case "true", "1":
+		return true
+	case "false", "0":
+		return false
+	}
+	return defaultValue
+}
+
+func (db *DB) GetSettingInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(db.GetSetting(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 func (db *DB) SetSetting(key, value string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	_, err := db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+	_, err := db.Exec("INSERT OR REPLACE INTO settings (key, value, source) VALUES (?, ?, 'db')", key, value)
+	return err
+}
+
+func (db *DB) DeleteSetting(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("DELETE FROM settings WHERE key = ?", key)
 	return err
 }
 
-func (db *DB) SetSettingIfNotExists(key, value string) error {
+// SetSettingIfNotExists seeds a setting, recording where the value came from
+func (db *DB) SetSettingIfNotExists(key, value, source string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	_, err := db.Exec("INSERT OR IGNORE INTO settings (key, value) VALUES (?, ?)", key, value)
+	_, err := db.Exec("INSERT OR IGNORE INTO settings (key, value, source) VALUES (?, ?, ?)", key, value, source)
 	return err
 }
 
+// GetSettingWithSource returns a stored setting and where it came from
+func (db *DB) GetSettingWithSource(key string) (string, string, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var value string
+	var source *string
+	err := db.QueryRow("SELECT value, source FROM settings WHERE key = ?", key).Scan(&value, &source)
+	if err != nil {
+		return "", "", false
+	}
+	if source == nil {
+		return value, "db", true
+	}
+	return value, *source, true
+}
+
 func (db *DB) GetAllSettings() (map[string]string, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
@@ -162,14 +554,53 @@ func (db *DB) GetAllSettings() (map[string]string, error) {
 }
 
 // Request functions
+const requestColumns = "id, requester_name, requester_email, media_type, tmdb_id, tvdb_id, imdb_id, title, year, poster, status, admin_notes, arr_id, created_at, updated_at, notified_at, episodes, seasons, user_id, is_4k, series_type, download_progress, ratings_snapshot, approved_by, approved_root_folder, approved_quality_profile, approved_monitor, is_upgrade"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRequest(row rowScanner) (*Request, error) {
+	var r Request
+	var episodes, seasons, ratings *string
+	err := row.Scan(&r.ID, &r.RequesterName, &r.RequesterEmail, &r.MediaType, &r.TmdbID, &r.TvdbID, &r.ImdbID, &r.Title, &r.Year, &r.Poster, &r.Status, &r.AdminNotes, &r.ArrID, &r.CreatedAt, &r.UpdatedAt, &r.NotifiedAt, &episodes, &seasons, &r.UserID, &r.Is4K, &r.SeriesType, &r.DownloadProgress, &ratings, &r.ApprovedBy, &r.ApprovedRootFolder, &r.ApprovedQualityProfile, &r.ApprovedMonitor, &r.IsUpgrade)
+	if err != nil {
+		return nil, err
+	}
+	fromJSONColumn(episodes, &r.Episodes)
+	fromJSONColumn(seasons, &r.Seasons)
+	if ratings != nil && *ratings != "" {
+		r.RatingsSnapshot = json.RawMessage(*ratings)
+	}
+	return &r, nil
+}
+
+// toJSONColumn encodes a list for a nullable JSON column, storing NULL when empty
+func toJSONColumn(v interface{}, length int) *string {
+	if length == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(v)
+	s := string(b)
+	return &s
+}
+
+func fromJSONColumn(column *string, v interface{}) {
+	if column != nil && *column != "" {
+		json.Unmarshal([]byte(*column), v)
+	}
+}
+
 func (db *DB) CreateRequest(req *Request) (int64, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	result, err := db.Exec(`
-		INSERT INTO requests (requester_name, requester_email, media_type, tmdb_id, tvdb_id, imdb_id, title, year, poster, status)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending')
-	`, req.RequesterName, req.RequesterEmail, req.MediaType, req.TmdbID, req.TvdbID, req.ImdbID, req.Title, req.Year, req.Poster)
+		INSERT INTO requests (requester_name, requester_email, media_type, tmdb_id, tvdb_id, imdb_id, title, year, poster, episodes, seasons, user_id, is_4k, series_type, ratings_snapshot, is_upgrade, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'pending')
+	`, req.RequesterName, req.RequesterEmail, req.MediaType, req.TmdbID, req.TvdbID, req.ImdbID, req.Title, req.Year, req.Poster,
+		toJSONColumn(req.Episodes, len(req.Episodes)), toJSONColumn(req.Seasons, len(req.Seasons)), req.UserID, req.Is4K, req.SeriesType,
+		toJSONColumn(req.RatingsSnapshot, len(req.RatingsSnapshot)), req.IsUpgrade)
 	
 	if err != nil {
 		return 0, err
@@ -177,24 +608,118 @@ func (db *DB) CreateRequest(req *Request) (int64, error) {
 	return result.LastInsertId()
 }
 
-func (db *DB) GetRequests(status, mediaType string) ([]Request, error) {
+// ImportRequest inserts a request brought over from another tool, keeping
+// its status and creation time
+func (db *DB) ImportRequest(req *Request) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec(`
+		INSERT INTO requests (requester_name, requester_email, media_type, tmdb_id, tvdb_id, imdb_id, title, year, poster, seasons, user_id, is_4k, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.RequesterName, req.RequesterEmail, req.MediaType, req.TmdbID, req.TvdbID, req.ImdbID, req.Title, req.Year, req.Poster,
+		toJSONColumn(req.Seasons, len(req.Seasons)), req.UserID, req.Is4K, req.Status,
+		req.CreatedAt.UTC().Format("2006-01-02 15:04:05"), req.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// HasRequestAt reports whether a request for the title was made at exactly
+// createdAt, used to skip already imported requests
+func (db *DB) HasRequestAt(mediaType string, tmdbID, tvdbID *int, createdAt time.Time) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	column, id := "tmdb_id", tmdbID
+	if mediaType == "series" {
+		column, id = "tvdb_id", tvdbID
+	}
+	if id == nil {
+		return false, nil
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM requests WHERE media_type = ? AND "+column+" = ? AND created_at = ?",
+		mediaType, *id, createdAt.UTC().Format("2006-01-02 15:04:05")).Scan(&count)
+	return count > 0, err
+}
+
+// GetRequests returns a page of requests and the total matching count. A
+// limit of 0 returns every match.
+func (db *DB) GetRequests(status, mediaType string, limit, offset int) ([]Request, int, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	query := "SELECT id, requester_name, requester_email, media_type, tmdb_id, tvdb_id, imdb_id, title, year, poster, status, admin_notes, arr_id, created_at, updated_at, notified_at FROM requests WHERE 1=1"
+	where := " WHERE 1=1"
 	args := []interface{}{}
 
 	if status != "" {
-		query += " AND status = ?"
+		where += " AND status = ?"
 		args = append(args, status)
 	}
 	if mediaType != "" {
-		query += " AND media_type = ?"
+		where += " AND media_type = ?"
 		args = append(args, mediaType)
 	}
-	query += " ORDER BY created_at DESC"
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM requests"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + requestColumns + " FROM requests" + where + " ORDER BY created_at DESC, id DESC"
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
 
 	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var requests []Request
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		requests = append(requests, *r)
+	}
+	return requests, total, nil
+}
+
+func (db *DB) GetRequestsByUser(userID int) ([]Request, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query("SELECT "+requestColumns+" FROM requests WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []Request
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *r)
+	}
+	return requests, nil
+}
+
+// SearchRequests matches requests by title or requester name
+func (db *DB) SearchRequests(query string, limit int) ([]Request, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pattern := "%" + query + "%"
+	rows, err := db.Query("SELECT "+requestColumns+" FROM requests WHERE title LIKE ?1 COLLATE NOCASE OR requester_name LIKE ?1 COLLATE NOCASE ORDER BY created_at DESC LIMIT ?2", pattern, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -202,12 +727,11 @@ func (db *DB) GetRequests(status, mediaType string) ([]Request, error) {
 
 	var requests []Request
 	for rows.Next() {
-		var r Request
-		err := rows.Scan(&r.ID, &r.RequesterName, &r.RequesterEmail, &r.MediaType, &r.TmdbID, &r.TvdbID, &r.ImdbID, &r.Title, &r.Year, &r.Poster, &r.Status, &r.AdminNotes, &r.ArrID, &r.CreatedAt, &r.UpdatedAt, &r.NotifiedAt)
+		r, err := scanRequest(rows)
 		if err != nil {
 			return nil, err
 		}
-		requests = append(requests, r)
+		requests = append(requests, *r)
 	}
 	return requests, nil
 }
@@ -216,125 +740,959 @@ func (db *DB) GetRequest(id int) (*Request, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	var r Request
-	err := db.QueryRow(`
-		SELECT id, requester_name, requester_email, media_type, tmdb_id, tvdb_id, imdb_id, title, year, poster, status, admin_notes, arr_id, created_at, updated_at, notified_at 
-		FROM requests WHERE id = ?
-	`, id).Scan(&r.ID, &r.RequesterName, &r.RequesterEmail, &r.MediaType, &r.TmdbID, &r.TvdbID, &r.ImdbID, &r.Title, &r.Year, &r.Poster, &r.Status, &r.AdminNotes, &r.ArrID, &r.CreatedAt, &r.UpdatedAt, &r.NotifiedAt)
-	
+	r, err := scanRequest(db.QueryRow("SELECT "+requestColumns+" FROM requests WHERE id = ?", id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &r, nil
+	return r, nil
 }
 
+// GetApprovedRequests returns approved requests that haven't completed yet,
+// including ones already downloading
 func (db *DB) GetApprovedRequests() ([]Request, error) {
-	return db.GetRequests("approved", "")
-}
-
-func (db *DB) UpdateRequestStatus(id int, status, adminNotes string) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	_, err := db.Exec("UPDATE requests SET status = ?, admin_notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", status, adminNotes, id)
-	return err
-}
-
-func (db *DB) UpdateRequestArrID(id, arrID int) error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	_, err := db.Exec("UPDATE requests SET arr_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", arrID, id)
-	return err
-}
-
-func (db *DB) CheckDuplicateRequest(mediaType string, tmdbID, tvdbID *int) (bool, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	var count int
-	var err error
-
-	if mediaType == "series" && tvdbID != nil {
-		err = db.QueryRow("SELECT COUNT(*) FROM requests WHERE tvdb_id = ? AND media_type = 'series' AND status = 'pending'", *tvdbID).Scan(&count)
-	} else if mediaType == "movie" && tmdbID != nil {
-		err = db.QueryRow("SELECT COUNT(*) FROM requests WHERE tmdb_id = ? AND media_type = 'movie' AND status = 'pending'", *tmdbID).Scan(&count)
+	rows, err := db.Query("SELECT " + requestColumns + " FROM requests WHERE status IN ('approved', 'downloading') ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	if err != nil {
-		return false, err
+	var requests []Request
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *r)
 	}
-	return count > 0, nil
+	return requests, nil
 }
 
-func (db *DB) GetRequestedIDs(mediaType string) (map[int]bool, error) {
+// GetApprovedRequestsByExternalID finds approved or downloading requests for
+// a series by tvdb id or a movie by tmdb id
+func (db *DB) GetApprovedRequestsByExternalID(mediaType string, externalID int) ([]Request, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	var query string
+	column := "tmdb_id"
 	if mediaType == "series" {
-		query = "SELECT tvdb_id FROM requests WHERE media_type = 'series' AND status IN ('pending', 'approved') AND tvdb_id IS NOT NULL"
-	} else {
-		query = "SELECT tmdb_id FROM requests WHERE media_type = 'movie' AND status IN ('pending', 'approved') AND tmdb_id IS NOT NULL"
+		column = "tvdb_id"
 	}
 
-	rows, err := db.Query(query)
+	rows, err := db.Query("SELECT "+requestColumns+" FROM requests WHERE status IN ('approved', 'downloading') AND media_type = ? AND "+column+" = ?", mediaType, externalID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	ids := make(map[int]bool)
+	var requests []Request
 	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
+		r, err := scanRequest(rows)
+		if err != nil {
 			return nil, err
 		}
-		ids[id] = true
+		requests = append(requests, *r)
 	}
-	return ids, nil
+	return requests, nil
 }
 
-// Stats
-func (db *DB) GetStats() (map[string]int, error) {
+func (db *DB) CountActiveDownloads() (int, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	stats := map[string]int{
-		"total":     0,
-		"pending":   0,
-		"approved":  0,
-		"rejected":  0,
-		"completed": 0,
-	}
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM requests WHERE status IN ('approved', 'downloading')").Scan(&count)
+	return count, err
+}
 
-	rows, err := db.Query(`
-		SELECT 
-			COUNT(*) as total,
-			SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) as pending,
-			SUM(CASE WHEN status = 'approved' THEN 1 ELSE 0 END) as approved,
-			SUM(CASE WHEN status = 'rejected' THEN 1 ELSE 0 END) as rejected,
-			SUM(CASE WHEN status = 'completed' THEN 1 ELSE 0 END) as completed
-		FROM requests
-	`)
-	if err != nil {
+func (db *DB) UpdateRequestStatus(id int, status, adminNotes string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET status = ?, admin_notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", status, adminNotes, id)
+	return err
+}
+
+// MarkRequestDownloading moves an approved request to downloading once the
+// arr has grabbed it
+func (db *DB) MarkRequestDownloading(id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET status = 'downloading', updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'approved'", id)
+	return err
+}
+
+func (db *DB) MarkRequestNotified(id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET notified_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+func (db *DB) UpdateRequest4K(id int, is4K bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET is_4k = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", is4K, id)
+	return err
+}
+
+func (db *DB) UpdateRequestSeriesType(id int, seriesType string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET series_type = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", seriesType, id)
+	return err
+}
+
+func (db *DB) UpdateRequestProgress(id, progress int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET download_progress = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", progress, id)
+	return err
+}
+
+func (db *DB) UpdateRequestArrID(id, arrID int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE requests SET arr_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", arrID, id)
+	return err
+}
+
+// UpdateRequestApproval records who approved a request, approvedBy 0 for
+// automatic approvals, and the root folder, quality profile and (for
+// series) monitor option it was added with
+func (db *DB) UpdateRequestApproval(id, approvedBy int, rootFolder string, qualityProfileID int, monitor string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var approver *int
+	if approvedBy > 0 {
+		approver = &approvedBy
+	}
+	var folder, monitorOption *string
+	if rootFolder != "" {
+		folder = &rootFolder
+	}
+	if monitor != "" {
+		monitorOption = &monitor
+	}
+
+	_, err := db.Exec("UPDATE requests SET approved_by = ?, approved_root_folder = ?, approved_quality_profile = ?, approved_monitor = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		approver, folder, qualityProfileID, monitorOption, id)
+	return err
+}
+
+func (db *DB) DeleteRequest(id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Foreign keys aren't enforced, remove the request's comments and issues ourselves
+	for _, table := range []string{"request_comments", "issues"} {
+		if _, err := db.Exec("DELETE FROM "+table+" WHERE request_id = ?", id); err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec("DELETE FROM requests WHERE id = ?", id)
+	return err
+}
+
+// FindDuplicateRequest returns the status of an open request for the same
+// title, or "" when there is none. Pending requests always count; approved
+// and downloading ones only for the same quality (a 4K copy can be requested
+// alongside a regular one), and completed ones only when includeCompleted.
+// Upgrade requests are left out, the title is already in the library.
+//
+// A series request for specific seasons is only blocked by pending requests
+// and by requests sharing one of its seasons. Requests for the whole series
+// share every season while approved or downloading; once completed they
+// don't block, the caller has already found the seasons missing from Sonarr.
+func (db *DB) FindDuplicateRequest(mediaType string, tmdbID, tvdbID *int, seasons []int, is4K, includeCompleted bool) (string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	column, id := "tmdb_id", tmdbID
+	if mediaType == "series" {
+		column, id = "tvdb_id", tvdbID
+	}
+	if id == nil {
+		return "", nil
+	}
+
+	statuses := "'approved', 'downloading'"
+	if includeCompleted {
+		statuses += ", 'completed'"
+	}
+
+	rows, err := db.Query(`
+		SELECT status, seasons FROM requests
+		WHERE media_type = ? AND `+column+` = ?
+		AND is_upgrade = 0
+		AND (status = 'pending' OR (status IN (`+statuses+`) AND is_4k = ?))
+		ORDER BY CASE status WHEN 'pending' THEN 0 WHEN 'approved' THEN 1 WHEN 'downloading' THEN 2 ELSE 3 END
+	`, mediaType, *id, is4K)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var seasonsJSON *string
+		if err := rows.Scan(&status, &seasonsJSON); err != nil {
+			return "", err
+		}
+		if mediaType != "series" || len(seasons) == 0 || status == "pending" {
+			return status, nil
+		}
+
+		var existing []int
+		fromJSONColumn(seasonsJSON, &existing)
+		if len(existing) == 0 {
+			if status != "completed" {
+				return status, nil
+			}
+			continue
+		}
+		for _, season := range existing {
+			for _, requested := range seasons {
+				if season == requested {
+					return status, nil
+				}
+			}
+		}
+	}
+	return "", rows.Err()
+}
+
+// HasOpenUpgrade reports whether an upgrade of the title is pending or
+// underway for the same quality
+func (db *DB) HasOpenUpgrade(mediaType string, tmdbID, tvdbID *int, is4K bool) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	column, id := "tmdb_id", tmdbID
+	if mediaType == "series" {
+		column, id = "tvdb_id", tvdbID
+	}
+	if id == nil {
+		return false, nil
+	}
+
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM requests
+		WHERE media_type = ? AND `+column+` = ? AND is_4k = ?
+		AND is_upgrade = 1 AND status IN ('pending', 'approved', 'downloading')
+	`, mediaType, *id, is4K).Scan(&count)
+	return count > 0, err
+}
+
+// ArrIDShared reports whether a request other than id points at the same
+// Sonarr series or Radarr movie, such as the seasons added to a series
+// already in Sonarr or an upgrade of the original request
+func (db *DB) ArrIDShared(id int, mediaType string, arrID int, is4K bool) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Series all live in the one Sonarr, movies in Radarr or the 4K Radarr
+	query := "SELECT COUNT(*) FROM requests WHERE id != ? AND media_type = ? AND arr_id = ?"
+	args := []interface{}{id, mediaType, arrID}
+	if mediaType == "movie" {
+		query += " AND is_4k = ?"
+		args = append(args, is4K)
+	}
+
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count > 0, err
+}
+
+// UserRequestedTitle reports whether the user ever requested the title,
+// whatever became of the request
+func (db *DB) UserRequestedTitle(userID int, mediaType string, tmdbID, tvdbID *int) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	column, id := "tmdb_id", tmdbID
+	if mediaType == "series" {
+		column, id = "tvdb_id", tvdbID
+	}
+	if id == nil {
+		return false, nil
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM requests WHERE user_id = ? AND media_type = ? AND "+column+" = ?", userID, mediaType, *id).Scan(&count)
+	return count > 0, err
+}
+
+// CountRequestsSince counts a requester's requests of a media type since the
+// given time. Rejected requests don't count.
+func (db *DB) CountRequestsSince(userOrName string, mediaType string, since time.Time) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM requests
+		WHERE requester_name = ? COLLATE NOCASE AND media_type = ? AND status != 'rejected' AND created_at >= ?
+	`, userOrName, mediaType, since.UTC().Format("2006-01-02 15:04:05")).Scan(&count)
+	return count, err
+}
+
+func (db *DB) GetRequestedIDs(mediaType string) (map[int]bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var query string
+	if mediaType == "series" {
+		query = "SELECT tvdb_id FROM requests WHERE media_type = 'series' AND status IN ('pending', 'approved', 'downloading') AND tvdb_id IS NOT NULL"
+	} else {
+		query = "SELECT tmdb_id FROM requests WHERE media_type = 'movie' AND status IN ('pending', 'approved', 'downloading') AND tmdb_id IS NOT NULL"
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// Stats
+func (db *DB) GetStats() (map[string]int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := map[string]int{
+		"total":       0,
+		"pending":     0,
+		"approved":    0,
+		"downloading": 0,
+		"rejected":    0,
+		"completed":   0,
+	}
+
+	rows, err := db.Query(`
+		SELECT 
+			COUNT(*) as total,
+			SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) as pending,
+			SUM(CASE WHEN status = 'approved' THEN 1 ELSE 0 END) as approved,
+			SUM(CASE WHEN status = 'downloading' THEN 1 ELSE 0 END) as downloading,
+			SUM(CASE WHEN status = 'rejected' THEN 1 ELSE 0 END) as rejected,
+			SUM(CASE WHEN status = 'completed' THEN 1 ELSE 0 END) as completed
+		FROM requests
+	`)
+	if err != nil {
 		return stats, err
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		var total, pending, approved, rejected, completed int
-		rows.Scan(&total, &pending, &approved, &rejected, &completed)
-		stats["total"] = total
-		stats["pending"] = pending
-		stats["approved"] = approved
-		stats["rejected"] = rejected
-		stats["completed"] = completed
+	if rows.Next() {
+		var total, pending, approved, downloading, rejected, completed int
+		rows.Scan(&total, &pending, &approved, &downloading, &rejected, &completed)
+		stats["total"] = total
+		stats["pending"] = pending
+		stats["approved"] = approved
+		stats["downloading"] = downloading
+		stats["rejected"] = rejected
+		stats["completed"] = completed
+	}
+	return stats, nil
+}
+
+// TopRequested is a title and how many times it was requested
+type TopRequested struct {
+	MediaType string  `json:"media_type"`
+	TmdbID    *int    `json:"tmdb_id"`
+	TvdbID    *int    `json:"tvdb_id"`
+	Title     string  `json:"title"`
+	Year      *int    `json:"year"`
+	Poster    *string `json:"poster"`
+	Requests  int     `json:"requests"`
+}
+
+// GetTopRequested returns the most requested titles, grouping series by
+// tvdb id and movies by tmdb id. An empty mediaType includes both.
+func (db *DB) GetTopRequested(mediaType string, limit int) ([]TopRequested, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	where := " WHERE CASE WHEN media_type = 'series' THEN tvdb_id ELSE tmdb_id END IS NOT NULL"
+	args := []interface{}{}
+	if mediaType != "" {
+		where += " AND media_type = ?"
+		args = append(args, mediaType)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`
+		SELECT media_type, MAX(tmdb_id), MAX(tvdb_id), MAX(title), MAX(year), MAX(poster), COUNT(*) AS requests
+		FROM requests`+where+`
+		GROUP BY media_type, CASE WHEN media_type = 'series' THEN tvdb_id ELSE tmdb_id END
+		ORDER BY requests DESC, MAX(created_at) DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []TopRequested
+	for rows.Next() {
+		var t TopRequested
+		if err := rows.Scan(&t.MediaType, &t.TmdbID, &t.TvdbID, &t.Title, &t.Year, &t.Poster, &t.Requests); err != nil {
+			return nil, err
+		}
+		top = append(top, t)
+	}
+	return top, nil
+}
+
+// RequesterStats are the request counts of one requester, an account when
+// UserID is set
+type RequesterStats struct {
+	RequesterName string `json:"requester_name"`
+	UserID        *int   `json:"user_id"`
+	Total         int    `json:"total"`
+	Pending       int    `json:"pending"`
+	Approved      int    `json:"approved"`
+	Downloading   int    `json:"downloading"`
+	Rejected      int    `json:"rejected"`
+	Completed     int    `json:"completed"`
+}
+
+// GetRequesterStats counts requests per requester, grouping by account and
+// otherwise by name, for requests made in [since, until). Zero times leave
+// the range open.
+func (db *DB) GetRequesterStats(since, until time.Time) ([]RequesterStats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	where := " WHERE 1=1"
+	args := []interface{}{}
+	if !since.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !until.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, until.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			MAX(requester_name),
+			user_id,
+			COUNT(*) AS total,
+			SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN status = 'approved' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN status = 'downloading' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN status = 'rejected' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN status = 'completed' THEN 1 ELSE 0 END)
+		FROM requests`+where+`
+		GROUP BY user_id, CASE WHEN user_id IS NULL THEN lower(requester_name) END
+		ORDER BY total DESC, MAX(requester_name)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []RequesterStats
+	for rows.Next() {
+		var s RequesterStats
+		if err := rows.Scan(&s.RequesterName, &s.UserID, &s.Total, &s.Pending, &s.Approved, &s.Downloading, &s.Rejected, &s.Completed); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// DailyCount is the number of requests made on a day (YYYY-MM-DD, UTC)
+type DailyCount struct {
+	Date     string `json:"date"`
+	Requests int    `json:"requests"`
+}
+
+// GetRequestsPerDay counts requests for each of the last days days,
+// including today and days without requests
+func (db *DB) GetRequestsPerDay(days int) ([]DailyCount, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+	rows, err := db.Query(`
+		SELECT date(created_at) AS day, COUNT(*) FROM requests
+		WHERE created_at >= ?
+		GROUP BY day
+	`, start.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		counts[day] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	series := make([]DailyCount, days)
+	for i := range series {
+		day := start.AddDate(0, 0, i).Format("2006-01-02")
+		series[i] = DailyCount{Date: day, Requests: counts[day]}
+	}
+	return series, nil
+}
+
+// Comments
+func (db *DB) AddComment(requestID int, userID *int, author string, isAdmin bool, body string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("INSERT INTO request_comments (request_id, user_id, author, is_admin, body) VALUES (?, ?, ?, ?, ?)",
+		requestID, userID, author, isAdmin, body)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetComments returns a request's comments, oldest first
+func (db *DB) GetComments(requestID int) ([]Comment, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query(`
+		SELECT id, request_id, user_id, author, is_admin, body, created_at
+		FROM request_comments WHERE request_id = ?
+		ORDER BY created_at, id
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.RequestID, &c.UserID, &c.Author, &c.IsAdmin, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// Issues
+const issueColumns = "i.id, i.request_id, i.user_id, i.reporter, i.issue_type, COALESCE(i.description, ''), i.status, i.resolved_by, i.resolved_at, i.created_at, r.title, r.media_type"
+
+func scanIssue(row rowScanner) (*Issue, error) {
+	var i Issue
+	err := row.Scan(&i.ID, &i.RequestID, &i.UserID, &i.Reporter, &i.IssueType, &i.Description, &i.Status, &i.ResolvedBy, &i.ResolvedAt, &i.CreatedAt, &i.Title, &i.MediaType)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (db *DB) CreateIssue(issue *Issue) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("INSERT INTO issues (request_id, user_id, reporter, issue_type, description) VALUES (?, ?, ?, ?, ?)",
+		issue.RequestID, issue.UserID, issue.Reporter, issue.IssueType, issue.Description)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetIssues lists issues with the given status, or all of them when status
+// is "", newest first
+func (db *DB) GetIssues(status string) ([]Issue, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	query := "SELECT " + issueColumns + " FROM issues i JOIN requests r ON r.id = i.request_id"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE i.status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY i.created_at DESC, i.id DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issues := []Issue{}
+	for rows.Next() {
+		issue, err := scanIssue(rows)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, *issue)
+	}
+	return issues, rows.Err()
+}
+
+func (db *DB) GetIssue(id int) (*Issue, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	issue, err := scanIssue(db.QueryRow("SELECT "+issueColumns+" FROM issues i JOIN requests r ON r.id = i.request_id WHERE i.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return issue, err
+}
+
+// HasOpenIssue reports whether a request already has an open issue of the
+// given type
+func (db *DB) HasOpenIssue(requestID int, issueType string) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM issues WHERE request_id = ? AND issue_type = ? AND status = 'open'", requestID, issueType).Scan(&count)
+	return count > 0, err
+}
+
+func (db *DB) ResolveIssue(id, resolvedBy int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE issues SET status = 'resolved', resolved_by = ?, resolved_at = CURRENT_TIMESTAMP WHERE id = ?", resolvedBy, id)
+	return err
+}
+
+// Users
+const userColumns = "id, username, password_hash, role, created_at, auto_approve, session_version"
+
+func (db *DB) CreateUser(username, passwordHash, role string, autoApprove bool) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("INSERT INTO users (username, password_hash, role, auto_approve) VALUES (?, ?, ?, ?)", username, passwordHash, role, autoApprove)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *DB) GetUsers() ([]User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.AutoApprove, &u.SessionVersion); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
 	}
-	return stats, nil
+	return users, nil
+}
+
+func (db *DB) GetUser(id int) (*User, error) {
+	return db.getUser("id = ?", id)
+}
+
+func (db *DB) GetUserByUsername(username string) (*User, error) {
+	return db.getUser("username = ?", username)
+}
+
+func (db *DB) getUser(where string, arg interface{}) (*User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var u User
+	err := db.QueryRow("SELECT "+userColumns+" FROM users WHERE "+where, arg).Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.AutoApprove, &u.SessionVersion)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (db *DB) GetUserByPlexID(plexID int) (*User, error) {
+	return db.getUser("plex_id = ?", plexID)
+}
+
+// CreatePlexUser creates a user who signs in with Plex and has no password
+func (db *DB) CreatePlexUser(username string, plexID int, plexToken string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("INSERT INTO users (username, password_hash, role, plex_id, plex_token) VALUES (?, '', 'user', ?, ?)", username, plexID, plexToken)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetPlexTokens returns the encrypted Plex token of each user signed in
+// with Plex, by user id
+func (db *DB) GetPlexTokens() (map[int]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query("SELECT id, plex_token FROM users WHERE plex_token IS NOT NULL AND plex_token != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			return nil, err
+		}
+		tokens[id] = token
+	}
+	return tokens, rows.Err()
+}
+
+func (db *DB) UpdateUserPlexToken(id int, plexToken string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE users SET plex_token = ? WHERE id = ?", plexToken, id)
+	return err
+}
+
+// UpdateUserPassword sets a new password and ends the user's sessions
+func (db *DB) UpdateUserPassword(id int, passwordHash string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE users SET password_hash = ?, session_version = session_version + 1 WHERE id = ?", passwordHash, id)
+	return err
+}
+
+// UpdateUserRole changes a user's role and ends the user's sessions
+func (db *DB) UpdateUserRole(id int, role string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE users SET role = ?, session_version = session_version + 1 WHERE id = ?", role, id)
+	return err
+}
+
+func (db *DB) UpdateUserAutoApprove(id int, autoApprove bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE users SET auto_approve = ? WHERE id = ?", autoApprove, id)
+	return err
+}
+
+func (db *DB) DeleteUser(id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.Exec("DELETE FROM api_keys WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+	return err
+}
+
+// API keys
+func (db *DB) CreateAPIKey(name, keyHash string, userID int) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("INSERT INTO api_keys (name, key_hash, user_id) VALUES (?, ?, ?)", name, keyHash, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (db *DB) GetAPIKeys() ([]APIKey, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query(`
+		SELECT k.id, k.name, k.user_id, u.username, k.created_at, k.last_used_at
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		ORDER BY k.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.UserID, &k.Username, &k.CreatedAt, &k.LastUsedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// GetUserByAPIKey returns the user owning the key with the given hash and
+// records that the key was used
+func (db *DB) GetUserByAPIKey(keyHash string) (*User, error) {
+	user, err := db.getUser("id = (SELECT user_id FROM api_keys WHERE key_hash = ?)", keyHash)
+	if err != nil || user == nil {
+		return user, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err = db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE key_hash = ?", keyHash)
+	return user, err
+}
+
+func (db *DB) DeleteAPIKey(id int) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("DELETE FROM api_keys WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (db *DB) CountUsers(role string) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	query := "SELECT COUNT(*) FROM users"
+	args := []interface{}{}
+	if role != "" {
+		query += " WHERE role = ?"
+		args = append(args, role)
+	}
+
+	var count int
+	err := db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// Notification queue
+func (db *DB) EnqueueNotification(channel, event, title, message, url, image, fields, lastError string, nextAttempt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec(`
+		INSERT INTO notification_queue (channel, event, title, message, url, image, fields, attempts, last_error, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+	`, channel, event, title, message, url, image, fields, lastError, nextAttempt.UTC().Truncate(time.Second))
+	return err
+}
+
+func (db *DB) GetDueNotifications(now time.Time) ([]QueuedNotification, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query(`
+		SELECT id, channel, COALESCE(event, ''), title, message, COALESCE(url, ''), COALESCE(image, ''), COALESCE(fields, ''), attempts, last_error, next_attempt_at, created_at
+		FROM notification_queue WHERE next_attempt_at <= ? ORDER BY next_attempt_at
+	`, now.UTC().Truncate(time.Second))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []QueuedNotification
+	for rows.Next() {
+		var n QueuedNotification
+		if err := rows.Scan(&n.ID, &n.Channel, &n.Event, &n.Title, &n.Message, &n.URL, &n.Image, &n.Fields, &n.Attempts, &n.LastError, &n.NextAttemptAt, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	return items, nil
+}
+
+// GetQueuedNotificationChannels returns the channels with notifications
+// waiting to be retried
+func (db *DB) GetQueuedNotificationChannels() (map[string]bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rows, err := db.Query("SELECT DISTINCT channel FROM notification_queue")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := make(map[string]bool)
+	for rows.Next() {
+		var channel string
+		if err := rows.Scan(&channel); err != nil {
+			return nil, err
+		}
+		channels[channel] = true
+	}
+	return channels, nil
+}
+
+func (db *DB) UpdateNotificationAttempt(id, attempts int, lastError string, nextAttempt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("UPDATE notification_queue SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?", attempts, lastError, nextAttempt.UTC().Truncate(time.Second), id)
+	return err
+}
+
+func (db *DB) DeleteNotification(id int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	_, err := db.Exec("DELETE FROM notification_queue WHERE id = ?", id)
+	return err
 }
 
 // Activity log
@@ -353,13 +1711,38 @@ func (db *DB) LogActivity(action string, details map[string]interface{}) error {
 	return err
 }
 
-func (db *DB) GetActivity(limit int) ([]Activity, error) {
+// GetActivity returns a page of activity and the total matching count,
+// optionally limited to one action and to entries in [since, until). Zero
+// times leave the range open.
+func (db *DB) GetActivity(action string, since, until time.Time, limit, offset int) ([]Activity, int, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
-	rows, err := db.Query("SELECT id, action, details, created_at FROM activity_log ORDER BY created_at DESC LIMIT ?", limit)
+	where := " WHERE 1=1"
+	args := []interface{}{}
+
+	if action != "" {
+		where += " AND action = ?"
+		args = append(args, action)
+	}
+	if !since.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if !until.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, until.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM activity_log"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, limit, offset)
+	rows, err := db.Query("SELECT id, action, details, created_at FROM activity_log"+where+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?", args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -367,9 +1750,94 @@ func (db *DB) GetActivity(limit int) ([]Activity, error) {
 	for rows.Next() {
 		var a Activity
 		if err := rows.Scan(&a.ID, &a.Action, &a.Details, &a.CreatedAt); err != nil {
-			return nil, err
+			return nil, 0, err
+		}
+		activities = append(activities, a)
+	}
+	return activities, total, nil
+}
+
+// PruneActivity deletes activity logged before the given time, returning
+// how many entries were removed
+func (db *DB) PruneActivity(before time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("DELETE FROM activity_log WHERE created_at < ?", before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClearActivity deletes the whole activity log, returning how many entries
+// were removed
+func (db *DB) ClearActivity() (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result, err := db.Exec("DELETE FROM activity_log")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Full-text search
+func (db *DB) FullTextSearch(query string, limit int) ([]Request, []Activity, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var requestQuery, activityQuery string
+	var arg string
+	if db.fts {
+		requestQuery = "SELECT " + requestColumns + " FROM requests WHERE id IN (SELECT rowid FROM requests_fts WHERE requests_fts MATCH ?1 ORDER BY rank LIMIT ?2) ORDER BY created_at DESC"
+		activityQuery = "SELECT id, action, details, created_at FROM activity_log WHERE id IN (SELECT rowid FROM activity_fts WHERE activity_fts MATCH ?1 ORDER BY rank LIMIT ?2) ORDER BY created_at DESC"
+		arg = ftsQuery(query)
+	} else {
+		requestQuery = "SELECT " + requestColumns + " FROM requests WHERE title LIKE ?1 OR requester_name LIKE ?1 OR admin_notes LIKE ?1 ORDER BY created_at DESC LIMIT ?2"
+		activityQuery = "SELECT id, action, details, created_at FROM activity_log WHERE action LIKE ?1 OR details LIKE ?1 ORDER BY created_at DESC LIMIT ?2"
+		arg = "%" + query + "%"
+	}
+
+	rows, err := db.Query(requestQuery, arg, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	var requests []Request
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		requests = append(requests, *r)
+	}
+	rows.Close()
+
+	rows, err = db.Query(activityQuery, arg, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var activities []Activity
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.ID, &a.Action, &a.Details, &a.CreatedAt); err != nil {
+			return nil, nil, err
 		}
 		activities = append(activities, a)
 	}
-	return activities, nil
+	return requests, activities, nil
+}
+
+// ftsQuery turns free text into an FTS5 query that prefix-matches every
+// term, quoting them so user input can't break the query syntax.
+func ftsQuery(text string) string {
+	terms := strings.Fields(text)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
 }
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxArrErrorBody caps how much of an arr error reply is kept
+const maxArrErrorBody = 500
+
+// Errors an ArrError matches with errors.Is, by status code
+var (
+	ErrArrUnauthorized = errors.New("arr rejected the API key")
+	ErrArrForbidden    = errors.New("arr denied access")
+	ErrArrNotFound     = errors.New("arr resource not found")
+)
+
+// ArrError is a non-2xx reply from Sonarr or Radarr, Body holds the reason
+// the arr gave, if any
+type ArrError struct {
+	Service    string
+	StatusCode int
+	Body       string
+}
+
+func (e *ArrError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s returned %d", e.Service, e.StatusCode)
+	}
+	return fmt.Sprintf("%s returned %d: %s", e.Service, e.StatusCode, e.Body)
+}
+
+func (e *ArrError) Is(target error) bool {
+	switch target {
+	case ErrArrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrArrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrArrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// newArrError builds an ArrError from a failed response, reading the
+// validation messages or message the arr replied with
+func newArrError(service string, resp *http.Response) *ArrError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return &ArrError{
+		Service:    service,
+		StatusCode: resp.StatusCode,
+		Body:       arrErrorMessage(body),
+	}
+}
+
+func arrErrorMessage(body []byte) string {
+	// Validation failures are a list of {propertyName, errorMessage}
+	var validation []struct {
+		ErrorMessage string `json:"errorMessage"`
+	}
+	if json.Unmarshal(body, &validation) == nil {
+		var messages []string
+		for _, v := range validation {
+			if v.ErrorMessage != "" {
+				messages = append(messages, v.ErrorMessage)
+			}
+		}
+		if len(messages) > 0 {
+			return truncateArrError(strings.Join(messages, "; "))
+		}
+	}
+
+	var reply struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &reply) == nil && reply.Message != "" {
+		return truncateArrError(reply.Message)
+	}
+
+	return truncateArrError(strings.TrimSpace(string(body)))
+}
+
+func truncateArrError(message string) string {
+	if len(message) <= maxArrErrorBody {
+		return message
+	}
+	return strings.ToValidUTF8(message[:maxArrErrorBody], "") + "..."
+}
+
+// NormalizeArrURL cleans up a Sonarr or Radarr address as users tend to
+// paste it: http:// is assumed when there's no scheme, and trailing slashes
+// and an /api or /api/v3 suffix are dropped. "" stays "".
+func NormalizeArrURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("not a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("expected an http or https URL")
+	}
+	if u.Hostname() == "" || strings.ContainsAny(u.Host, " ") {
+		return "", fmt.Errorf("missing host")
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	for _, suffix := range []string{"/api/v3", "/api/v1", "/api"} {
+		if strings.HasSuffix(strings.ToLower(path), suffix) {
+			path = strings.TrimRight(path[:len(path)-len(suffix)], "/")
+			break
+		}
+	}
+
+	u.Path, u.RawPath = path, ""
+	u.RawQuery, u.Fragment = "", ""
+	return u.String(), nil
+}
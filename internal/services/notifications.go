@@ -2,13 +2,77 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/smtp"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/IcarusCore/Requestarr/internal/models"
 )
 
+const (
+	notifyRetryBase   = time.Minute
+	notifyMaxAttempts = 8
+
+	// Immediate retries before a delivery is handed to the queue
+	sendAttempts    = 3
+	sendBackoffBase = 500 * time.Millisecond
+
+	telegramMaxMessageLength = 4096
+
+	// ntfy topic names are at most 64 characters
+	ntfyMaxTopicLength = 64
+)
+
+// Notification events, each can be toggled with a notify_on_<event> setting
+const (
+	EventRequest  = "request"
+	EventApprove  = "approve"
+	EventComplete = "complete"
+	EventReject   = "reject"
+
+	EventFirstEpisode = "first_episode"
+	EventComment      = "comment"
+	EventIssue        = "issue"
+)
+
+// Event names passed to webhook receivers
+const (
+	NotifyRequestCreated   = "request_created"
+	NotifyRequestApproved  = "request_approved"
+	NotifyRequestRejected  = "request_rejected"
+	NotifyRequestCompleted = "request_completed"
+	NotifyFirstEpisode     = "first_episode_available"
+	NotifyRequestComment   = "request_comment"
+	NotifyIssueReported    = "issue_reported"
+	NotifyIssueResolved    = "issue_resolved"
+	NotifyTest             = "test"
+)
+
+// NotificationChannels are the channels notifications can be sent through
+var NotificationChannels = []string{"discord", "ntfy", "telegram", "email", "webhook"}
+
+var errChannelNotConfigured = errors.New("notification channel not configured")
+
+// NotificationField is a labelled detail sent along with a notification,
+// shown as an embed field on Discord and included in webhook payloads
+type NotificationField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 type NotificationService struct {
 	db     *models.DB
 	client *http.Client
@@ -23,22 +87,412 @@ func NewNotificationService(db *models.DB) *NotificationService {
 	}
 }
 
-func (s *NotificationService) Send(title, message, url string) {
-	// Discord webhook
-	discordWebhook := s.db.GetSetting("discord_webhook")
-	if discordWebhook != "" {
-		s.sendDiscord(discordWebhook, title, message, url)
+// Enabled reports whether notifications are on for an event. Unset toggles
+// default to enabled.
+func (s *NotificationService) Enabled(event string) bool {
+	return s.db.GetSettingBool("notify_on_"+event, true)
+}
+
+// ChannelStatus reports each configured channel as "ok", or "failing" when
+// notifications to it are queued for retry
+func (s *NotificationService) ChannelStatus() (map[string]string, error) {
+	failing, err := s.db.GetQueuedNotificationChannels()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make(map[string]string)
+	for _, channel := range s.configuredChannels() {
+		status[channel] = "ok"
+		if failing[channel] {
+			status[channel] = "failing"
+		}
+	}
+	return status, nil
+}
+
+// SendEvent delivers to every configured channel concurrently, tagging
+// webhook payloads with the event name. url is where the notification links
+// to and image a picture to show with it, both optional, as are the fields.
+// Each channel gets a few quick retries, then failures are queued for
+// ProcessQueue and returned together.
+func (s *NotificationService) SendEvent(ctx context.Context, event, title, message, url, image string, fields ...NotificationField) error {
+	channels := s.configuredChannels()
+	errs := make([]error, len(channels))
+
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		wg.Add(1)
+		go func(i int, channel string) {
+			defer wg.Done()
+
+			err := withRetry(ctx, sendAttempts, sendBackoffBase, func() error {
+				return s.deliver(ctx, channel, event, title, message, url, image, fields)
+			})
+			if err != nil {
+				s.db.EnqueueNotification(channel, event, title, message, url, image, encodeFields(fields), err.Error(), time.Now().Add(notifyRetryBase))
+				errs[i] = fmt.Errorf("%s: %w", channel, err)
+			}
+		}(i, channel)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// SendRequestEvent is SendEvent for a notification about a request, linking
+// to the request, showing its poster and listing its details as fields
+func (s *NotificationService) SendRequestEvent(ctx context.Context, req models.Request, event, title, message string) error {
+	return s.SendEvent(ctx, event, title, message, s.RequestURL(req.ID), posterURL(req), requestFields(req)...)
+}
+
+// requestFields lists who asked for a request and what it is
+func requestFields(req models.Request) []NotificationField {
+	mediaType := "Movie"
+	if req.MediaType == "series" {
+		mediaType = "Series"
+	}
+	if req.Is4K {
+		mediaType += " (4K)"
+	}
+	if req.IsUpgrade {
+		mediaType += " upgrade"
+	}
+
+	fields := []NotificationField{
+		{Name: "Requester", Value: req.RequesterName},
+		{Name: "Type", Value: mediaType},
+	}
+	if req.Year != nil && *req.Year > 0 {
+		fields = append(fields, NotificationField{Name: "Year", Value: strconv.Itoa(*req.Year)})
+	}
+	if rating := snapshotRating(req.RatingsSnapshot); rating != "" {
+		fields = append(fields, NotificationField{Name: "Rating", Value: rating})
+	}
+	return fields
+}
+
+// snapshotRating summarizes the ratings a requester saw, "" when there are
+// none
+func snapshotRating(snapshot json.RawMessage) string {
+	var ratings RatingsResult
+	if len(snapshot) == 0 || json.Unmarshal(snapshot, &ratings) != nil {
+		return ""
+	}
+
+	var parts []string
+	if ratings.IMDB != "" {
+		parts = append(parts, "IMDb "+ratings.IMDB)
+	}
+	if ratings.RottenTomatoes != nil {
+		parts = append(parts, fmt.Sprintf("RT %d%%", *ratings.RottenTomatoes))
+	}
+	if ratings.Metacritic != nil {
+		parts = append(parts, fmt.Sprintf("Metacritic %d", *ratings.Metacritic))
+	}
+	return strings.Join(parts, " • ")
+}
+
+// encodeFields stores fields in the notification queue, "" when there are none
+func encodeFields(fields []NotificationField) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(fields)
+	return string(b)
+}
+
+// RequestURL links to a request in the web UI, "" when public_url is unset
+func (s *NotificationService) RequestURL(id int) string {
+	publicURL := strings.TrimRight(s.db.GetSetting("public_url"), "/")
+	if publicURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/#request-%d", publicURL, id)
+}
+
+// posterURL is the request's poster when it's a full URL that chat apps
+// can fetch
+func posterURL(req models.Request) string {
+	if req.Poster == nil || !strings.HasPrefix(*req.Poster, "http") {
+		return ""
+	}
+	return *req.Poster
+}
+
+// SendToRequester delivers a notification to the person who made the
+// request, when notify_requesters is on: to their own ntfy topic (the
+// ntfy_user_topic_prefix followed by their name) and to their email address.
+// Failures are queued for retry like SendEvent's.
+func (s *NotificationService) SendToRequester(ctx context.Context, req models.Request, event, title, message string) error {
+	if !s.db.GetSettingBool("notify_requesters", false) {
+		return nil
+	}
+	url, image, fields := s.RequestURL(req.ID), posterURL(req), requestFields(req)
+
+	var channels []string
+	if topic := s.requesterTopic(req.RequesterName); topic != "" && s.db.GetSetting("ntfy_url") != "" {
+		channels = append(channels, "ntfy:"+topic)
+	}
+	if req.RequesterEmail != nil && *req.RequesterEmail != "" && s.db.GetSetting("smtp_host") != "" && s.db.GetSetting("smtp_from") != "" {
+		channels = append(channels, "email:"+*req.RequesterEmail)
+	}
+
+	var errs []error
+	for _, channel := range channels {
+		err := withRetry(ctx, sendAttempts, sendBackoffBase, func() error {
+			return s.deliver(ctx, channel, event, title, message, url, image, fields)
+		})
+		if err != nil {
+			s.db.EnqueueNotification(channel, event, title, message, url, image, encodeFields(fields), err.Error(), time.Now().Add(notifyRetryBase))
+			errs = append(errs, fmt.Errorf("%s: %w", channel, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	ntfyTopicInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+	ntfyTopicPrefix  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+)
+
+// IsValidNtfyTopicPrefix reports whether prefix can start an ntfy topic name
+func IsValidNtfyTopicPrefix(prefix string) bool {
+	return ntfyTopicPrefix.MatchString(prefix)
+}
+
+// requesterTopic is the requester's personal ntfy topic, "" when
+// ntfy_user_topic_prefix is unset
+func (s *NotificationService) requesterTopic(requester string) string {
+	prefix := s.db.GetSetting("ntfy_user_topic_prefix")
+	name := strings.Trim(ntfyTopicInvalid.ReplaceAllString(strings.ToLower(requester), "-"), "-")
+	if prefix == "" || name == "" {
+		return ""
+	}
+
+	topic := prefix + name
+	if len(topic) > ntfyMaxTopicLength {
+		topic = topic[:ntfyMaxTopicLength]
+	}
+	return topic
+}
+
+// SendTest delivers a sample notification through one channel, or every
+// configured channel when channel is "", without retrying or queueing it.
+// The result maps each channel tried to its error, nil when it went through.
+func (s *NotificationService) SendTest(ctx context.Context, channel string) (map[string]error, error) {
+	channels := s.configuredChannels()
+	if channel != "" {
+		configured := false
+		for _, c := range channels {
+			if c == channel {
+				configured = true
+			}
+		}
+		if !configured {
+			return nil, fmt.Errorf("Notification channel %s is not configured", channel)
+		}
+		channels = []string{channel}
 	}
 
-	// ntfy
-	ntfyURL := s.db.GetSetting("ntfy_url")
-	ntfyTopic := s.db.GetSetting("ntfy_topic")
-	if ntfyURL != "" && ntfyTopic != "" {
-		s.sendNtfy(ntfyURL, ntfyTopic, title, message, url)
+	fields := []NotificationField{{Name: "Requester", Value: "Requestarr"}, {Name: "Type", Value: "Test"}}
+	results := make(map[string]error, len(channels))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, channel := range channels {
+		wg.Add(1)
+		go func(channel string) {
+			defer wg.Done()
+
+			err := s.deliver(ctx, channel, NotifyTest, "🔔 Test Notification", "If you can read this, **Requestarr** notifications are working.", strings.TrimRight(s.db.GetSetting("public_url"), "/"), "", fields)
+			mu.Lock()
+			results[channel] = err
+			mu.Unlock()
+		}(channel)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+// withRetry calls fn up to attempts times, doubling the wait between tries.
+// It gives up early once ctx is done.
+func withRetry(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = fn(); err == nil || err == errChannelNotConfigured {
+			return err
+		}
+	}
+	return err
+}
+
+// SendRequestReady announces that a requested title finished downloading
+func (s *NotificationService) SendRequestReady(ctx context.Context, req models.Request) error {
+	if !s.Enabled(EventComplete) {
+		return nil
+	}
+
+	mediaWord := "Movie"
+	if req.MediaType == "series" {
+		mediaWord = "Series"
+	}
+	title := fmt.Sprintf("🎉 %s Ready", mediaWord)
+	message := fmt.Sprintf("**%s** is now available to watch!", req.Title)
+	return errors.Join(
+		s.SendRequestEvent(ctx, req, NotifyRequestCompleted, title, message),
+		s.SendToRequester(ctx, req, NotifyRequestCompleted, title, message),
+	)
+}
+
+// SendFirstEpisodeReady announces that a requested series has its first
+// episode while the rest are still downloading
+func (s *NotificationService) SendFirstEpisodeReady(ctx context.Context, req models.Request) error {
+	if !s.Enabled(EventFirstEpisode) {
+		return nil
+	}
+
+	message := fmt.Sprintf("**%s** has started arriving, more episodes are on the way!", req.Title)
+	return errors.Join(
+		s.SendRequestEvent(ctx, req, NotifyFirstEpisode, "📺 First Episode Ready", message),
+		s.SendToRequester(ctx, req, NotifyFirstEpisode, "📺 First Episode Ready", message),
+	)
+}
+
+// ProcessQueue retries queued notifications that are due, backing off
+// exponentially and dropping them after notifyMaxAttempts. It stops early
+// once ctx is done, leaving the rest queued.
+func (s *NotificationService) ProcessQueue(ctx context.Context) {
+	items, err := s.db.GetDueNotifications(time.Now())
+	if err != nil {
+		slog.Error("Failed to get queued notifications", "error", err)
+		return
+	}
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return
+		}
+		var fields []NotificationField
+		if item.Fields != "" {
+			json.Unmarshal([]byte(item.Fields), &fields)
+		}
+		err := s.deliver(ctx, item.Channel, item.Event, item.Title, item.Message, item.URL, item.Image, fields)
+		if err == nil || err == errChannelNotConfigured {
+			s.db.DeleteNotification(item.ID)
+			continue
+		}
+
+		attempts := item.Attempts + 1
+		if attempts >= notifyMaxAttempts {
+			slog.Warn("Dropping notification", "title", item.Title, "channel", item.Channel, "attempts", attempts, "error", err)
+			s.db.LogActivity("notification_failed", map[string]interface{}{
+				"channel":  item.Channel,
+				"title":    item.Title,
+				"attempts": attempts,
+				"error":    err.Error(),
+			})
+			s.db.DeleteNotification(item.ID)
+			continue
+		}
+
+		s.db.UpdateNotificationAttempt(item.ID, attempts, err.Error(), time.Now().Add(notifyRetryBase*time.Duration(1<<(attempts-1))))
 	}
 }
 
-func (s *NotificationService) sendDiscord(webhook, title, message, url string) error {
+func (s *NotificationService) configuredChannels() []string {
+	var channels []string
+
+	if s.db.GetSetting("discord_webhook") != "" {
+		channels = append(channels, "discord")
+	}
+	if s.db.GetSetting("ntfy_url") != "" && s.db.GetSetting("ntfy_topic") != "" {
+		channels = append(channels, "ntfy")
+	}
+	if s.db.GetSetting("telegram_bot_token") != "" && s.db.GetSetting("telegram_chat_id") != "" {
+		channels = append(channels, "telegram")
+	}
+	if s.db.GetSetting("smtp_host") != "" && s.db.GetSetting("smtp_from") != "" && s.db.GetSetting("smtp_to") != "" {
+		channels = append(channels, "email")
+	}
+	if s.db.GetSetting("webhook_notify_url") != "" {
+		channels = append(channels, "webhook")
+	}
+
+	return channels
+}
+
+// deliver sends to a channel by name. Requester channels carry their
+// address, as "ntfy:<topic>" or "email:<address>".
+func (s *NotificationService) deliver(ctx context.Context, channel, event, title, message, url, image string, fields []NotificationField) error {
+	if kind, address, ok := strings.Cut(channel, ":"); ok {
+		switch kind {
+		case "ntfy":
+			ntfyURL := s.db.GetSetting("ntfy_url")
+			if ntfyURL == "" {
+				return errChannelNotConfigured
+			}
+			return s.sendNtfy(ctx, ntfyURL, address, title, message, url, image)
+		case "email":
+			if s.db.GetSetting("smtp_host") == "" {
+				return errChannelNotConfigured
+			}
+			return s.sendEmail(ctx, []string{address}, title, message, url, image)
+		}
+		return errChannelNotConfigured
+	}
+
+	switch channel {
+	case "discord":
+		discordWebhook := s.db.GetSetting("discord_webhook")
+		if discordWebhook == "" {
+			return errChannelNotConfigured
+		}
+		return s.sendDiscord(ctx, discordWebhook, title, message, url, image, fields)
+	case "ntfy":
+		ntfyURL := s.db.GetSetting("ntfy_url")
+		ntfyTopic := s.db.GetSetting("ntfy_topic")
+		if ntfyURL == "" || ntfyTopic == "" {
+			return errChannelNotConfigured
+		}
+		return s.sendNtfy(ctx, ntfyURL, ntfyTopic, title, message, url, image)
+	case "telegram":
+		botToken := s.db.GetSetting("telegram_bot_token")
+		chatID := s.db.GetSetting("telegram_chat_id")
+		if botToken == "" || chatID == "" {
+			return errChannelNotConfigured
+		}
+		return s.sendTelegram(ctx, botToken, chatID, title, message, url)
+	case "email":
+		if s.db.GetSetting("smtp_host") == "" {
+			return errChannelNotConfigured
+		}
+		var recipients []string
+		for _, to := range strings.Split(s.db.GetSetting("smtp_to"), ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				recipients = append(recipients, to)
+			}
+		}
+		return s.sendEmail(ctx, recipients, title, message, url, image)
+	case "webhook":
+		webhookURL := s.db.GetSetting("webhook_notify_url")
+		if webhookURL == "" {
+			return errChannelNotConfigured
+		}
+		return s.sendWebhook(ctx, webhookURL, event, title, message, url, image, fields)
+	}
+	return errChannelNotConfigured
+}
+
+func (s *NotificationService) sendDiscord(ctx context.Context, webhook, title, message, url, image string, fields []NotificationField) error {
 	embed := map[string]interface{}{
 		"title":       title,
 		"description": message,
@@ -50,6 +504,23 @@ func (s *NotificationService) sendDiscord(webhook, title, message, url string) e
 	if url != "" {
 		embed["url"] = url
 	}
+	if image != "" {
+		embed["thumbnail"] = map[string]string{"url": image}
+	}
+	if len(fields) > 0 {
+		embedFields := make([]map[string]interface{}, 0, len(fields))
+		for _, field := range fields {
+			if field.Value == "" {
+				continue
+			}
+			embedFields = append(embedFields, map[string]interface{}{
+				"name":   field.Name,
+				"value":  field.Value,
+				"inline": true,
+			})
+		}
+		embed["fields"] = embedFields
+	}
 
 	payload := map[string]interface{}{
 		"embeds": []interface{}{embed},
@@ -57,17 +528,21 @@ func (s *NotificationService) sendDiscord(webhook, title, message, url string) e
 
 	jsonData, _ := json.Marshal(payload)
 
-	resp, err := s.client.Post(webhook, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := s.postJSON(ctx, webhook, jsonData)
 	if err != nil {
 		return err
 	}
 	resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Discord returned %d", resp.StatusCode)
+	}
+
 	return nil
 }
 
-func (s *NotificationService) sendNtfy(ntfyURL, topic, title, message, url string) error {
-	req, err := http.NewRequest("POST", ntfyURL+"/"+topic, bytes.NewBufferString(message))
+func (s *NotificationService) sendNtfy(ctx context.Context, ntfyURL, topic, title, message, url, image string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", ntfyURL+"/"+topic, bytes.NewBufferString(message))
 	if err != nil {
 		return err
 	}
@@ -76,6 +551,9 @@ func (s *NotificationService) sendNtfy(ntfyURL, topic, title, message, url strin
 	if url != "" {
 		req.Header.Set("Click", url)
 	}
+	if image != "" {
+		req.Header.Set("Attach", image)
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
@@ -83,5 +561,182 @@ func (s *NotificationService) sendNtfy(ntfyURL, topic, title, message, url strin
 	}
 	resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ntfy returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (s *NotificationService) sendTelegram(ctx context.Context, botToken, chatID, title, message, url string) error {
+	// The limit counts the visible text, so the message is cut before it is
+	// escaped and can't end in half an entity or tag
+	room := telegramMaxMessageLength - len([]rune(title)) - len([]rune(url)) - 2
+	if runes := []rune(message); len(runes) > room {
+		message = string(runes[:max(room-3, 0)]) + "..."
+	}
+
+	// Sent as HTML, titles and names can contain anything and Telegram
+	// rejects Markdown with unbalanced _, * or [
+	text := "<b>" + html.EscapeString(title) + "</b>\n" + markdownBold.ReplaceAllString(html.EscapeString(message), "<b>$1</b>")
+	if url != "" {
+		text += "\n" + html.EscapeString(url)
+	}
+
+	payload := map[string]interface{}{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": "HTML",
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	resp, err := s.postJSON(ctx, "https://api.telegram.org/bot"+botToken+"/sendMessage", jsonData)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var result struct {
+			Description string `json:"description"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return fmt.Errorf("Telegram returned %d: %s", resp.StatusCode, result.Description)
+	}
+
 	return nil
 }
+
+func (s *NotificationService) sendWebhook(ctx context.Context, webhookURL, event, title, message, url, image string, fields []NotificationField) error {
+	if fields == nil {
+		fields = []NotificationField{}
+	}
+	payload := map[string]interface{}{
+		"event":     event,
+		"title":     title,
+		"message":   message,
+		"url":       url,
+		"image":     image,
+		"fields":    fields,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	jsonData, _ := json.Marshal(payload)
+
+	resp, err := s.postJSON(ctx, webhookURL, jsonData)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook returned %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// postJSON posts a JSON body, giving up when ctx is done
+func (s *NotificationService) postJSON(ctx context.Context, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.client.Do(req)
+}
+
+var markdownBold = regexp.MustCompile(`\*\*(.+?)\*\*`)
+
+func (s *NotificationService) sendEmail(ctx context.Context, recipients []string, title, message, url, image string) error {
+	host := s.db.GetSetting("smtp_host")
+	port := s.db.GetSetting("smtp_port")
+	if port == "" {
+		port = "587"
+	}
+	username := s.db.GetSetting("smtp_username")
+	password := s.db.GetSetting("smtp_password")
+	from := s.db.GetSetting("smtp_from")
+	if from == "" || len(recipients) == 0 {
+		return errChannelNotConfigured
+	}
+
+	body := "<h2>" + html.EscapeString(title) + "</h2>\n"
+	if image != "" {
+		body += `<p><img src="` + html.EscapeString(image) + `" alt="" width="200"></p>` + "\n"
+	}
+	body += "<p>" + markdownBold.ReplaceAllString(html.EscapeString(message), "<strong>$1</strong>") + "</p>\n"
+	if url != "" {
+		body += `<p><a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + "</a></p>\n"
+	}
+
+	headers := []string{
+		"From: " + from,
+		"To: " + strings.Join(recipients, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", title),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/html; charset=UTF-8",
+	}
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + body
+
+	// Port 465 uses implicit TLS, anything else upgrades with STARTTLS when offered
+	addr := net.JoinHostPort(host, port)
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if port == "465" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if port != "465" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if username != "" {
+		if err := c.Auth(smtp.PlainAuth("", username, password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range recipients {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// overseerrPageSize is how many requests are fetched per API call
+const overseerrPageSize = 100
+
+// Overseerr request statuses, Jellyseerr uses the same values
+const (
+	OverseerrRequestPending   = 1
+	OverseerrRequestApproved  = 2
+	OverseerrRequestDeclined  = 3
+	OverseerrRequestFailed    = 4
+	OverseerrRequestCompleted = 5
+)
+
+// Overseerr media statuses
+const (
+	OverseerrMediaProcessing         = 3
+	OverseerrMediaPartiallyAvailable = 4
+	OverseerrMediaAvailable          = 5
+)
+
+// OverseerrRequest is a request as returned by Overseerr's and Jellyseerr's
+// /api/v1/request endpoint
+type OverseerrRequest struct {
+	ID        int       `json:"id"`
+	Status    int       `json:"status"`
+	Type      string    `json:"type"` // movie or tv
+	Is4K      bool      `json:"is4k"`
+	CreatedAt time.Time `json:"createdAt"`
+	Media     struct {
+		TmdbID int    `json:"tmdbId"`
+		TvdbID int    `json:"tvdbId"`
+		ImdbID string `json:"imdbId"`
+		Status int    `json:"status"`
+	} `json:"media"`
+	RequestedBy struct {
+		DisplayName  string `json:"displayName"`
+		Username     string `json:"username"`
+		PlexUsername string `json:"plexUsername"`
+		Email        string `json:"email"`
+	} `json:"requestedBy"`
+	Seasons []struct {
+		SeasonNumber int `json:"seasonNumber"`
+	} `json:"seasons"`
+}
+
+// RequesterName is the name Overseerr shows for the requesting user
+func (r *OverseerrRequest) RequesterName() string {
+	for _, name := range []string{r.RequestedBy.DisplayName, r.RequestedBy.Username, r.RequestedBy.PlexUsername, r.RequestedBy.Email} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseOverseerrExport reads requests saved from Overseerr's API, either a
+// page ({"results": [...]}) or a plain list
+func ParseOverseerrExport(data []byte) ([]OverseerrRequest, error) {
+	var page struct {
+		Results []OverseerrRequest `json:"results"`
+	}
+	if err := json.Unmarshal(data, &page); err == nil {
+		return page.Results, nil
+	}
+
+	var list []OverseerrRequest
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("Invalid Overseerr export: %w", err)
+	}
+	return list, nil
+}
+
+// FetchOverseerrRequests pages through every request of an Overseerr or
+// Jellyseerr instance
+func FetchOverseerrRequests(ctx context.Context, baseURL, apiKey string) ([]OverseerrRequest, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	var requests []OverseerrRequest
+	for skip := 0; ; skip += overseerrPageSize {
+		u := fmt.Sprintf("%s/api/v1/request?take=%d&skip=%d&sort=added", baseURL, overseerrPageSize, skip)
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			PageInfo struct {
+				Results int `json:"results"`
+			} `json:"pageInfo"`
+			Results []OverseerrRequest `json:"results"`
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Overseerr rejected the API key")
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Overseerr returned %d", resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		requests = append(requests, page.Results...)
+		if len(page.Results) < overseerrPageSize || len(requests) >= page.PageInfo.Results {
+			return requests, nil
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IcarusCore/Requestarr/internal/cache"
+	"github.com/IcarusCore/Requestarr/internal/models"
+)
+
+const (
+	plexTVURL       = "https://plex.tv/api/v2"
+	plexAuthURL     = "https://app.plex.tv/auth#"
+	plexDiscoverURL = "https://discover.provider.plex.tv"
+	plexProduct     = "Requestarr"
+
+	plexWatchlistPageSize = 100
+)
+
+// PlexService signs users in with their Plex account using the PIN flow:
+// a PIN is created, the user approves it on plex.tv, and polling the PIN
+// then yields an auth token
+type PlexService struct {
+	db     *models.DB
+	cache  cache.CacheStore
+	client *http.Client
+}
+
+// PlexPin is a PIN waiting to be approved at URL
+type PlexPin struct {
+	ID   int    `json:"id"`
+	Code string `json:"code"`
+	URL  string `json:"url"`
+}
+
+// PlexAccount is the Plex user a token belongs to
+type PlexAccount struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Thumb    string `json:"thumb"`
+}
+
+// PlexWatchlistItem is a title on a user's Plex watchlist, with the ids
+// Plex knows for it
+type PlexWatchlistItem struct {
+	Title  string
+	Year   int
+	Type   string // movie or show
+	TmdbID int
+	TvdbID int
+	ImdbID string
+}
+
+func NewPlexService(db *models.DB, cache cache.CacheStore) *PlexService {
+	return &PlexService{
+		db:    db,
+		cache: cache,
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+// clientIdentifier returns the ID Plex knows this instance by, generating
+// it on first use
+func (s *PlexService) clientIdentifier() string {
+	id := s.db.GetSetting("plex_client_identifier")
+	if id == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+		s.db.SetSetting("plex_client_identifier", id)
+	}
+	return id
+}
+
+// request calls a plex.tv API endpoint
+func (s *PlexService) request(ctx context.Context, method, endpoint, token string, result interface{}) error {
+	return s.requestURL(ctx, method, plexTVURL+endpoint, token, result)
+}
+
+// requestURL calls a Plex API and decodes its JSON reply into result
+func (s *PlexService) requestURL(ctx context.Context, method, u, token string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Plex-Product", plexProduct)
+	req.Header.Set("X-Plex-Client-Identifier", s.clientIdentifier())
+	if token != "" {
+		req.Header.Set("X-Plex-Token", token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("Plex rejected the token")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Plex returned %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// CreatePin starts a sign-in, the user approves it by opening the PIN's URL
+func (s *PlexService) CreatePin(ctx context.Context) (*PlexPin, error) {
+	var pin PlexPin
+	if err := s.request(ctx, "POST", "/pins?strong=true", "", &pin); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("clientID", s.clientIdentifier())
+	params.Set("code", pin.Code)
+	params.Set("context[device][product]", plexProduct)
+	pin.URL = plexAuthURL + "?" + params.Encode()
+
+	return &pin, nil
+}
+
+// CheckPin returns the auth token for an approved PIN, or "" while the user
+// hasn't signed in yet
+func (s *PlexService) CheckPin(ctx context.Context, id int) (string, error) {
+	var pin struct {
+		AuthToken *string `json:"authToken"`
+	}
+	if err := s.request(ctx, "GET", fmt.Sprintf("/pins/%d", id), "", &pin); err != nil {
+		return "", err
+	}
+	if pin.AuthToken == nil {
+		return "", nil
+	}
+	return *pin.AuthToken, nil
+}
+
+func (s *PlexService) GetAccount(ctx context.Context, token string) (*PlexAccount, error) {
+	var account PlexAccount
+	if err := s.request(ctx, "GET", "/user", token, &account); err != nil {
+		return nil, err
+	}
+	if account.ID == 0 || account.Username == "" {
+		return nil, fmt.Errorf("Plex returned an incomplete account")
+	}
+	return &account, nil
+}
+
+// HasServerAccess reports whether the token's account owns or has been
+// shared the Plex server with the given machine identifier
+func (s *PlexService) HasServerAccess(ctx context.Context, token, machineID string) (bool, error) {
+	var resources []struct {
+		ClientIdentifier string `json:"clientIdentifier"`
+	}
+	if err := s.request(ctx, "GET", "/resources", token, &resources); err != nil {
+		return false, err
+	}
+
+	for _, resource := range resources {
+		if resource.ClientIdentifier == machineID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetWatchlist returns the titles on the token's account watchlist
+func (s *PlexService) GetWatchlist(ctx context.Context, token string) ([]PlexWatchlistItem, error) {
+	var items []PlexWatchlistItem
+	for start := 0; ; start += plexWatchlistPageSize {
+		var page struct {
+			MediaContainer struct {
+				TotalSize int            `json:"totalSize"`
+				Metadata  []plexMetadata `json:"Metadata"`
+			} `json:"MediaContainer"`
+		}
+		u := fmt.Sprintf("%s/library/sections/watchlist/all?includeGuids=1&X-Plex-Container-Start=%d&X-Plex-Container-Size=%d", plexDiscoverURL, start, plexWatchlistPageSize)
+		if err := s.requestURL(ctx, "GET", u, token, &page); err != nil {
+			return nil, err
+		}
+
+		for _, metadata := range page.MediaContainer.Metadata {
+			if metadata.Type != "movie" && metadata.Type != "show" {
+				continue
+			}
+			item := PlexWatchlistItem{Title: metadata.Title, Year: metadata.Year, Type: metadata.Type}
+			guids := metadata.Guid
+			if len(guids) == 0 {
+				var err error
+				if guids, err = s.metadataGuids(ctx, token, metadata.RatingKey); err != nil {
+					slog.Warn("Failed to get Plex metadata", "title", metadata.Title, "error", err)
+					continue
+				}
+			}
+			setPlexGuids(&item, guids)
+			items = append(items, item)
+		}
+
+		if len(page.MediaContainer.Metadata) < plexWatchlistPageSize || start+plexWatchlistPageSize >= page.MediaContainer.TotalSize {
+			return items, nil
+		}
+	}
+}
+
+type plexMetadata struct {
+	RatingKey string     `json:"ratingKey"`
+	Title     string     `json:"title"`
+	Type      string     `json:"type"`
+	Year      int        `json:"year"`
+	Guid      []plexGuid `json:"Guid"`
+}
+
+type plexGuid struct {
+	ID string `json:"id"`
+}
+
+// metadataGuids fetches the external ids of a Plex title, cached for a day
+// as they don't change
+func (s *PlexService) metadataGuids(ctx context.Context, token, ratingKey string) ([]plexGuid, error) {
+	cacheKey := "plex_guids_" + ratingKey
+	if cached, found := s.cache.Get(cacheKey); found {
+		ids := cached.([]interface{})
+		guids := make([]plexGuid, len(ids))
+		for i, id := range ids {
+			guids[i].ID, _ = id.(string)
+		}
+		return guids, nil
+	}
+
+	var result struct {
+		MediaContainer struct {
+			Metadata []plexMetadata `json:"Metadata"`
+		} `json:"MediaContainer"`
+	}
+	if err := s.requestURL(ctx, "GET", plexDiscoverURL+"/library/metadata/"+url.PathEscape(ratingKey), token, &result); err != nil {
+		return nil, err
+	}
+	if len(result.MediaContainer.Metadata) == 0 {
+		return nil, fmt.Errorf("Plex returned no metadata")
+	}
+
+	guids := result.MediaContainer.Metadata[0].Guid
+	ids := make([]interface{}, len(guids))
+	for i, guid := range guids {
+		ids[i] = guid.ID
+	}
+	s.cache.SetWithTTL(cacheKey, ids, 24*time.Hour)
+	return guids, nil
+}
+
+// setPlexGuids fills in the ids from Plex guids like tmdb://603
+func setPlexGuids(item *PlexWatchlistItem, guids []plexGuid) {
+	for _, guid := range guids {
+		scheme, id, ok := strings.Cut(guid.ID, "://")
+		if !ok {
+			continue
+		}
+		switch scheme {
+		case "tmdb":
+			item.TmdbID, _ = strconv.Atoi(id)
+		case "tvdb":
+			item.TvdbID, _ = strconv.Atoi(id)
+		case "imdb":
+			item.ImdbID = id
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import "time"
+
+// QueueStatus summarizes the Sonarr/Radarr queue entries for one series or movie
+type QueueStatus struct {
+	Percent             int    `json:"percent"`
+	Status              string `json:"status"`
+	TimeLeft            string `json:"timeLeft,omitempty"`
+	EstimatedCompletion string `json:"estimatedCompletion,omitempty"`
+	Items               int    `json:"items"`
+}
+
+// SummarizeQueue combines the queue records whose idField ("seriesId" or
+// "movieId") matches arrID, returning nil when none are queued
+func SummarizeQueue(records []map[string]interface{}, idField string, arrID int) *QueueStatus {
+	var status *QueueStatus
+	var size, sizeLeft float64
+	var latest time.Time
+
+	for _, record := range records {
+		if getInt(record, idField) != arrID {
+			continue
+		}
+		if status == nil {
+			status = &QueueStatus{Status: getString(record, "status")}
+		}
+		status.Items++
+
+		recordSize, _ := record["size"].(float64)
+		recordLeft, _ := record["sizeleft"].(float64)
+		size += recordSize
+		sizeLeft += recordLeft
+
+		// Episodes download in parallel, so the slowest one decides when the series is done
+		if eta, err := time.Parse(time.RFC3339, getString(record, "estimatedCompletionTime")); err == nil && eta.After(latest) {
+			latest = eta
+			status.TimeLeft = getString(record, "timeleft")
+			status.EstimatedCompletion = eta.Format(time.RFC3339)
+		}
+		if getString(record, "status") == "downloading" {
+			status.Status = "downloading"
+		}
+	}
+
+	if status != nil && size > 0 {
+		status.Percent = int((size - sizeLeft) * 100 / size)
+	}
+	return status
+}
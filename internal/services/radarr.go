@@ -2,23 +2,49 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/IcarusCore/Requestarr/internal/cache"
 	"github.com/IcarusCore/Requestarr/internal/models"
 )
 
+// ExistingMoviesCacheKey prefixes the cached library tmdb ids of each Radarr
+// instance, e.g. existing_movies_radarr_4k
+const ExistingMoviesCacheKey = "existing_movies"
+
+// MinimumAvailabilityOptions are the minimumAvailability values Radarr accepts
+var MinimumAvailabilityOptions = []string{"announced", "inCinemas", "released"}
+
+func IsValidMinimumAvailability(availability string) bool {
+	for _, option := range MinimumAvailabilityOptions {
+		if option == availability {
+			return true
+		}
+	}
+	return false
+}
+
 type RadarrService struct {
 	db     *models.DB
+	cache  cache.CacheStore
+	prefix string
 	client *http.Client
 }
 
-func NewRadarrService(db *models.DB) *RadarrService {
+// NewRadarrService creates a Radarr client reading its url and api key from
+// the <prefix>_url and <prefix>_api_key settings, e.g. "radarr" or "radarr_4k"
+func NewRadarrService(db *models.DB, cache cache.CacheStore, prefix string) *RadarrService {
 	return &RadarrService{
-		db: db,
+		db:     db,
+		cache:  cache,
+		prefix: prefix,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -26,10 +52,15 @@ func NewRadarrService(db *models.DB) *RadarrService {
 }
 
 func (s *RadarrService) getConfig() (string, string) {
-	return s.db.GetSetting("radarr_url"), s.db.GetSetting("radarr_api_key")
+	return s.db.GetSetting(s.prefix + "_url"), s.db.GetSetting(s.prefix + "_api_key")
 }
 
-func (s *RadarrService) request(method, endpoint string, data interface{}) (interface{}, error) {
+func (s *RadarrService) IsConfigured() bool {
+	radarrURL, apiKey := s.getConfig()
+	return radarrURL != "" && apiKey != ""
+}
+
+func (s *RadarrService) request(ctx context.Context, method, endpoint string, data interface{}) (interface{}, error) {
 	radarrURL, apiKey := s.getConfig()
 	if radarrURL == "" || apiKey == "" {
 		return nil, fmt.Errorf("Radarr not configured")
@@ -42,10 +73,10 @@ func (s *RadarrService) request(method, endpoint string, data interface{}) (inte
 
 	if data != nil {
 		jsonData, _ := json.Marshal(data)
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+		req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(jsonData))
 		req.Header.Set("Content-Type", "application/json")
 	} else {
-		req, err = http.NewRequest(method, url, nil)
+		req, err = http.NewRequestWithContext(ctx, method, url, nil)
 	}
 
 	if err != nil {
@@ -61,19 +92,23 @@ func (s *RadarrService) request(method, endpoint string, data interface{}) (inte
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("Radarr returned %d", resp.StatusCode)
+		return nil, newArrError("Radarr", resp)
 	}
 
 	var result interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// Some endpoints (delete) reply without a body
+		if err == io.EOF {
+			return nil, nil
+		}
 		return nil, err
 	}
 
 	return result, nil
 }
 
-func (s *RadarrService) Search(term string) ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "movie/lookup?term="+term, nil)
+func (s *RadarrService) Search(ctx context.Context, term string) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "movie/lookup?term="+url.QueryEscape(term), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +123,8 @@ func (s *RadarrService) Search(term string) ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *RadarrService) GetExisting() ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "movie", nil)
+func (s *RadarrService) GetExisting(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "movie", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -104,8 +139,8 @@ func (s *RadarrService) GetExisting() ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *RadarrService) GetMovie(id int) (map[string]interface{}, error) {
-	result, err := s.request("GET", fmt.Sprintf("movie/%d", id), nil)
+func (s *RadarrService) GetMovie(ctx context.Context, id int) (map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("movie/%d", id), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +150,68 @@ func (s *RadarrService) GetMovie(id int) (map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *RadarrService) GetRootFolders() ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "rootfolder", nil)
+// FindMovie returns the library movie with the given tmdb id, nil when
+// Radarr doesn't have it
+func (s *RadarrService) FindMovie(ctx context.Context, tmdbID int) (map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("movie?tmdbId=%d", tmdbID), nil)
+	if err != nil {
+		return nil, err
+	}
+	if arr, ok := result.([]interface{}); ok && len(arr) > 0 {
+		if m, ok := arr[0].(map[string]interface{}); ok {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+// UpgradeMovie moves a library movie to another quality profile and
+// searches for a release that meets it
+func (s *RadarrService) UpgradeMovie(ctx context.Context, id, qualityProfileID int) error {
+	movie, err := s.GetMovie(ctx, id)
+	if err != nil {
+		return err
+	}
+	if movie == nil {
+		return fmt.Errorf("movie not found")
+	}
+
+	movie["qualityProfileId"] = qualityProfileID
+	movie["monitored"] = true
+	if _, err := s.request(ctx, "PUT", fmt.Sprintf("movie/%d", id), movie); err != nil {
+		return err
+	}
+
+	return s.SearchMovie(ctx, id)
+}
+
+// SearchMovie has Radarr search for a release of a library movie
+func (s *RadarrService) SearchMovie(ctx context.Context, id int) error {
+	_, err := s.request(ctx, "POST", "command", map[string]interface{}{
+		"name":     "MoviesSearch",
+		"movieIds": []int{id},
+	})
+	return err
+}
+
+// CutoffMet reports whether a movie has a file that meets its quality
+// profile's cutoff
+func (s *RadarrService) CutoffMet(ctx context.Context, id int) (bool, error) {
+	movie, err := s.GetMovie(ctx, id)
+	if err != nil || movie == nil {
+		return false, err
+	}
+
+	file, ok := movie["movieFile"].(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	notMet, _ := file["qualityCutoffNotMet"].(bool)
+	return !notMet, nil
+}
+
+func (s *RadarrService) GetRootFolders(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "rootfolder", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +226,8 @@ func (s *RadarrService) GetRootFolders() ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *RadarrService) GetQualityProfiles() ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "qualityprofile", nil)
+func (s *RadarrService) GetQualityProfiles(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "qualityprofile", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -147,12 +242,12 @@ func (s *RadarrService) GetQualityProfiles() ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *RadarrService) AddMovie(tmdbID int, rootFolder string, qualityProfileID int, minimumAvailability string) (map[string]interface{}, error) {
+func (s *RadarrService) AddMovie(ctx context.Context, tmdbID int, rootFolder string, qualityProfileID int, minimumAvailability string) (map[string]interface{}, error) {
 	// First lookup the movie
-	result, err := s.request("GET", fmt.Sprintf("movie/lookup/tmdb?tmdbId=%d", tmdbID), nil)
+	result, err := s.request(ctx, "GET", fmt.Sprintf("movie/lookup/tmdb?tmdbId=%d", tmdbID), nil)
 	if err != nil {
 		// Try alternative lookup
-		result, err = s.request("GET", fmt.Sprintf("movie/lookup?term=tmdb:%d", tmdbID), nil)
+		result, err = s.request(ctx, "GET", fmt.Sprintf("movie/lookup?term=tmdb:%d", tmdbID), nil)
 		if err != nil {
 			return nil, err
 		}
@@ -175,10 +270,11 @@ func (s *RadarrService) AddMovie(tmdbID int, rootFolder string, qualityProfileID
 		"searchForMovie": true,
 	}
 
-	addResult, err := s.request("POST", "movie", movieData)
+	addResult, err := s.request(ctx, "POST", "movie", movieData)
 	if err != nil {
 		return nil, err
 	}
+	s.InvalidateExisting()
 
 	if m, ok := addResult.(map[string]interface{}); ok {
 		return m, nil
@@ -186,22 +282,70 @@ func (s *RadarrService) AddMovie(tmdbID int, rootFolder string, qualityProfileID
 	return nil, nil
 }
 
-func (s *RadarrService) CheckExists(tmdbID int) (bool, error) {
-	existing, err := s.GetExisting()
+func (s *RadarrService) DeleteMovie(ctx context.Context, id int, deleteFiles bool) error {
+	_, err := s.request(ctx, "DELETE", fmt.Sprintf("movie/%d?deleteFiles=%t", id, deleteFiles), nil)
+	if err == nil {
+		s.InvalidateExisting()
+	}
+	return err
+}
+
+// GetQueue returns the records currently in Radarr's download queue
+func (s *RadarrService) GetQueue(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "queue?pageSize=1000", nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	for _, movie := range existing {
-		if id, ok := movie["tmdbId"].(float64); ok && int(id) == tmdbID {
-			return true, nil
+	page, _ := result.(map[string]interface{})
+	records, _ := page["records"].([]interface{})
+	items := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		if m, ok := record.(map[string]interface{}); ok {
+			items = append(items, m)
 		}
 	}
-	return false, nil
+	return items, nil
+}
+
+func (s *RadarrService) existingCacheKey() string {
+	return ExistingMoviesCacheKey + "_" + s.prefix
+}
+
+// ExistingIDs returns the tmdb ids in this instance's library, cached for
+// two minutes and invalidated when movies are added or removed
+func (s *RadarrService) ExistingIDs(ctx context.Context) (map[int]bool, error) {
+	if cached, found := s.cache.Get(s.existingCacheKey()); found {
+		return cached.(map[int]bool), nil
+	}
+
+	movies, err := s.GetExisting(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]bool)
+	addExistingIDs(ids, movies, "tmdbId")
+
+	s.cache.SetWithTTL(s.existingCacheKey(), ids, 2*time.Minute)
+	return ids, nil
+}
+
+// InvalidateExisting drops the cached library ids
+func (s *RadarrService) InvalidateExisting() {
+	s.cache.Delete(s.existingCacheKey())
+}
+
+func (s *RadarrService) CheckExists(ctx context.Context, tmdbID int) (bool, error) {
+	ids, err := s.ExistingIDs(ctx)
+	if err != nil {
+		return false, err
+	}
+	return ids[tmdbID], nil
 }
 
-func (s *RadarrService) GetStatus() (map[string]interface{}, error) {
-	result, err := s.request("GET", "system/status", nil)
+func (s *RadarrService) GetStatus(ctx context.Context) (map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "system/status", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -211,8 +355,8 @@ func (s *RadarrService) GetStatus() (map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *RadarrService) TestConnection(url, apiKey string) (map[string]interface{}, error) {
-	req, _ := http.NewRequest("GET", strings.TrimRight(url, "/")+"/api/v3/system/status", nil)
+func (s *RadarrService) TestConnection(ctx context.Context, url, apiKey string) (map[string]interface{}, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(url, "/")+"/api/v3/system/status", nil)
 	req.Header.Set("X-Api-Key", apiKey)
 
 	resp, err := s.client.Do(req)
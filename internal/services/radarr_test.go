@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IcarusCore/Requestarr/internal/cache"
+)
+
+func TestRadarrSearchEscapesTerm(t *testing.T) {
+	terms := make(chan string, 1)
+	server := lookupServer(t, "/api/v3/movie/lookup", terms)
+	db := newTestDB(t, map[string]string{"radarr_url": server.URL, "radarr_api_key": "test"})
+	radarr := NewRadarrService(db, cache.NewCache(time.Minute, 0, ""), "radarr")
+
+	for _, term := range searchTerms {
+		if _, err := radarr.Search(context.Background(), term); err != nil {
+			t.Fatalf("Search(%q): %v", term, err)
+		}
+		if got := <-terms; got != term {
+			t.Errorf("Radarr got term %q, want %q", got, term)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -20,19 +22,24 @@ const (
 
 type RatingsService struct {
 	db     *models.DB
-	cache  *cache.Cache
+	cache  cache.CacheStore
 	client *http.Client
 }
 
 type RatingsResult struct {
-	RottenTomatoes  *int   `json:"rottenTomatoes,omitempty"`
-	RTAudienceScore *int   `json:"rtAudienceScore,omitempty"`
-	RTCertified     bool   `json:"rtCertified,omitempty"`
-	IMDB            string `json:"imdb,omitempty"`
-	Metacritic      *int   `json:"metacritic,omitempty"`
+	RottenTomatoes  *int    `json:"rottenTomatoes,omitempty"`
+	RTAudienceScore *int    `json:"rtAudienceScore,omitempty"`
+	RTCertified     bool    `json:"rtCertified,omitempty"`
+	IMDB            string  `json:"imdb,omitempty"`
+	Metacritic      *int    `json:"metacritic,omitempty"`
+	Trakt           float64 `json:"trakt,omitempty"`
 }
 
-func NewRatingsService(db *models.DB, cache *cache.Cache) *RatingsService {
+func init() {
+	gob.Register(&RatingsResult{})
+}
+
+func NewRatingsService(db *models.DB, cache cache.CacheStore) *RatingsService {
 	return &RatingsService{
 		db:    db,
 		cache: cache,
@@ -42,7 +49,7 @@ func NewRatingsService(db *models.DB, cache *cache.Cache) *RatingsService {
 	}
 }
 
-func (s *RatingsService) GetRatings(title, year, mediaType, imdbID string, tmdbID int) (*RatingsResult, error) {
+func (s *RatingsService) GetRatings(ctx context.Context, title, year, mediaType, imdbID string, tmdbID int) (*RatingsResult, error) {
 	// Check cache first
 	cacheKey := fmt.Sprintf("ratings_%s_%s_%s", title, year, mediaType)
 	if cached, found := s.cache.Get(cacheKey); found {
@@ -54,7 +61,7 @@ func (s *RatingsService) GetRatings(title, year, mediaType, imdbID string, tmdbI
 	// Try MDBList first (best source)
 	mdblistKey := s.db.GetSetting("mdblist_api_key")
 	if mdblistKey != "" {
-		mdbResult, err := s.getMDBListRatings(mdblistKey, imdbID, tmdbID, mediaType)
+		mdbResult, err := s.getMDBListRatings(ctx, mdblistKey, imdbID, tmdbID, mediaType)
 		if err == nil && mdbResult != nil {
 			result = mdbResult
 		}
@@ -62,7 +69,7 @@ func (s *RatingsService) GetRatings(title, year, mediaType, imdbID string, tmdbI
 
 	// Fallback to RT Algolia if no RT data
 	if result.RottenTomatoes == nil && title != "" {
-		rtResult, err := s.getRTRatings(title, year, mediaType)
+		rtResult, err := s.getRTRatings(ctx, title, year, mediaType)
 		if err == nil && rtResult != nil {
 			if result.RottenTomatoes == nil {
 				result.RottenTomatoes = rtResult.RottenTomatoes
@@ -76,13 +83,25 @@ func (s *RatingsService) GetRatings(title, year, mediaType, imdbID string, tmdbI
 		}
 	}
 
+	// Trakt community score
+	if clientID := s.db.GetSetting("trakt_client_id"); clientID != "" && (imdbID != "" || tmdbID > 0) {
+		if rating, err := s.getTraktRatings(ctx, clientID, imdbID, tmdbID, mediaType); err == nil {
+			result.Trakt = rating
+		}
+	}
+
+	// Lookups cut short by a cancelled request would cache empty ratings
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// Cache the result
 	s.cache.Set(cacheKey, result)
 
 	return result, nil
 }
 
-func (s *RatingsService) getMDBListRatings(apiKey, imdbID string, tmdbID int, mediaType string) (*RatingsResult, error) {
+func (s *RatingsService) getMDBListRatings(ctx context.Context, apiKey, imdbID string, tmdbID int, mediaType string) (*RatingsResult, error) {
 	params := url.Values{}
 	params.Set("apikey", apiKey)
 
@@ -99,7 +118,11 @@ func (s *RatingsService) getMDBListRatings(apiKey, imdbID string, tmdbID int, me
 		return nil, fmt.Errorf("no ID provided")
 	}
 
-	resp, err := s.client.Get("https://mdblist.com/api/?" + params.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://mdblist.com/api/?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +168,7 @@ func (s *RatingsService) getMDBListRatings(apiKey, imdbID string, tmdbID int, me
 	return result, nil
 }
 
-func (s *RatingsService) getRTRatings(title, year, mediaType string) (*RatingsResult, error) {
+func (s *RatingsService) getRTRatings(ctx context.Context, title, year, mediaType string) (*RatingsResult, error) {
 	searchQuery := title
 	if year != "" {
 		searchQuery = title + " " + year
@@ -166,7 +189,7 @@ func (s *RatingsService) getRTRatings(title, year, mediaType string) (*RatingsRe
 
 	jsonData, _ := json.Marshal(payload)
 
-	req, _ := http.NewRequest("POST", fmt.Sprintf("https://%s-dsn.algolia.net/1/indexes/%s/query", rtAlgoliaAppID, rtAlgoliaIndex), bytes.NewBuffer(jsonData))
+	req, _ := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s-dsn.algolia.net/1/indexes/%s/query", rtAlgoliaAppID, rtAlgoliaIndex), bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-algolia-api-key", rtAlgoliaAPIKey)
 	req.Header.Set("x-algolia-application-id", rtAlgoliaAppID)
@@ -232,3 +255,60 @@ func (s *RatingsService) getRTRatings(title, year, mediaType string) (*RatingsRe
 
 	return result, nil
 }
+
+// getTraktRatings returns Trakt's 0-10 community rating. Trakt accepts IMDB
+// ids directly, TMDB ids have to be resolved with a search first.
+func (s *RatingsService) getTraktRatings(ctx context.Context, clientID, imdbID string, tmdbID int, mediaType string) (float64, error) {
+	kind, searchType := "movies", "movie"
+	if mediaType == "tv" || mediaType == "series" {
+		kind, searchType = "shows", "show"
+	}
+
+	id := imdbID
+	if id == "" {
+		var results []map[string]interface{}
+		if err := s.traktGet(ctx, clientID, fmt.Sprintf("/search/tmdb/%d?type=%s", tmdbID, searchType), &results); err != nil {
+			return 0, err
+		}
+		if len(results) == 0 {
+			return 0, fmt.Errorf("Not found on Trakt")
+		}
+		media, _ := results[0][searchType].(map[string]interface{})
+		ids, _ := media["ids"].(map[string]interface{})
+		traktID := getInt(ids, "trakt")
+		if traktID == 0 {
+			return 0, fmt.Errorf("Not found on Trakt")
+		}
+		id = fmt.Sprintf("%d", traktID)
+	}
+
+	var data struct {
+		Rating float64 `json:"rating"`
+	}
+	if err := s.traktGet(ctx, clientID, "/"+kind+"/"+url.PathEscape(id)+"/ratings", &data); err != nil {
+		return 0, err
+	}
+	return data.Rating, nil
+}
+
+func (s *RatingsService) traktGet(ctx context.Context, clientID, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", traktBaseURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("trakt-api-version", "2")
+	req.Header.Set("trakt-api-key", clientID)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Trakt returned %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -2,23 +2,58 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/IcarusCore/Requestarr/internal/cache"
 	"github.com/IcarusCore/Requestarr/internal/models"
 )
 
+// ExistingSeriesCacheKey holds Sonarr's library tvdb ids
+const ExistingSeriesCacheKey = "existing_series"
+
+// SeriesMonitorOptions are the addOptions.monitor values Sonarr accepts
+var SeriesMonitorOptions = []string{"all", "future", "missing", "existing", "firstSeason", "latestSeason", "pilot", "none"}
+
+func IsValidSeriesMonitor(monitor string) bool {
+	for _, option := range SeriesMonitorOptions {
+		if option == monitor {
+			return true
+		}
+	}
+	return false
+}
+
+// SeriesTypes are the series types Sonarr accepts, anime and daily change
+// how episodes are numbered and searched
+var SeriesTypes = []string{"standard", "anime", "daily"}
+
+func IsValidSeriesType(seriesType string) bool {
+	for _, option := range SeriesTypes {
+		if option == seriesType {
+			return true
+		}
+	}
+	return false
+}
+
 type SonarrService struct {
 	db     *models.DB
+	cache  cache.CacheStore
 	client *http.Client
 }
 
-func NewSonarrService(db *models.DB) *SonarrService {
+func NewSonarrService(db *models.DB, cache cache.CacheStore) *SonarrService {
 	return &SonarrService{
-		db: db,
+		db:    db,
+		cache: cache,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
@@ -29,7 +64,12 @@ func (s *SonarrService) getConfig() (string, string) {
 	return s.db.GetSetting("sonarr_url"), s.db.GetSetting("sonarr_api_key")
 }
 
-func (s *SonarrService) request(method, endpoint string, data interface{}) (interface{}, error) {
+func (s *SonarrService) IsConfigured() bool {
+	sonarrURL, apiKey := s.getConfig()
+	return sonarrURL != "" && apiKey != ""
+}
+
+func (s *SonarrService) request(ctx context.Context, method, endpoint string, data interface{}) (interface{}, error) {
 	sonarrURL, apiKey := s.getConfig()
 	if sonarrURL == "" || apiKey == "" {
 		return nil, fmt.Errorf("Sonarr not configured")
@@ -42,10 +82,10 @@ func (s *SonarrService) request(method, endpoint string, data interface{}) (inte
 
 	if data != nil {
 		jsonData, _ := json.Marshal(data)
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+		req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(jsonData))
 		req.Header.Set("Content-Type", "application/json")
 	} else {
-		req, err = http.NewRequest(method, url, nil)
+		req, err = http.NewRequestWithContext(ctx, method, url, nil)
 	}
 
 	if err != nil {
@@ -61,19 +101,23 @@ func (s *SonarrService) request(method, endpoint string, data interface{}) (inte
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, fmt.Errorf("Sonarr returned %d", resp.StatusCode)
+		return nil, newArrError("Sonarr", resp)
 	}
 
 	var result interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// Some endpoints (monitor, delete) reply without a body
+		if err == io.EOF {
+			return nil, nil
+		}
 		return nil, err
 	}
 
 	return result, nil
 }
 
-func (s *SonarrService) Search(term string) ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "series/lookup?term="+term, nil)
+func (s *SonarrService) Search(ctx context.Context, term string) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "series/lookup?term="+url.QueryEscape(term), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +132,8 @@ func (s *SonarrService) Search(term string) ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *SonarrService) GetExisting() ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "series", nil)
+func (s *SonarrService) GetExisting(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "series", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -104,8 +148,8 @@ func (s *SonarrService) GetExisting() ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *SonarrService) GetSeries(id int) (map[string]interface{}, error) {
-	result, err := s.request("GET", fmt.Sprintf("series/%d", id), nil)
+func (s *SonarrService) GetSeries(ctx context.Context, id int) (map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("series/%d", id), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -115,8 +159,177 @@ func (s *SonarrService) GetSeries(id int) (map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *SonarrService) GetRootFolders() ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "rootfolder", nil)
+// FindSeries returns the library series with the given tvdb id, nil when
+// Sonarr doesn't have it
+func (s *SonarrService) FindSeries(ctx context.Context, tvdbID int) (map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("series?tvdbId=%d", tvdbID), nil)
+	if err != nil {
+		return nil, err
+	}
+	if arr, ok := result.([]interface{}); ok && len(arr) > 0 {
+		if m, ok := arr[0].(map[string]interface{}); ok {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+// SeasonAvailability is how much of a season is in the library. Season 0
+// holds a series' specials.
+type SeasonAvailability struct {
+	SeasonNumber  int  `json:"seasonNumber"`
+	Special       bool `json:"special"`
+	Monitored     bool `json:"monitored"`
+	EpisodeFiles  int  `json:"episodeFiles"`
+	TotalEpisodes int  `json:"totalEpisodes"`
+	Available     bool `json:"available"` // every episode has a file
+}
+
+// GetSeasonAvailability returns per-season episode file counts for the
+// library series with the given tvdb id, nil when Sonarr doesn't have it
+func (s *SonarrService) GetSeasonAvailability(ctx context.Context, tvdbID int) ([]SeasonAvailability, error) {
+	series, err := s.FindSeries(ctx, tvdbID)
+	if err != nil || series == nil {
+		return nil, err
+	}
+
+	seasons := []SeasonAvailability{}
+	list, _ := series["seasons"].([]interface{})
+	for _, item := range list {
+		season, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stats, _ := season["statistics"].(map[string]interface{})
+		availability := SeasonAvailability{
+			SeasonNumber:  getInt(season, "seasonNumber"),
+			EpisodeFiles:  getInt(stats, "episodeFileCount"),
+			TotalEpisodes: getInt(stats, "totalEpisodeCount"),
+		}
+		availability.Special = availability.SeasonNumber == 0
+		availability.Monitored, _ = season["monitored"].(bool)
+		availability.Available = availability.TotalEpisodes > 0 && availability.EpisodeFiles >= availability.TotalEpisodes
+		seasons = append(seasons, availability)
+	}
+	return seasons, nil
+}
+
+// MonitorSeasons monitors and searches for the given seasons of a series
+// already in Sonarr, leaving its other seasons as they are
+func (s *SonarrService) MonitorSeasons(ctx context.Context, seriesID int, seasons []int) error {
+	series, err := s.GetSeries(ctx, seriesID)
+	if err != nil {
+		return err
+	}
+	if series == nil {
+		return fmt.Errorf("series not found")
+	}
+
+	selected := make(map[int]bool, len(seasons))
+	for _, season := range seasons {
+		selected[season] = true
+	}
+	if list, ok := series["seasons"].([]interface{}); ok {
+		for _, item := range list {
+			if season, ok := item.(map[string]interface{}); ok && selected[getInt(season, "seasonNumber")] {
+				season["monitored"] = true
+			}
+		}
+	}
+	series["monitored"] = true
+	if _, err := s.request(ctx, "PUT", fmt.Sprintf("series/%d", seriesID), series); err != nil {
+		return err
+	}
+
+	for _, season := range seasons {
+		if _, err := s.request(ctx, "POST", "command", map[string]interface{}{
+			"name":         "SeasonSearch",
+			"seriesId":     seriesID,
+			"seasonNumber": season,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpgradeSeries moves a library series to another quality profile and
+// searches for releases that meet it
+func (s *SonarrService) UpgradeSeries(ctx context.Context, id, qualityProfileID int) error {
+	series, err := s.GetSeries(ctx, id)
+	if err != nil {
+		return err
+	}
+	if series == nil {
+		return fmt.Errorf("series not found")
+	}
+
+	series["qualityProfileId"] = qualityProfileID
+	if _, err := s.request(ctx, "PUT", fmt.Sprintf("series/%d", id), series); err != nil {
+		return err
+	}
+
+	return s.SearchSeries(ctx, id)
+}
+
+// SearchSeries has Sonarr search for releases of a library series'
+// monitored episodes
+func (s *SonarrService) SearchSeries(ctx context.Context, id int) error {
+	_, err := s.request(ctx, "POST", "command", map[string]interface{}{
+		"name":     "SeriesSearch",
+		"seriesId": id,
+	})
+	return err
+}
+
+// CutoffMet reports whether a series has episode files and all of them
+// meet its quality profile's cutoff
+func (s *SonarrService) CutoffMet(ctx context.Context, seriesID int) (bool, error) {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("episodefile?seriesId=%d", seriesID), nil)
+	if err != nil {
+		return false, err
+	}
+
+	files, _ := result.([]interface{})
+	if len(files) == 0 {
+		return false, nil
+	}
+	for _, f := range files {
+		file, _ := f.(map[string]interface{})
+		if notMet, _ := file["qualityCutoffNotMet"].(bool); notMet {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// EpisodeProgress returns how many episodes of a series have files and how
+// many monitored episodes there are
+func (s *SonarrService) EpisodeProgress(ctx context.Context, seriesID int) (int, int, error) {
+	series, err := s.GetSeries(ctx, seriesID)
+	if err != nil || series == nil {
+		return 0, 0, err
+	}
+
+	stats, _ := series["statistics"].(map[string]interface{})
+	return getInt(stats, "episodeFileCount"), getInt(stats, "episodeCount"), nil
+}
+
+// SeriesProgress is the percent of monitored episodes downloaded, at least 1
+// once any file exists so the first episode is only announced once
+func SeriesProgress(files, monitored int) int {
+	if monitored == 0 || files >= monitored {
+		return 100
+	}
+	progress := files * 100 / monitored
+	if progress < 1 && files > 0 {
+		progress = 1
+	}
+	return progress
+}
+
+func (s *SonarrService) GetRootFolders(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "rootfolder", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +344,8 @@ func (s *SonarrService) GetRootFolders() ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *SonarrService) GetQualityProfiles() ([]map[string]interface{}, error) {
-	result, err := s.request("GET", "qualityprofile", nil)
+func (s *SonarrService) GetQualityProfiles(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "qualityprofile", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -147,9 +360,44 @@ func (s *SonarrService) GetQualityProfiles() ([]map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *SonarrService) AddSeries(tvdbID int, rootFolder string, qualityProfileID int, monitor string) (map[string]interface{}, error) {
+// GetLanguageProfiles lists Sonarr's language profiles. Sonarr v4 folded
+// languages into quality profiles and dropped the endpoint, so a 404 yields
+// an empty list.
+func (s *SonarrService) GetLanguageProfiles(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "languageprofile", nil)
+	if errors.Is(err, ErrArrNotFound) {
+		return []map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if arr, ok := result.([]interface{}); ok {
+		items := make([]map[string]interface{}, len(arr))
+		for i, item := range arr {
+			items[i] = item.(map[string]interface{})
+		}
+		return items, nil
+	}
+	return nil, nil
+}
+
+// AddSeries adds a series to Sonarr. When seasons is non-empty only those
+// seasons are monitored, otherwise monitor decides which episodes are. A
+// languageProfileID of 0 leaves Sonarr's default.
+func (s *SonarrService) AddSeries(ctx context.Context, tvdbID int, rootFolder string, qualityProfileID, languageProfileID int, seriesType, monitor string, seasons []int) (map[string]interface{}, error) {
+	return s.addSeries(ctx, tvdbID, rootFolder, qualityProfileID, languageProfileID, seriesType, monitor, seasons, true)
+}
+
+// AddSeriesUnmonitored adds a series without monitoring or searching any
+// episodes, so specific episodes can be monitored afterwards.
+func (s *SonarrService) AddSeriesUnmonitored(ctx context.Context, tvdbID int, rootFolder string, qualityProfileID, languageProfileID int, seriesType string) (map[string]interface{}, error) {
+	return s.addSeries(ctx, tvdbID, rootFolder, qualityProfileID, languageProfileID, seriesType, "none", nil, false)
+}
+
+func (s *SonarrService) addSeries(ctx context.Context, tvdbID int, rootFolder string, qualityProfileID, languageProfileID int, seriesType, monitor string, seasons []int, search bool) (map[string]interface{}, error) {
 	// First lookup the series
-	result, err := s.request("GET", fmt.Sprintf("series/lookup?term=tvdb:%d", tvdbID), nil)
+	result, err := s.request(ctx, "GET", fmt.Sprintf("series/lookup?term=tvdb:%d", tvdbID), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -162,18 +410,53 @@ func (s *SonarrService) AddSeries(tvdbID int, rootFolder string, qualityProfileI
 	seriesData := arr[0].(map[string]interface{})
 	seriesData["rootFolderPath"] = rootFolder
 	seriesData["qualityProfileId"] = qualityProfileID
+	if languageProfileID > 0 {
+		seriesData["languageProfileId"] = languageProfileID
+	}
+	if seriesType != "" {
+		seriesData["seriesType"] = seriesType
+	}
 	seriesData["monitored"] = true
 	seriesData["seasonFolder"] = true
-	seriesData["addOptions"] = map[string]interface{}{
-		"monitor":                     monitor,
-		"searchForMissingEpisodes":    true,
+
+	addOptions := map[string]interface{}{
+		"monitor":                      monitor,
+		"searchForMissingEpisodes":     search,
 		"searchForCutoffUnmetEpisodes": false,
 	}
 
-	addResult, err := s.request("POST", "series", seriesData)
+	if len(seasons) > 0 {
+		selected := make(map[int]bool, len(seasons))
+		for _, season := range seasons {
+			selected[season] = true
+		}
+
+		var seasonList []interface{}
+		if list, ok := seriesData["seasons"].([]interface{}); ok {
+			for _, item := range list {
+				season, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				number, _ := season["seasonNumber"].(float64)
+				season["monitored"] = selected[int(number)]
+				seasonList = append(seasonList, season)
+			}
+		}
+		seriesData["seasons"] = seasonList
+
+		// Without a monitor option Sonarr applies the per-season flags above;
+		// any explicit option (including "none") would override them.
+		delete(addOptions, "monitor")
+	}
+
+	seriesData["addOptions"] = addOptions
+
+	addResult, err := s.request(ctx, "POST", "series", seriesData)
 	if err != nil {
 		return nil, err
 	}
+	s.InvalidateExisting()
 
 	if m, ok := addResult.(map[string]interface{}); ok {
 		return m, nil
@@ -181,22 +464,191 @@ func (s *SonarrService) AddSeries(tvdbID int, rootFolder string, qualityProfileI
 	return nil, nil
 }
 
-func (s *SonarrService) CheckExists(tvdbID int) (bool, error) {
-	existing, err := s.GetExisting()
+func (s *SonarrService) GetEpisodes(ctx context.Context, seriesID int) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("episode?seriesId=%d", seriesID), nil)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	if arr, ok := result.([]interface{}); ok {
+		items := make([]map[string]interface{}, len(arr))
+		for i, item := range arr {
+			items[i] = item.(map[string]interface{})
+		}
+		return items, nil
+	}
+	return nil, nil
+}
+
+// CheckEpisodeSeasons checks that the seasons of the requested episodes
+// exist in the series with tvdbID, before it is added to Sonarr
+func (s *SonarrService) CheckEpisodeSeasons(ctx context.Context, tvdbID int, episodes []models.Episode) error {
+	result, err := s.request(ctx, "GET", fmt.Sprintf("series/lookup?term=tvdb:%d", tvdbID), nil)
+	if err != nil {
+		return err
+	}
+	arr, _ := result.([]interface{})
+	if len(arr) == 0 {
+		return fmt.Errorf("series not found")
+	}
+	series, _ := arr[0].(map[string]interface{})
+	seasonList, _ := series["seasons"].([]interface{})
+
+	seasons := make(map[int]bool, len(seasonList))
+	for _, item := range seasonList {
+		if season, ok := item.(map[string]interface{}); ok {
+			number, _ := season["seasonNumber"].(float64)
+			seasons[int(number)] = true
+		}
+	}
+
+	var missing []string
+	for _, ep := range episodes {
+		if !seasons[ep.Season] {
+			missing = append(missing, fmt.Sprintf("S%02dE%02d", ep.Season, ep.Episode))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("episodes not found in series: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// episodeWait bounds how long MonitorEpisodes waits for Sonarr to load the
+// episodes of a series it just added
+const episodeWait = 10 * time.Second
+
+// waitForEpisodes polls the episodes of a series until Sonarr's refresh after
+// adding it has filled them in, giving up after episodeWait or when ctx ends
+func (s *SonarrService) waitForEpisodes(ctx context.Context, seriesID int) ([]map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, episodeWait)
+	defer cancel()
+
+	delay := 250 * time.Millisecond
+	for {
+		existing, err := s.GetEpisodes(ctx, seriesID)
+		if err == nil && len(existing) > 0 {
+			return existing, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = fmt.Errorf("Sonarr has not loaded the episodes of the series yet")
+			}
+			return nil, err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 2*time.Second)
+	}
+}
+
+// MonitorEpisodes monitors and searches for the given episodes of a series
+// already in Sonarr. Every requested episode must exist in the series.
+func (s *SonarrService) MonitorEpisodes(ctx context.Context, seriesID int, episodes []models.Episode) error {
+	existing, err := s.waitForEpisodes(ctx, seriesID)
+	if err != nil {
+		return err
+	}
+
+	episodeIDs := make(map[models.Episode]int)
+	for _, ep := range existing {
+		season, _ := ep["seasonNumber"].(float64)
+		number, _ := ep["episodeNumber"].(float64)
+		if id, ok := ep["id"].(float64); ok {
+			episodeIDs[models.Episode{Season: int(season), Episode: int(number)}] = int(id)
+		}
+	}
+
+	ids := make([]int, 0, len(episodes))
+	var missing []string
+	for _, ep := range episodes {
+		id, ok := episodeIDs[ep]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("S%02dE%02d", ep.Season, ep.Episode))
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("episodes not found in series: %s", strings.Join(missing, ", "))
+	}
+
+	if _, err := s.request(ctx, "PUT", "episode/monitor", map[string]interface{}{
+		"episodeIds": ids,
+		"monitored":  true,
+	}); err != nil {
+		return err
+	}
+
+	_, err = s.request(ctx, "POST", "command", map[string]interface{}{
+		"name":       "EpisodeSearch",
+		"episodeIds": ids,
+	})
+	return err
+}
+
+func (s *SonarrService) DeleteSeries(ctx context.Context, id int, deleteFiles bool) error {
+	_, err := s.request(ctx, "DELETE", fmt.Sprintf("series/%d?deleteFiles=%t", id, deleteFiles), nil)
+	if err == nil {
+		s.InvalidateExisting()
+	}
+	return err
+}
+
+// GetQueue returns the records currently in Sonarr's download queue
+func (s *SonarrService) GetQueue(ctx context.Context) ([]map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "queue?pageSize=1000", nil)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, series := range existing {
-		if id, ok := series["tvdbId"].(float64); ok && int(id) == tvdbID {
-			return true, nil
+	page, _ := result.(map[string]interface{})
+	records, _ := page["records"].([]interface{})
+	items := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		if m, ok := record.(map[string]interface{}); ok {
+			items = append(items, m)
 		}
 	}
-	return false, nil
+	return items, nil
+}
+
+// ExistingIDs returns the tvdb ids in the library, cached for two minutes
+// and invalidated when series are added or removed
+func (s *SonarrService) ExistingIDs(ctx context.Context) (map[int]bool, error) {
+	if cached, found := s.cache.Get(ExistingSeriesCacheKey); found {
+		return cached.(map[int]bool), nil
+	}
+
+	series, err := s.GetExisting(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]bool)
+	addExistingIDs(ids, series, "tvdbId")
+
+	s.cache.SetWithTTL(ExistingSeriesCacheKey, ids, 2*time.Minute)
+	return ids, nil
+}
+
+// InvalidateExisting drops the cached library ids
+func (s *SonarrService) InvalidateExisting() {
+	s.cache.Delete(ExistingSeriesCacheKey)
+}
+
+func (s *SonarrService) CheckExists(ctx context.Context, tvdbID int) (bool, error) {
+	ids, err := s.ExistingIDs(ctx)
+	if err != nil {
+		return false, err
+	}
+	return ids[tvdbID], nil
 }
 
-func (s *SonarrService) GetStatus() (map[string]interface{}, error) {
-	result, err := s.request("GET", "system/status", nil)
+func (s *SonarrService) GetStatus(ctx context.Context) (map[string]interface{}, error) {
+	result, err := s.request(ctx, "GET", "system/status", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -206,8 +658,8 @@ func (s *SonarrService) GetStatus() (map[string]interface{}, error) {
 	return nil, nil
 }
 
-func (s *SonarrService) TestConnection(url, apiKey string) (map[string]interface{}, error) {
-	req, _ := http.NewRequest("GET", strings.TrimRight(url, "/")+"/api/v3/system/status", nil)
+func (s *SonarrService) TestConnection(ctx context.Context, url, apiKey string) (map[string]interface{}, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(url, "/")+"/api/v3/system/status", nil)
 	req.Header.Set("X-Api-Key", apiKey)
 
 	resp, err := s.client.Do(req)
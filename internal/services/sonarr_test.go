@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IcarusCore/Requestarr/internal/cache"
+	"github.com/IcarusCore/Requestarr/internal/models"
+)
+
+// searchTerms have characters that break a query string unless escaped
+var searchTerms = []string{"Fast & Furious", "Spider-Man: No Way Home", "100% Wolf", "What If...?", "Love, Death + Robots", "#Alive"}
+
+// newTestDB returns a fresh database with the given settings
+func newTestDB(t *testing.T, settings map[string]string) *models.DB {
+	t.Helper()
+
+	db, err := models.InitDB(filepath.Join(t.TempDir(), "requestarr.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for key, value := range settings {
+		db.SetSetting(key, value)
+	}
+	return db
+}
+
+// lookupServer answers arr lookups with an empty list, sending each term it
+// receives on terms
+func lookupServer(t *testing.T, path string, terms chan<- string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("path = %s, want %s", r.URL.Path, path)
+		}
+		terms <- r.URL.Query().Get("term")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSonarrSearchEscapesTerm(t *testing.T) {
+	terms := make(chan string, 1)
+	server := lookupServer(t, "/api/v3/series/lookup", terms)
+	db := newTestDB(t, map[string]string{"sonarr_url": server.URL, "sonarr_api_key": "test"})
+	sonarr := NewSonarrService(db, cache.NewCache(time.Minute, 0, ""))
+
+	for _, term := range searchTerms {
+		if _, err := sonarr.Search(context.Background(), term); err != nil {
+			t.Fatalf("Search(%q): %v", term, err)
+		}
+		if got := <-terms; got != term {
+			t.Errorf("Sonarr got term %q, want %q", got, term)
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,12 +20,68 @@ import (
 const (
 	tmdbBaseURL  = "https://api.themoviedb.org/3"
 	tmdbImageURL = "https://image.tmdb.org/t/p"
+
+	tmdbAnimationGenre = 16
+
+	tmdbMaxRetries   = 3
+	tmdbMaxRetryWait = 10 * time.Second
+
+	// DefaultTMDBConcurrency limits parallel detail requests to stay under
+	// TMDB's rate limit on cold caches
+	DefaultTMDBConcurrency = 5
+
+	// Discovery defaults, each overridable with a discover_* setting
+	DefaultDiscoverLanguage      = "en"
+	DefaultDiscoverMinVotesMovie = 100
+	DefaultDiscoverMinVotesTV    = 50
+
+	// AnyLanguage as a discover language turns off the original language filter
+	AnyLanguage = "any"
+
+	// DefaultTMDBLanguage is the language of titles and overviews when
+	// tmdb_language is unset
+	DefaultTMDBLanguage = "en-US"
 )
 
+var (
+	languageCode     = regexp.MustCompile(`^[a-z]{2}$`)
+	regionCode       = regexp.MustCompile(`^[A-Z]{2}$`)
+	metadataLanguage = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+)
+
+// IsValidMetadataLanguage reports whether language is a TMDB language such
+// as "de" or "pt-BR"
+func IsValidMetadataLanguage(language string) bool {
+	return metadataLanguage.MatchString(language)
+}
+
+// IsValidLanguage reports whether language is an ISO 639-1 code such as
+// "en", or AnyLanguage
+func IsValidLanguage(language string) bool {
+	return language == AnyLanguage || languageCode.MatchString(language)
+}
+
+// IsValidRegion reports whether region is an ISO 3166-1 code such as "US"
+func IsValidRegion(region string) bool {
+	return regionCode.MatchString(region)
+}
+
+// RateLimitError is returned when TMDB is still rate limiting after retries
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "TMDB rate limit exceeded"
+}
+
 type TMDBService struct {
-	db     *models.DB
-	cache  *cache.Cache
-	client *http.Client
+	db       *models.DB
+	cache    cache.CacheStore
+	client   *http.Client
+	sonarr   *SonarrService
+	radarr   *RadarrService
+	radarr4k *RadarrService
 }
 
 type TMDBDiscoverResult struct {
@@ -29,12 +90,28 @@ type TMDBDiscoverResult struct {
 	TotalPages int                      `json:"total_pages"`
 }
 
+// DiscoverOptions are the filters applied to a discover query
+type DiscoverOptions struct {
+	SortBy        string
+	Year          string
+	FromYear      int // 0 for no lower bound
+	ToYear        int // 0 for no upper bound
+	MinRating     float64
+	MinRuntime    int    // minutes, per episode for series
+	MaxRuntime    int    // 0 for no limit
+	MinVotes      int    // 0 for the default for the sort order
+	Language      string // original language, "" for the discover_language setting
+	Region        string // release date region, "" for the discover_region setting
+	WithProviders string // pipe separated TMDB provider ids
+	WatchRegion   string
+}
+
 type MediaItem struct {
 	TmdbID        int     `json:"tmdbId,omitempty"`
 	TvdbID        int     `json:"tvdbId,omitempty"`
 	ImdbID        string  `json:"imdbId,omitempty"`
 	Title         string  `json:"title"`
-	Year          string  `json:"year,omitempty"`
+	Year          int     `json:"year,omitempty"` // 0 when unknown, e.g. not aired yet
 	Overview      string  `json:"overview,omitempty"`
 	Rating        float64 `json:"rating,omitempty"`
 	VoteCount     int     `json:"voteCount,omitempty"`
@@ -44,23 +121,52 @@ type MediaItem struct {
 	Runtime       int     `json:"runtime,omitempty"`
 	RequestStatus string  `json:"requestStatus"`
 	Source        string  `json:"source"`
+	// Set by sources that mix movies and series, "movie" or "series"
+	MediaType string `json:"mediaType,omitempty"`
+	// Set when external ids couldn't be fetched, leaving the item unrequestable
+	EnrichmentFailed bool `json:"enrichmentFailed,omitempty"`
 }
 
-func NewTMDBService(db *models.DB, cache *cache.Cache) *TMDBService {
+// NewTMDBService creates the TMDB client; the arr services are used to mark
+// results already in the library
+func NewTMDBService(db *models.DB, cache cache.CacheStore, sonarr *SonarrService, radarr, radarr4k *RadarrService) *TMDBService {
 	return &TMDBService{
 		db:    db,
 		cache: cache,
 		client: &http.Client{
 			Timeout: 15 * time.Second,
 		},
+		sonarr:   sonarr,
+		radarr:   radarr,
+		radarr4k: radarr4k,
+	}
+}
+
+// concurrency is how many detail requests a page of results may have in
+// flight at once, from the tmdb_concurrency setting
+func (s *TMDBService) concurrency() int {
+	n := s.db.GetSettingInt("tmdb_concurrency", DefaultTMDBConcurrency)
+	if n < 1 {
+		return 1
 	}
+	return n
 }
 
 func (s *TMDBService) getAPIKey() string {
 	return s.db.GetSetting("tmdb_api_key")
 }
 
-func (s *TMDBService) request(endpoint string, params map[string]string) (map[string]interface{}, error) {
+// Language is the language TMDB returns titles and overviews in
+func (s *TMDBService) Language() string {
+	if language := s.db.GetSetting("tmdb_language"); language != "" {
+		return language
+	}
+	return DefaultTMDBLanguage
+}
+
+// request fetches a TMDB endpoint in Language, unless params asks for a
+// language of its own. Cache keys for the results must include the language.
+func (s *TMDBService) request(ctx context.Context, endpoint string, params map[string]string) (map[string]interface{}, error) {
 	apiKey := s.getAPIKey()
 	if apiKey == "" {
 		return nil, fmt.Errorf("TMDB API key not configured")
@@ -69,12 +175,13 @@ func (s *TMDBService) request(endpoint string, params map[string]string) (map[st
 	u, _ := url.Parse(tmdbBaseURL + "/" + endpoint)
 	q := u.Query()
 	q.Set("api_key", apiKey)
+	q.Set("language", s.Language())
 	for k, v := range params {
 		q.Set(k, v)
 	}
 	u.RawQuery = q.Encode()
 
-	resp, err := s.client.Get(u.String())
+	resp, err := s.get(ctx, u.String())
 	if err != nil {
 		return nil, err
 	}
@@ -92,311 +199,999 @@ func (s *TMDBService) request(endpoint string, params map[string]string) (map[st
 	return result, nil
 }
 
-func (s *TMDBService) DiscoverMovies(page int, sortBy string, year string) ([]MediaItem, int, error) {
-	params := map[string]string{
-		"page":                   fmt.Sprintf("%d", page),
-		"sort_by":                sortBy,
-		"include_adult":          "false",
-		"include_video":          "false",
-		"with_original_language": "en",
-		"region":                 "US",
-		"vote_count.gte":         "100",
+// get fetches a TMDB URL, waiting and retrying when rate limited. Once the
+// retries run out it returns a *RateLimitError.
+func (s *TMDBService) get(ctx context.Context, u string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		if attempt >= tmdbMaxRetries {
+			return nil, &RateLimitError{RetryAfter: wait}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryAfter reads a Retry-After header in seconds or as a date, falling
+// back to exponential backoff, and caps the wait
+func retryAfter(header string, attempt int) time.Duration {
+	wait := time.Second << attempt
+	if seconds, err := strconv.Atoi(header); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		wait = time.Until(date)
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > tmdbMaxRetryWait {
+		wait = tmdbMaxRetryWait
 	}
+	return wait
+}
 
-	if sortBy == "vote_average.desc" {
-		params["vote_count.gte"] = "500"
+func (s *TMDBService) DiscoverMovies(ctx context.Context, page int, opts DiscoverOptions) ([]MediaItem, int, error) {
+	params := map[string]string{
+		"page":           fmt.Sprintf("%d", page),
+		"sort_by":        opts.SortBy,
+		"include_adult":  "false",
+		"include_video":  "false",
+		"region":         s.discoverRegion(opts),
+		"vote_count.gte": strconv.Itoa(s.discoverMinVotes("discover_min_votes_movie", DefaultDiscoverMinVotesMovie, 5, opts)),
 	}
+	s.setLanguageParam(params, opts)
 
-	if year != "" {
-		params["primary_release_year"] = year
+	if opts.Year != "" {
+		params["primary_release_year"] = opts.Year
 	}
+	setDateRange(params, "primary_release_date", opts)
+	setFilterParams(params, opts)
+
+	s.setProviderParams(params, opts)
 
-	data, err := s.request("discover/movie", params)
+	data, err := s.request(ctx, "discover/movie", params)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	results, _ := data["results"].([]interface{})
-	totalPages := int(data["total_pages"].(float64))
-	if totalPages > 500 {
-		totalPages = 500
+	totalPages, err := discoverTotalPages(data)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Get existing and requested IDs
-	existingIDs, _ := s.getExistingMovieIDs()
-	requestedIDs, _ := s.db.GetRequestedIDs("movie")
+	return s.movieItems(ctx, results), totalPages, nil
+}
 
-	// Process results in parallel to fetch external IDs
-	items := make([]MediaItem, len(results))
-	var wg sync.WaitGroup
+// discoverTotalPages reads total_pages of a discover reply, capped at the
+// 500 pages TMDB will serve
+func discoverTotalPages(data map[string]interface{}) (int, error) {
+	total, ok := data["total_pages"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("TMDB response is missing total_pages")
+	}
+	if total > 500 {
+		return 500, nil
+	}
+	return int(total), nil
+}
 
-	for i, r := range results {
-		wg.Add(1)
-		go func(idx int, movie map[string]interface{}) {
-			defer wg.Done()
+func (s *TMDBService) DiscoverTV(ctx context.Context, page int, opts DiscoverOptions) ([]MediaItem, int, error) {
+	params := map[string]string{
+		"page":                         fmt.Sprintf("%d", page),
+		"sort_by":                      opts.SortBy,
+		"include_null_first_air_dates": "false",
+		"vote_count.gte":               strconv.Itoa(s.discoverMinVotes("discover_min_votes_tv", DefaultDiscoverMinVotesTV, 4, opts)),
+	}
+	s.setLanguageParam(params, opts)
 
-			tmdbID := int(movie["id"].(float64))
-			
-			// Check cache first for external IDs
-			cacheKey := fmt.Sprintf("tmdb_movie_%d", tmdbID)
-			var imdbID string
-			
-			if cached, found := s.cache.Get(cacheKey); found {
-				imdbID = cached.(string)
-			} else {
-				// Fetch details to get IMDB ID
-				details, err := s.request(fmt.Sprintf("movie/%d", tmdbID), map[string]string{"append_to_response": "external_ids"})
-				if err == nil {
-					if extIDs, ok := details["external_ids"].(map[string]interface{}); ok {
-						if id, ok := extIDs["imdb_id"].(string); ok {
-							imdbID = id
-						}
-					}
-					if imdbID == "" {
-						if id, ok := details["imdb_id"].(string); ok {
-							imdbID = id
-						}
-					}
-					s.cache.Set(cacheKey, imdbID)
-				}
-			}
+	if opts.Year != "" {
+		params["first_air_date_year"] = opts.Year
+	}
+	setDateRange(params, "first_air_date", opts)
+	setFilterParams(params, opts)
 
-			status := "available"
-			if existingIDs[tmdbID] {
-				status = "exists"
-			} else if requestedIDs[tmdbID] {
-				status = "requested"
-			}
+	s.setProviderParams(params, opts)
 
-			var posterPath, backdropPath string
-			if p, ok := movie["poster_path"].(string); ok {
-				posterPath = tmdbImageURL + "/w500" + p
-			}
-			if b, ok := movie["backdrop_path"].(string); ok {
-				backdropPath = tmdbImageURL + "/original" + b
-			}
+	data, err := s.request(ctx, "discover/tv", params)
+	if err != nil {
+		return nil, 0, err
+	}
 
-			year := ""
-			if rd, ok := movie["release_date"].(string); ok && len(rd) >= 4 {
-				year = rd[:4]
-			}
+	results, _ := data["results"].([]interface{})
+	totalPages, err := discoverTotalPages(data)
+	if err != nil {
+		return nil, 0, err
+	}
 
-			rating := 0.0
-			if r, ok := movie["vote_average"].(float64); ok {
-				rating = r
-			}
+	return s.tvItems(ctx, results), totalPages, nil
+}
 
-			items[idx] = MediaItem{
-				TmdbID:        tmdbID,
-				ImdbID:        imdbID,
-				Title:         getString(movie, "title"),
-				Year:          year,
-				Overview:      getString(movie, "overview"),
-				Rating:        rating,
-				VoteCount:     getInt(movie, "vote_count"),
-				Poster:        posterPath,
-				Fanart:        backdropPath,
-				RequestStatus: status,
-				Source:        "tmdb",
-			}
-		}(i, r.(map[string]interface{}))
+// Region is the configured TMDB region, used for release dates and watch providers
+func (s *TMDBService) Region() string {
+	if region := s.db.GetSetting("tmdb_region"); region != "" {
+		return region
 	}
+	return "US"
+}
 
-	wg.Wait()
-	return items, totalPages, nil
+// discoverRegion is the region whose release dates discover uses: the
+// request's, then discover_region, then tmdb_region
+func (s *TMDBService) discoverRegion(opts DiscoverOptions) string {
+	if opts.Region != "" {
+		return opts.Region
+	}
+	if region := s.db.GetSetting("discover_region"); region != "" {
+		return region
+	}
+	return s.Region()
 }
 
-func (s *TMDBService) DiscoverTV(page int, sortBy string, year string) ([]MediaItem, int, error) {
-	params := map[string]string{
-		"page":                         fmt.Sprintf("%d", page),
-		"sort_by":                      sortBy,
-		"include_null_first_air_dates": "false",
-		"with_original_language":       "en",
-		"vote_count.gte":               "50",
+// setLanguageParam limits discover results to titles originally in the
+// request's language or discover_language, unless that's AnyLanguage
+func (s *TMDBService) setLanguageParam(params map[string]string, opts DiscoverOptions) {
+	language := opts.Language
+	if language == "" {
+		language = s.db.GetSetting("discover_language")
 	}
+	if language == "" {
+		language = DefaultDiscoverLanguage
+	}
+	if language != AnyLanguage {
+		params["with_original_language"] = language
+	}
+}
 
-	if sortBy == "vote_average.desc" {
-		params["vote_count.gte"] = "200"
+// discoverMinVotes is the vote count floor from setting. Sorting by rating
+// multiplies it by ratingFactor so titles with a handful of votes can't top
+// the list.
+func (s *TMDBService) discoverMinVotes(setting string, defaultValue, ratingFactor int, opts DiscoverOptions) int {
+	minVotes := s.db.GetSettingInt(setting, defaultValue)
+	if opts.SortBy == "vote_average.desc" {
+		minVotes *= ratingFactor
 	}
+	return minVotes
+}
 
-	if year != "" {
-		params["first_air_date_year"] = year
+// setDateRange limits discover results to titles whose date field falls
+// from the start of FromYear through the end of ToYear
+func setDateRange(params map[string]string, field string, opts DiscoverOptions) {
+	if opts.FromYear > 0 {
+		params[field+".gte"] = fmt.Sprintf("%d-01-01", opts.FromYear)
+	}
+	if opts.ToYear > 0 {
+		params[field+".lte"] = fmt.Sprintf("%d-12-31", opts.ToYear)
 	}
+}
 
-	data, err := s.request("discover/tv", params)
+// setFilterParams applies the rating, runtime and vote count filters
+func setFilterParams(params map[string]string, opts DiscoverOptions) {
+	if opts.MinRating > 0 {
+		params["vote_average.gte"] = strconv.FormatFloat(opts.MinRating, 'f', -1, 64)
+	}
+	if opts.MinRuntime > 0 {
+		params["with_runtime.gte"] = strconv.Itoa(opts.MinRuntime)
+	}
+	if opts.MaxRuntime > 0 {
+		params["with_runtime.lte"] = strconv.Itoa(opts.MaxRuntime)
+	}
+	if opts.MinVotes > 0 {
+		params["vote_count.gte"] = strconv.Itoa(opts.MinVotes)
+	}
+}
+
+func (s *TMDBService) setProviderParams(params map[string]string, opts DiscoverOptions) {
+	if opts.WithProviders == "" {
+		return
+	}
+	params["with_watch_providers"] = opts.WithProviders
+	params["watch_region"] = opts.WatchRegion
+	if opts.WatchRegion == "" {
+		params["watch_region"] = s.Region()
+	}
+}
+
+// WatchProviders lists the streaming providers TMDB knows for a region
+func (s *TMDBService) WatchProviders(ctx context.Context, mediaType, region string) ([]map[string]interface{}, error) {
+	cacheKey := fmt.Sprintf("tmdb_watch_providers_%s_%s_%s", mediaType, region, s.Language())
+	if cached, found := s.cache.Get(cacheKey); found {
+		return cached.([]map[string]interface{}), nil
+	}
+
+	data, err := s.request(ctx, "watch/providers/"+mediaType, map[string]string{"watch_region": region})
+	if err != nil {
+		return nil, err
+	}
+
+	results, _ := data["results"].([]interface{})
+	providers := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		p, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		provider := map[string]interface{}{
+			"id":   getInt(p, "provider_id"),
+			"name": getString(p, "provider_name"),
+		}
+		if logo := getString(p, "logo_path"); logo != "" {
+			provider["logo"] = tmdbImageURL + "/w92" + logo
+		}
+		if priorities, ok := p["display_priorities"].(map[string]interface{}); ok {
+			provider["priority"] = getInt(priorities, region)
+		}
+		providers = append(providers, provider)
+	}
+
+	s.cache.SetWithTTL(cacheKey, providers, 24*time.Hour)
+	return providers, nil
+}
+
+// Trending returns TMDB's trending movies or tv ("movie" or "tv") for a
+// "day" or "week" window
+func (s *TMDBService) Trending(ctx context.Context, mediaType string, window string) ([]MediaItem, int, error) {
+	data, err := s.request(ctx, fmt.Sprintf("trending/%s/%s", mediaType, window), map[string]string{})
 	if err != nil {
 		return nil, 0, err
 	}
 
 	results, _ := data["results"].([]interface{})
-	totalPages := int(data["total_pages"].(float64))
-	if totalPages > 500 {
-		totalPages = 500
+	totalPages := getInt(data, "total_pages")
+
+	if mediaType == "tv" {
+		return s.tvItems(ctx, results), totalPages, nil
 	}
+	return s.movieItems(ctx, results), totalPages, nil
+}
 
-	// Get existing and requested IDs
-	existingIDs, _ := s.getExistingSeriesIDs()
-	requestedIDs, _ := s.db.GetRequestedIDs("series")
+// Recommendations returns titles TMDB recommends for a movie or tv show
+func (s *TMDBService) Recommendations(ctx context.Context, mediaType string, tmdbID int) ([]MediaItem, error) {
+	// Cache the raw results so request status stays current
+	cacheKey := fmt.Sprintf("tmdb_recommendations_%s_%d_%s", mediaType, tmdbID, s.Language())
+	var results []interface{}
+	if cached, found := s.cache.Get(cacheKey); found {
+		results = cached.([]interface{})
+	} else {
+		data, err := s.request(ctx, fmt.Sprintf("%s/%d/recommendations", mediaType, tmdbID), map[string]string{})
+		if err != nil {
+			return nil, err
+		}
+		results, _ = data["results"].([]interface{})
+		s.cache.Set(cacheKey, results)
+	}
 
-	// Process results in parallel to fetch external IDs
-	items := make([]MediaItem, len(results))
-	var wg sync.WaitGroup
+	if mediaType == "tv" {
+		return s.tvItems(ctx, results), nil
+	}
+	return s.movieItems(ctx, results), nil
+}
 
-	for i, r := range results {
-		wg.Add(1)
-		go func(idx int, show map[string]interface{}) {
-			defer wg.Done()
+// maxDetailCast is how many cast members a detail lists
+const maxDetailCast = 20
 
-			tmdbID := int(show["id"].(float64))
-			
-			// Check cache first for external IDs
-			cacheKey := fmt.Sprintf("tmdb_tv_%d", tmdbID)
-			var tvdbID int
-			var imdbID string
-			
-			if cached, found := s.cache.Get(cacheKey); found {
-				if ids, ok := cached.(map[string]interface{}); ok {
-					tvdbID = int(ids["tvdb"].(float64))
-					imdbID, _ = ids["imdb"].(string)
-				}
-			} else {
-				// Fetch details to get TVDB ID
-				details, err := s.request(fmt.Sprintf("tv/%d", tmdbID), map[string]string{"append_to_response": "external_ids"})
-				if err == nil {
-					if extIDs, ok := details["external_ids"].(map[string]interface{}); ok {
-						if id, ok := extIDs["tvdb_id"].(float64); ok {
-							tvdbID = int(id)
-						}
-						if id, ok := extIDs["imdb_id"].(string); ok {
-							imdbID = id
-						}
+// MediaDetail is a movie or tv show with everything a detail page shows
+type MediaDetail struct {
+	MediaItem
+	Tagline         string         `json:"tagline,omitempty"`
+	Genres          []string       `json:"genres"`
+	Status          string         `json:"status,omitempty"` // e.g. "Released" or "Ended"
+	Seasons         int            `json:"seasons,omitempty"`
+	TrailerKey      string         `json:"trailerKey,omitempty"` // YouTube video key
+	Cast            []CastMember   `json:"cast"`
+	Recommendations []MediaItem    `json:"recommendations"`
+	Ratings         *RatingsResult `json:"ratings,omitempty"`
+}
+
+// CastMember is an actor in a title
+type CastMember struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Character string `json:"character,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+}
+
+// GetDetail fetches a movie or tv show (mediaType "movie" or "tv") with its
+// credits, videos, external ids and recommendations in a single request
+func (s *TMDBService) GetDetail(ctx context.Context, mediaType string, tmdbID int) (*MediaDetail, error) {
+	// Only TMDB's answer is cached, the title's library and request status
+	// are looked up fresh on every call
+	cacheKey := fmt.Sprintf("tmdb_detail_%s_%d_%s", mediaType, tmdbID, s.Language())
+	var data map[string]interface{}
+	if cached, found := s.cache.Get(cacheKey); found {
+		data = cached.(map[string]interface{})
+	} else {
+		var err error
+		data, err = s.request(ctx, fmt.Sprintf("%s/%d", mediaType, tmdbID), map[string]string{
+			"append_to_response":     "credits,videos,external_ids,recommendations",
+			"include_video_language": videoLanguages(s.Language()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.cache.Set(cacheKey, data)
+	}
+
+	// The appended ids save movieItems and tvItems looking them up. Without
+	// them nothing is cached, so a lookup still fetches the real ids.
+	if external, ok := data["external_ids"].(map[string]interface{}); ok {
+		s.cacheExternalIDs(tmdbID, mediaType, ExternalIDs{
+			TvdbID: getInt(external, "tvdb_id"),
+			ImdbID: getString(external, "imdb_id"),
+		})
+	}
+
+	recommendations, _ := data["recommendations"].(map[string]interface{})
+	recommended, _ := recommendations["results"].([]interface{})
+
+	var items, related []MediaItem
+	if mediaType == "tv" {
+		items, related = s.tvItems(ctx, []interface{}{data}), s.tvItems(ctx, recommended)
+	} else {
+		items, related = s.movieItems(ctx, []interface{}{data}), s.movieItems(ctx, recommended)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("TMDB returned no details for %s %d", mediaType, tmdbID)
+	}
+
+	detail := &MediaDetail{
+		MediaItem:       items[0],
+		Tagline:         getString(data, "tagline"),
+		Genres:          []string{},
+		Status:          getString(data, "status"),
+		TrailerKey:      trailerKey(data),
+		Cast:            []CastMember{},
+		Recommendations: related,
+	}
+	detail.MediaType = "movie"
+	detail.Runtime = getInt(data, "runtime")
+	if mediaType == "tv" {
+		detail.MediaType = "series"
+		detail.Seasons = getInt(data, "number_of_seasons")
+		if runtimes, ok := data["episode_run_time"].([]interface{}); ok && len(runtimes) > 0 {
+			runtime, _ := runtimes[0].(float64)
+			detail.Runtime = int(runtime)
+		}
+		if networks, ok := data["networks"].([]interface{}); ok && len(networks) > 0 {
+			network, _ := networks[0].(map[string]interface{})
+			detail.Network = getString(network, "name")
+		}
+	}
+
+	genres, _ := data["genres"].([]interface{})
+	for _, g := range genres {
+		if genre, ok := g.(map[string]interface{}); ok {
+			detail.Genres = append(detail.Genres, getString(genre, "name"))
+		}
+	}
+
+	credits, _ := data["credits"].(map[string]interface{})
+	cast, _ := credits["cast"].([]interface{})
+	for _, c := range cast {
+		member, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		castMember := CastMember{
+			ID:        getInt(member, "id"),
+			Name:      getString(member, "name"),
+			Character: getString(member, "character"),
+		}
+		if p, ok := member["profile_path"].(string); ok {
+			castMember.Profile = tmdbImageURL + "/w185" + p
+		}
+		detail.Cast = append(detail.Cast, castMember)
+		if len(detail.Cast) == maxDetailCast {
+			break
+		}
+	}
+
+	return detail, nil
+}
+
+// videoLanguages lists the video languages to append to a detail response.
+// Videos otherwise only come in the metadata language, and most titles have
+// no trailer in languages other than English.
+func videoLanguages(language string) string {
+	language, _, _ = strings.Cut(language, "-")
+	if language == "en" {
+		return "en,null"
+	}
+	return language + ",en,null"
+}
+
+// trailerKey picks the YouTube key of a title's trailer, preferring official
+// trailers and falling back to teasers
+func trailerKey(data map[string]interface{}) string {
+	videos, _ := data["videos"].(map[string]interface{})
+	results, _ := videos["results"].([]interface{})
+
+	best, bestScore := "", 0
+	for _, v := range results {
+		video, ok := v.(map[string]interface{})
+		if !ok || getString(video, "site") != "YouTube" || getString(video, "key") == "" {
+			continue
+		}
+		score := 0
+		switch getString(video, "type") {
+		case "Trailer":
+			score = 4
+		case "Teaser":
+			score = 2
+		default:
+			continue
+		}
+		if official, _ := video["official"].(bool); official {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = getString(video, "key"), score
+		}
+	}
+	return best
+}
+
+// Collection is a TMDB movie collection, e.g. a franchise
+type Collection struct {
+	ID       int         `json:"id"`
+	Name     string      `json:"name"`
+	Overview string      `json:"overview,omitempty"`
+	Poster   string      `json:"poster,omitempty"`
+	Fanart   string      `json:"fanart,omitempty"`
+	Parts    []MediaItem `json:"parts"`
+}
+
+// GetCollection returns a collection with its movies in release order,
+// unreleased ones last
+func (s *TMDBService) GetCollection(ctx context.Context, collectionID int) (*Collection, error) {
+	// Parts are marked requested or in the library per call, so what gets
+	// cached is the collection as TMDB sent it
+	cacheKey := fmt.Sprintf("tmdb_collection_%d_%s", collectionID, s.Language())
+	var data map[string]interface{}
+	if cached, found := s.cache.Get(cacheKey); found {
+		data = cached.(map[string]interface{})
+	} else {
+		var err error
+		data, err = s.request(ctx, fmt.Sprintf("collection/%d", collectionID), map[string]string{})
+		if err != nil {
+			return nil, err
+		}
+		s.cache.Set(cacheKey, data)
+	}
+
+	collection := &Collection{
+		ID:       collectionID,
+		Name:     getString(data, "name"),
+		Overview: getString(data, "overview"),
+	}
+	if p, ok := data["poster_path"].(string); ok {
+		collection.Poster = tmdbImageURL + "/w500" + p
+	}
+	if b, ok := data["backdrop_path"].(string); ok {
+		collection.Fanart = tmdbImageURL + "/original" + b
+	}
+
+	// Sort a copy, the cached response is shared
+	parts, _ := data["parts"].([]interface{})
+	parts = append([]interface{}(nil), parts...)
+	releaseDate := func(part interface{}) string {
+		m, _ := part.(map[string]interface{})
+		return getString(m, "release_date")
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		a, b := releaseDate(parts[i]), releaseDate(parts[j])
+		if a == "" || b == "" {
+			return b == "" && a != ""
+		}
+		return a < b
+	})
+	collection.Parts = s.movieItems(ctx, parts)
+	return collection, nil
+}
+
+// SearchPerson finds actors, directors and other crew by name
+func (s *TMDBService) SearchPerson(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	data, err := s.request(ctx, "search/person", map[string]string{"query": query, "include_adult": "false"})
+	if err != nil {
+		return nil, err
+	}
+
+	results, _ := data["results"].([]interface{})
+	people := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		p, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		var knownFor []string
+		if works, ok := p["known_for"].([]interface{}); ok {
+			for _, w := range works {
+				if work, ok := w.(map[string]interface{}); ok {
+					if title := getString(work, "title"); title != "" {
+						knownFor = append(knownFor, title)
+					} else if name := getString(work, "name"); name != "" {
+						knownFor = append(knownFor, name)
 					}
-					s.cache.Set(cacheKey, map[string]interface{}{"tvdb": float64(tvdbID), "imdb": imdbID})
 				}
 			}
+		}
 
-			status := "available"
-			if tvdbID > 0 && existingIDs[tvdbID] {
-				status = "exists"
-			} else if tvdbID > 0 && requestedIDs[tvdbID] {
-				status = "requested"
-			}
+		person := map[string]interface{}{
+			"id":         getInt(p, "id"),
+			"name":       getString(p, "name"),
+			"department": getString(p, "known_for_department"),
+			"knownFor":   knownFor,
+		}
+		if profile := getString(p, "profile_path"); profile != "" {
+			person["profile"] = tmdbImageURL + "/w185" + profile
+		}
+		people = append(people, person)
+	}
 
-			var posterPath, backdropPath string
-			if p, ok := show["poster_path"].(string); ok {
-				posterPath = tmdbImageURL + "/w500" + p
-			}
-			if b, ok := show["backdrop_path"].(string); ok {
-				backdropPath = tmdbImageURL + "/original" + b
-			}
+	return people, nil
+}
+
+// PersonCredits returns a person's movies and tv shows, as cast or crew.
+// mediaType limits it to "movie" or "tv", empty returns both.
+func (s *TMDBService) PersonCredits(ctx context.Context, personID int, mediaType string) ([]MediaItem, error) {
+	data, err := s.request(ctx, fmt.Sprintf("person/%d/combined_credits", personID), map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+
+	var credits []interface{}
+	if cast, ok := data["cast"].([]interface{}); ok {
+		credits = append(credits, cast...)
+	}
+	if crew, ok := data["crew"].([]interface{}); ok {
+		credits = append(credits, crew...)
+	}
+
+	// Someone can be credited several times on the same title
+	seen := make(map[string]bool)
+	var movies, shows []interface{}
+	for _, c := range credits {
+		credit, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		creditType := getString(credit, "media_type")
+		if mediaType != "" && creditType != mediaType {
+			continue
+		}
+		key := fmt.Sprintf("%s_%d", creditType, getInt(credit, "id"))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		switch creditType {
+		case "movie":
+			movies = append(movies, credit)
+		case "tv":
+			shows = append(shows, credit)
+		}
+	}
 
-			year := ""
-			if rd, ok := show["first_air_date"].(string); ok && len(rd) >= 4 {
-				year = rd[:4]
+	items := s.movieItems(ctx, movies)
+	for i := range items {
+		items[i].MediaType = "movie"
+	}
+	for _, item := range s.tvItems(ctx, shows) {
+		item.MediaType = "series"
+		items = append(items, item)
+	}
+
+	// Newest first, undated titles (usually announced projects) last
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Year > items[j].Year
+	})
+
+	return items, nil
+}
+
+// IsAnime reports whether a TMDB tv show is Japanese animation, which Sonarr
+// needs to treat as the anime series type
+func (s *TMDBService) IsAnime(ctx context.Context, tmdbID int) (bool, error) {
+	cacheKey := fmt.Sprintf("tmdb_tv_anime_%d", tmdbID)
+	if cached, found := s.cache.Get(cacheKey); found {
+		return cached.(bool), nil
+	}
+
+	details, err := s.request(ctx, fmt.Sprintf("tv/%d", tmdbID), map[string]string{})
+	if err != nil {
+		return false, err
+	}
+
+	animation := false
+	if genres, ok := details["genres"].([]interface{}); ok {
+		for _, g := range genres {
+			if genre, ok := g.(map[string]interface{}); ok && getInt(genre, "id") == tmdbAnimationGenre {
+				animation = true
 			}
+		}
+	}
 
-			rating := 0.0
-			if r, ok := show["vote_average"].(float64); ok {
-				rating = r
+	japanese := getString(details, "original_language") == "ja"
+	if countries, ok := details["origin_country"].([]interface{}); ok {
+		for _, c := range countries {
+			if c == "JP" {
+				japanese = true
 			}
+		}
+	}
+
+	anime := animation && japanese
+	s.cache.Set(cacheKey, anime)
+	return anime, nil
+}
+
+// TitleInfo is what a request records about a TMDB title
+type TitleInfo struct {
+	Title  string
+	Year   int
+	Poster string
+}
+
+// LookupTitle fetches the name, release year and poster of a TMDB movie or
+// tv show
+func (s *TMDBService) LookupTitle(ctx context.Context, mediaType string, tmdbID int) (*TitleInfo, error) {
+	details, err := s.request(ctx, fmt.Sprintf("%s/%d", mediaType, tmdbID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TitleInfo{Title: getString(details, "title")}
+	date := getString(details, "release_date")
+	if mediaType == "tv" {
+		info.Title = getString(details, "name")
+		date = getString(details, "first_air_date")
+	}
+	info.Year = ParseYear(date)
+	if p, ok := details["poster_path"].(string); ok {
+		info.Poster = tmdbImageURL + "/w500" + p
+	}
+	if info.Title == "" {
+		return nil, fmt.Errorf("TMDB returned no title for %s %d", mediaType, tmdbID)
+	}
+	return info, nil
+}
+
+// FindByIMDbID returns the TMDB id and media type of the title with the
+// given IMDb id, or 0 when TMDB doesn't know it. mediaType ("movie" or "tv")
+// limits the match to that type, an empty one takes either, movies first.
+func (s *TMDBService) FindByIMDbID(ctx context.Context, imdbID, mediaType string) (int, string, error) {
+	result, err := s.request(ctx, "find/"+url.PathEscape(imdbID), map[string]string{"external_source": "imdb_id"})
+	if err != nil {
+		return 0, "", err
+	}
+
+	types := []string{"movie", "tv"}
+	if mediaType != "" {
+		types = []string{mediaType}
+	}
+	for _, t := range types {
+		matches, _ := result[t+"_results"].([]interface{})
+		if len(matches) == 0 {
+			continue
+		}
+		match, _ := matches[0].(map[string]interface{})
+		if id := getInt(match, "id"); id > 0 {
+			return id, t, nil
+		}
+	}
+	return 0, "", nil
+}
 
-			items[idx] = MediaItem{
-				TmdbID:        tmdbID,
-				TvdbID:        tvdbID,
-				ImdbID:        imdbID,
-				Title:         getString(show, "name"),
-				Year:          year,
-				Overview:      getString(show, "overview"),
-				Rating:        rating,
-				VoteCount:     getInt(show, "vote_count"),
-				Poster:        posterPath,
-				Fanart:        backdropPath,
-				RequestStatus: status,
-				Source:        "tmdb",
+// ExternalIDs are a TMDB title's ids on other services
+type ExternalIDs struct {
+	TvdbID int
+	ImdbID string
+}
+
+// ResolveExternalIDs looks up the external ids of TMDB titles of mediaType
+// ("movie" or "tv"). Cached ids are used as is, the rest are fetched with at
+// most tmdb_concurrency requests in flight. Titles whose lookup failed are
+// left out of the result.
+func (s *TMDBService) ResolveExternalIDs(ctx context.Context, tmdbIDs []int, mediaType string) map[int]ExternalIDs {
+	resolved := make(map[int]ExternalIDs, len(tmdbIDs))
+	var missing []int
+	for _, tmdbID := range tmdbIDs {
+		if ids, ok := s.cachedExternalIDs(tmdbID, mediaType); ok {
+			resolved[tmdbID] = ids
+		} else {
+			missing = append(missing, tmdbID)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.concurrency())
+	for _, tmdbID := range missing {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(tmdbID int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ids, err := s.fetchExternalIDs(ctx, tmdbID, mediaType)
+			if err != nil {
+				return
 			}
-		}(i, r.(map[string]interface{}))
+			mu.Lock()
+			resolved[tmdbID] = ids
+			mu.Unlock()
+		}(tmdbID)
 	}
 
 	wg.Wait()
-	return items, totalPages, nil
+	return resolved
 }
 
-func (s *TMDBService) getExistingMovieIDs() (map[int]bool, error) {
-	// Get from Radarr
-	radarrURL := s.db.GetSetting("radarr_url")
-	radarrKey := s.db.GetSetting("radarr_api_key")
-	
-	if radarrURL == "" || radarrKey == "" {
-		return map[int]bool{}, nil
+// GetExternalIDs looks up the external ids of one TMDB title of mediaType,
+// returning the error when TMDB can't be reached
+func (s *TMDBService) GetExternalIDs(ctx context.Context, tmdbID int, mediaType string) (ExternalIDs, error) {
+	if ids, ok := s.cachedExternalIDs(tmdbID, mediaType); ok {
+		return ids, nil
 	}
+	return s.fetchExternalIDs(ctx, tmdbID, mediaType)
+}
 
-	cacheKey := "existing_movies"
-	if cached, found := s.cache.Get(cacheKey); found {
-		return cached.(map[int]bool), nil
+func (s *TMDBService) cachedExternalIDs(tmdbID int, mediaType string) (ExternalIDs, bool) {
+	cached, found := s.cache.Get(fmt.Sprintf("tmdb_%s_%d", mediaType, tmdbID))
+	if !found {
+		return ExternalIDs{}, false
 	}
 
-	req, _ := http.NewRequest("GET", radarrURL+"/api/v3/movie", nil)
-	req.Header.Set("X-Api-Key", radarrKey)
+	// Movies cache the imdb id alone, series both ids
+	switch v := cached.(type) {
+	case string:
+		return ExternalIDs{ImdbID: v}, true
+	case map[string]interface{}:
+		tvdbID, _ := v["tvdb"].(float64)
+		imdbID, _ := v["imdb"].(string)
+		return ExternalIDs{TvdbID: int(tvdbID), ImdbID: imdbID}, true
+	}
+	return ExternalIDs{}, false
+}
 
-	resp, err := s.client.Do(req)
+func (s *TMDBService) fetchExternalIDs(ctx context.Context, tmdbID int, mediaType string) (ExternalIDs, error) {
+	result, err := s.request(ctx, fmt.Sprintf("%s/%d/external_ids", mediaType, tmdbID), nil)
 	if err != nil {
-		return map[int]bool{}, err
+		return ExternalIDs{}, err
 	}
-	defer resp.Body.Close()
 
-	var movies []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&movies); err != nil {
-		return map[int]bool{}, err
+	ids := ExternalIDs{
+		TvdbID: getInt(result, "tvdb_id"),
+		ImdbID: getString(result, "imdb_id"),
 	}
+	s.cacheExternalIDs(tmdbID, mediaType, ids)
+	return ids, nil
+}
 
-	ids := make(map[int]bool)
-	for _, m := range movies {
-		if id, ok := m["tmdbId"].(float64); ok {
-			ids[int(id)] = true
+func (s *TMDBService) cacheExternalIDs(tmdbID int, mediaType string, ids ExternalIDs) {
+	cacheKey := fmt.Sprintf("tmdb_%s_%d", mediaType, tmdbID)
+	if mediaType == "tv" {
+		s.cache.Set(cacheKey, map[string]interface{}{"tvdb": float64(ids.TvdbID), "imdb": ids.ImdbID})
+	} else {
+		s.cache.Set(cacheKey, ids.ImdbID)
+	}
+}
+
+// validResults returns the raw results that are objects with a TMDB id,
+// skipping malformed entries
+func validResults(results []interface{}) []map[string]interface{} {
+	valid := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		if result, ok := r.(map[string]interface{}); ok && getInt(result, "id") > 0 {
+			valid = append(valid, result)
 		}
 	}
+	return valid
+}
 
-	s.cache.SetWithTTL(cacheKey, ids, 2*time.Minute)
-	return ids, nil
+// resultIDs returns the TMDB ids of results
+func resultIDs(results []map[string]interface{}) []int {
+	ids := make([]int, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, getInt(r, "id"))
+	}
+	return ids
 }
 
-func (s *TMDBService) getExistingSeriesIDs() (map[int]bool, error) {
-	// Get from Sonarr
-	sonarrURL := s.db.GetSetting("sonarr_url")
-	sonarrKey := s.db.GetSetting("sonarr_api_key")
-	
-	if sonarrURL == "" || sonarrKey == "" {
-		return map[int]bool{}, nil
+// movieItems converts TMDB movie results, resolving external ids and marking
+// request status
+func (s *TMDBService) movieItems(ctx context.Context, results []interface{}) []MediaItem {
+	// Get existing and requested IDs
+	existingIDs, _ := s.getExistingMovieIDs(ctx)
+	requestedIDs, _ := s.db.GetRequestedIDs("movie")
+	valid := validResults(results)
+	externalIDs := s.ResolveExternalIDs(ctx, resultIDs(valid), "movie")
+
+	items := make([]MediaItem, len(valid))
+	for i, movie := range valid {
+		tmdbID := getInt(movie, "id")
+		ids, resolved := externalIDs[tmdbID]
+
+		status := "available"
+		if existingIDs[tmdbID] {
+			status = "exists"
+		} else if requestedIDs[tmdbID] {
+			status = "requested"
+		}
+
+		var posterPath, backdropPath string
+		if p, ok := movie["poster_path"].(string); ok {
+			posterPath = tmdbImageURL + "/w500" + p
+		}
+		if b, ok := movie["backdrop_path"].(string); ok {
+			backdropPath = tmdbImageURL + "/original" + b
+		}
+
+		year := ParseYear(movie["release_date"])
+
+		rating := 0.0
+		if r, ok := movie["vote_average"].(float64); ok {
+			rating = r
+		}
+
+		items[i] = MediaItem{
+			TmdbID:           tmdbID,
+			ImdbID:           ids.ImdbID,
+			Title:            getString(movie, "title"),
+			Year:             year,
+			Overview:         getString(movie, "overview"),
+			Rating:           rating,
+			VoteCount:        getInt(movie, "vote_count"),
+			Poster:           posterPath,
+			Fanart:           backdropPath,
+			RequestStatus:    status,
+			Source:           "tmdb",
+			EnrichmentFailed: !resolved,
+		}
 	}
 
-	cacheKey := "existing_series"
-	if cached, found := s.cache.Get(cacheKey); found {
-		return cached.(map[int]bool), nil
+	return items
+}
+
+// tvItems converts TMDB tv results, resolving external ids and marking
+// request status
+func (s *TMDBService) tvItems(ctx context.Context, results []interface{}) []MediaItem {
+	// Get existing and requested IDs
+	existingIDs, _ := s.getExistingSeriesIDs(ctx)
+	requestedIDs, _ := s.db.GetRequestedIDs("series")
+	valid := validResults(results)
+	externalIDs := s.ResolveExternalIDs(ctx, resultIDs(valid), "tv")
+
+	items := make([]MediaItem, len(valid))
+	for i, show := range valid {
+		tmdbID := getInt(show, "id")
+		ids, resolved := externalIDs[tmdbID]
+
+		status := "available"
+		if ids.TvdbID > 0 && existingIDs[ids.TvdbID] {
+			status = "exists"
+		} else if ids.TvdbID > 0 && requestedIDs[ids.TvdbID] {
+			status = "requested"
+		}
+
+		var posterPath, backdropPath string
+		if p, ok := show["poster_path"].(string); ok {
+			posterPath = tmdbImageURL + "/w500" + p
+		}
+		if b, ok := show["backdrop_path"].(string); ok {
+			backdropPath = tmdbImageURL + "/original" + b
+		}
+
+		year := ParseYear(show["first_air_date"])
+
+		rating := 0.0
+		if r, ok := show["vote_average"].(float64); ok {
+			rating = r
+		}
+
+		items[i] = MediaItem{
+			TmdbID:           tmdbID,
+			TvdbID:           ids.TvdbID,
+			ImdbID:           ids.ImdbID,
+			Title:            getString(show, "name"),
+			Year:             year,
+			Overview:         getString(show, "overview"),
+			Rating:           rating,
+			VoteCount:        getInt(show, "vote_count"),
+			Poster:           posterPath,
+			Fanart:           backdropPath,
+			RequestStatus:    status,
+			Source:           "tmdb",
+			EnrichmentFailed: !resolved,
+		}
 	}
 
-	req, _ := http.NewRequest("GET", sonarrURL+"/api/v3/series", nil)
-	req.Header.Set("X-Api-Key", sonarrKey)
+	return items
+}
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return map[int]bool{}, err
+// getExistingMovieIDs merges the library ids of Radarr and the optional 4K
+// Radarr, each cached by its service
+func (s *TMDBService) getExistingMovieIDs(ctx context.Context) (map[int]bool, error) {
+	ids := make(map[int]bool)
+	for _, radarr := range []*RadarrService{s.radarr, s.radarr4k} {
+		if !radarr.IsConfigured() {
+			continue
+		}
+
+		movies, err := radarr.ExistingIDs(ctx)
+		if err != nil {
+			return map[int]bool{}, err
+		}
+		for id := range movies {
+			ids[id] = true
+		}
 	}
-	defer resp.Body.Close()
+	return ids, nil
+}
 
-	var series []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&series); err != nil {
-		return map[int]bool{}, err
+func (s *TMDBService) getExistingSeriesIDs(ctx context.Context) (map[int]bool, error) {
+	if !s.sonarr.IsConfigured() {
+		return map[int]bool{}, nil
 	}
+	return s.sonarr.ExistingIDs(ctx)
+}
 
-	ids := make(map[int]bool)
-	for _, s := range series {
-		if id, ok := s["tvdbId"].(float64); ok {
+// addExistingIDs adds the external id field of each library item to ids
+func addExistingIDs(ids map[int]bool, items []map[string]interface{}, key string) {
+	for _, item := range items {
+		if id, ok := item[key].(float64); ok {
 			ids[int(id)] = true
 		}
 	}
+}
 
-	s.cache.SetWithTTL(cacheKey, ids, 2*time.Minute)
-	return ids, nil
+// Ping checks that TMDB is reachable and accepts the API key
+func (s *TMDBService) Ping(ctx context.Context) error {
+	_, err := s.request(ctx, "configuration", nil)
+	return err
+}
+
+// ParseYear reads a year given as a number, a "2006" string or a
+// "2006-01-02" date, returning 0 when there is none
+func ParseYear(v interface{}) int {
+	switch year := v.(type) {
+	case float64:
+		if year > 0 {
+			return int(year)
+		}
+	case int:
+		if year > 0 {
+			return year
+		}
+	case string:
+		if len(year) >= 4 {
+			if y, err := strconv.Atoi(year[:4]); err == nil && y > 0 {
+				return y
+			}
+		}
+	}
+	return 0
 }
 
 func getString(m map[string]interface{}, key string) string {
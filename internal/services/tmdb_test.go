@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IcarusCore/Requestarr/internal/cache"
+)
+
+func TestParseYear(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want int
+	}{
+		{"float", float64(2006), 2006},
+		{"int", 1999, 1999},
+		{"year string", "2006", 2006},
+		{"date string", "2011-04-17", 2011},
+		{"empty string", "", 0},
+		{"short string", "99", 0},
+		{"not a year", "TBA", 0},
+		{"nil", nil, 0},
+		{"zero float", float64(0), 0},
+		{"zero int", 0, 0},
+		{"zero year string", "0000-00-00", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseYear(tt.in); got != tt.want {
+				t.Errorf("ParseYear(%#v) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaItemsOmitUnknownYear(t *testing.T) {
+	db := newTestDB(t, nil)
+	appCache := cache.NewCache(time.Minute, 0, "")
+	tmdb := NewTMDBService(db, appCache, NewSonarrService(db, appCache), NewRadarrService(db, appCache, "radarr"), NewRadarrService(db, appCache, "radarr_4k"))
+
+	tests := []struct {
+		name  string
+		items func([]interface{}) []MediaItem
+		date  string
+	}{
+		{"series", func(r []interface{}) []MediaItem { return tmdb.tvItems(context.Background(), r) }, "first_air_date"},
+		{"movies", func(r []interface{}) []MediaItem { return tmdb.movieItems(context.Background(), r) }, "release_date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := tt.items([]interface{}{
+				map[string]interface{}{"id": float64(1), "name": "Aired", "title": "Aired", tt.date: "2011-04-17"},
+				map[string]interface{}{"id": float64(2), "name": "Not aired", "title": "Not aired", tt.date: ""},
+				map[string]interface{}{"id": float64(3), "name": "No date", "title": "No date"},
+			})
+			if len(items) != 3 {
+				t.Fatalf("got %d items, want 3", len(items))
+			}
+
+			for i, wantYear := range []bool{true, false, false} {
+				data, err := json.Marshal(items[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				var fields map[string]interface{}
+				json.Unmarshal(data, &fields)
+				if _, hasYear := fields["year"]; hasYear != wantYear {
+					t.Errorf("%s: year present = %v, want %v (%s)", items[i].Title, hasYear, wantYear, data)
+				}
+			}
+		})
+	}
+}
+
+func TestVideoLanguages(t *testing.T) {
+	tests := map[string]string{
+		"en-US": "en,null",
+		"en":    "en,null",
+		"de":    "de,en,null",
+		"pt-BR": "pt,en,null",
+	}
+	for language, want := range tests {
+		if got := videoLanguages(language); got != want {
+			t.Errorf("videoLanguages(%q) = %q, want %q", language, got, want)
+		}
+	}
+}
+
+func TestGetDetailCachesOnlyAppendedExternalIDs(t *testing.T) {
+	db := newTestDB(t, nil)
+	appCache := cache.NewCache(time.Minute, 0, "")
+	tmdb := NewTMDBService(db, appCache, NewSonarrService(db, appCache), NewRadarrService(db, appCache, "radarr"), NewRadarrService(db, appCache, "radarr_4k"))
+
+	tests := []struct {
+		name      string
+		tmdbID    int
+		detail    map[string]interface{}
+		wantCache bool
+	}{
+		{"appended", 603, map[string]interface{}{"id": float64(603), "title": "The Matrix", "external_ids": map[string]interface{}{"imdb_id": "tt0133093"}}, true},
+		{"missing", 604, map[string]interface{}{"id": float64(604), "title": "The Matrix Reloaded"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A cached detail keeps GetDetail from calling TMDB
+			appCache.Set(fmt.Sprintf("tmdb_detail_movie_%d_%s", tt.tmdbID, tmdb.Language()), tt.detail)
+			if _, err := tmdb.GetDetail(context.Background(), "movie", tt.tmdbID); err != nil {
+				t.Fatal(err)
+			}
+			if _, cached := tmdb.cachedExternalIDs(tt.tmdbID, "movie"); cached != tt.wantCache {
+				t.Errorf("external ids cached = %v, want %v", cached, tt.wantCache)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IcarusCore/Requestarr/internal/cache"
+	"github.com/IcarusCore/Requestarr/internal/models"
+)
+
+const (
+	traktBaseURL  = "https://api.trakt.tv"
+	traktPageSize = 20
+	traktCacheTTL = 10 * time.Minute
+)
+
+type TraktService struct {
+	db     *models.DB
+	cache  cache.CacheStore
+	tmdb   *TMDBService
+	client *http.Client
+}
+
+type traktMedia struct {
+	Title    string  `json:"title"`
+	Year     int     `json:"year"`
+	Overview string  `json:"overview"`
+	Rating   float64 `json:"rating"`
+	Votes    int     `json:"votes"`
+	Runtime  int     `json:"runtime"`
+	Network  string  `json:"network"`
+	IDs      struct {
+		Tmdb int    `json:"tmdb"`
+		Tvdb int    `json:"tvdb"`
+		Imdb string `json:"imdb"`
+	} `json:"ids"`
+}
+
+// traktItem covers both wrapped list entries (trending, user lists) and the
+// bare media objects returned by the popular endpoints
+type traktItem struct {
+	traktMedia
+	Type  string      `json:"type"`
+	Movie *traktMedia `json:"movie"`
+	Show  *traktMedia `json:"show"`
+}
+
+// traktPage is a cached list page; fields are exported so it can be gob-encoded
+type traktPage struct {
+	Items      []MediaItem
+	TotalPages int
+}
+
+func init() {
+	gob.Register(traktPage{})
+}
+
+func NewTraktService(db *models.DB, cache cache.CacheStore, tmdb *TMDBService) *TraktService {
+	return &TraktService{
+		db:    db,
+		cache: cache,
+		tmdb:  tmdb,
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+// GetList fetches a page of a Trakt list. list is "trending", "popular" or
+// "<username>/<slug>"; mediaType ("movie" or "series") picks which trending or
+// popular list to use, user lists can mix both.
+func (s *TraktService) GetList(ctx context.Context, list, mediaType string, page int) ([]MediaItem, int, error) {
+	clientID := s.db.GetSetting("trakt_client_id")
+	if clientID == "" {
+		return nil, 0, fmt.Errorf("Trakt client ID not configured")
+	}
+
+	var endpoint string
+	switch list {
+	case "trending", "popular":
+		kind := "movies"
+		if mediaType == "series" {
+			kind = "shows"
+		}
+		endpoint = "/" + kind + "/" + list
+	default:
+		user, slug, ok := strings.Cut(list, "/")
+		if !ok || user == "" || slug == "" {
+			return nil, 0, fmt.Errorf("Invalid Trakt list %q", list)
+		}
+		endpoint = "/users/" + url.PathEscape(user) + "/lists/" + url.PathEscape(slug) + "/items"
+	}
+
+	cacheKey := fmt.Sprintf("trakt_%s_%s_%d", list, mediaType, page)
+	var items []MediaItem
+	var totalPages int
+	if cached, found := s.cache.Get(cacheKey); found {
+		p := cached.(traktPage)
+		items, totalPages = p.Items, p.TotalPages
+	} else {
+		var err error
+		items, totalPages, err = s.fetch(ctx, clientID, endpoint, page)
+		if err != nil {
+			return nil, 0, err
+		}
+		s.cache.SetWithTTL(cacheKey, traktPage{items, totalPages}, traktCacheTTL)
+	}
+
+	return s.annotate(ctx, items), totalPages, nil
+}
+
+func (s *TraktService) fetch(ctx context.Context, clientID, endpoint string, page int) ([]MediaItem, int, error) {
+	u := fmt.Sprintf("%s%s?extended=full&page=%d&limit=%d", traktBaseURL, endpoint, page, traktPageSize)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("trakt-api-version", "2")
+	req.Header.Set("trakt-api-key", clientID)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, 0, fmt.Errorf("Trakt returned %d", resp.StatusCode)
+	}
+
+	var raw []traktItem
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, 0, err
+	}
+
+	totalPages, _ := strconv.Atoi(resp.Header.Get("X-Pagination-Page-Count"))
+	if totalPages < page {
+		totalPages = page
+	}
+
+	isShows := strings.HasPrefix(endpoint, "/shows/")
+	items := make([]MediaItem, 0, len(raw))
+	for _, r := range raw {
+		media, isSeries := &r.traktMedia, isShows
+		if r.Movie != nil {
+			media, isSeries = r.Movie, false
+		} else if r.Show != nil {
+			media, isSeries = r.Show, true
+		} else if r.Type != "" {
+			// Seasons, episodes and people can appear in user lists
+			continue
+		}
+
+		item := MediaItem{
+			TmdbID:    media.IDs.Tmdb,
+			ImdbID:    media.IDs.Imdb,
+			Title:     media.Title,
+			Overview:  media.Overview,
+			Rating:    media.Rating,
+			VoteCount: media.Votes,
+			Runtime:   media.Runtime,
+			Network:   media.Network,
+			Source:    "trakt",
+			MediaType: "movie",
+		}
+		item.Year = media.Year
+		if isSeries {
+			item.TvdbID = media.IDs.Tvdb
+			item.MediaType = "series"
+		}
+		items = append(items, item)
+	}
+
+	return items, totalPages, nil
+}
+
+// annotate sets each item's request status, copying so cached pages aren't modified
+func (s *TraktService) annotate(ctx context.Context, cached []MediaItem) []MediaItem {
+	existingMovies, _ := s.tmdb.getExistingMovieIDs(ctx)
+	existingSeries, _ := s.tmdb.getExistingSeriesIDs(ctx)
+	requestedMovies, _ := s.db.GetRequestedIDs("movie")
+	requestedSeries, _ := s.db.GetRequestedIDs("series")
+
+	items := make([]MediaItem, len(cached))
+	for i, item := range cached {
+		item.RequestStatus = "available"
+		if item.MediaType == "series" {
+			if item.TvdbID > 0 && existingSeries[item.TvdbID] {
+				item.RequestStatus = "exists"
+			} else if item.TvdbID > 0 && requestedSeries[item.TvdbID] {
+				item.RequestStatus = "requested"
+			}
+		} else if item.TmdbID > 0 {
+			if existingMovies[item.TmdbID] {
+				item.RequestStatus = "exists"
+			} else if requestedMovies[item.TmdbID] {
+				item.RequestStatus = "requested"
+			}
+		}
+		items[i] = item
+	}
+	return items
+}